@@ -0,0 +1,347 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// adminAuthorized reports whether the request carries the admin token,
+// either as a bearer token or as the Basic auth password.
+func adminAuthorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		_, token, ok = r.BasicAuth()
+	}
+	return ok && config.AdminToken != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) == 1
+}
+
+// requireAdmin wraps an admin handler. Without an admin token the surface
+// doesn't exist; otherwise unauthenticated requests get a Basic auth
+// challenge so browsers can log in with the token as the password.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.AdminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if !adminAuthorized(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="tinypaste admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// quarantineHandler lists the IDs of quarantined pastes, one per line.
+func quarantineHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	walkBuckets(0, 256, func(f pasteFile) {
+		meta, err := readHeader(f.Path)
+		if err == nil && meta.Quarantine {
+			fmt.Fprintln(w, f.ID)
+		}
+	})
+}
+
+// approveHandler releases a quarantined paste. Its creation time is kept so
+// approval doesn't extend its life.
+func approveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.FormValue("id")
+	if !isValidID(id) {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	p, err := loadPaste(id)
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrExpired) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		requestLog(r.Context()).Error("approve: load paste", "id", id, "err", err)
+		internalError(w, r)
+		return
+	}
+	if !p.Quarantined {
+		fmt.Fprintln(w, "not quarantined")
+		return
+	}
+
+	p.Quarantined = false
+	if err := p.save(r.Context()); err != nil {
+		requestLog(r.Context()).Error("approve: save paste", "id", id, "err", err)
+		internalError(w, r)
+		return
+	}
+	if err := store.Chtimes(p.path(), p.Created); err != nil {
+		requestLog(r.Context()).Error("approve: keep creation time", "id", id, "err", err)
+		internalError(w, r)
+		return
+	}
+	fmt.Fprintln(w, "approved")
+}
+
+// statsCacheTTL bounds how often the admin page walks every bucket.
+const statsCacheTTL = time.Minute
+
+// adminStats is what the admin template renders.
+type adminStats struct {
+	Pastes   int
+	Bytes    string
+	Expired  int
+	ByTTL    []ttlCount
+	SyncMode string
+	Computed time.Time
+
+	// Listing holds up to adminListSize pastes in Sort order
+	Sort    string
+	Listing []adminEntry
+
+	live []pasteFile
+}
+
+// adminEntry is one row of the admin listing.
+type adminEntry struct {
+	ID      string
+	Title   string
+	Age     string
+	Expires string
+}
+
+// adminListSize is how many pastes the admin listing shows.
+const adminListSize = 20
+
+// adminSorts orders the admin listing by the sort query parameter:
+// newest or oldest first, or the soonest to expire first.
+var adminSorts = map[string]func(a, b pasteFile) bool{
+	"newest":   func(a, b pasteFile) bool { return a.Created.After(b.Created) },
+	"oldest":   func(a, b pasteFile) bool { return a.Created.Before(b.Created) },
+	"expiring": func(a, b pasteFile) bool { return a.expires().Before(b.expires()) },
+}
+
+type ttlCount struct {
+	TTL   string
+	Count int
+}
+
+var (
+	statsMu    sync.Mutex
+	statsCache *adminStats
+)
+
+// pasteStats summarizes the store, recomputing at most once per
+// statsCacheTTL.
+func pasteStats() *adminStats {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	if statsCache != nil && time.Since(statsCache.Computed) < statsCacheTTL {
+		return statsCache
+	}
+
+	now := time.Now()
+	stats := &adminStats{Computed: now, SyncMode: config.SyncMode}
+	var bytes int64
+	counts := make(map[string]int)
+	var live []pasteFile
+	walkBuckets(0, 256, func(f pasteFile) {
+		if f.expired(now) {
+			stats.Expired++
+			return
+		}
+		stats.Pastes++
+		bytes += f.Size
+		counts[f.TTL]++
+		live = append(live, f)
+	})
+	stats.Bytes = humanSize(bytes)
+
+	for ttl, count := range counts {
+		stats.ByTTL = append(stats.ByTTL, ttlCount{TTL: ttl, Count: count})
+	}
+	sort.Slice(stats.ByTTL, func(i, j int) bool { return TTLHours[stats.ByTTL[i].TTL] < TTLHours[stats.ByTTL[j].TTL] })
+	stats.live = live
+
+	statsCache = stats
+	return stats
+}
+
+// adminListing returns the first adminListSize of live in the given order.
+// live is left as it is, since it belongs to the cached stats.
+func adminListing(live []pasteFile, order string) []adminEntry {
+	less := adminSorts[order]
+	sorted := slices.Clone(live)
+	sort.Slice(sorted, func(i, j int) bool { return less(sorted[i], sorted[j]) })
+
+	now := time.Now()
+	var entries []adminEntry
+	for _, f := range sorted[:min(len(sorted), adminListSize)] {
+		meta, err := readHeader(f.Path)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, adminEntry{
+			ID:      f.ID,
+			Title:   meta.Title,
+			Age:     formatAge(defaultLang, now.Sub(f.Created)),
+			Expires: formatLeft(f.expires().Sub(now)),
+		})
+	}
+	return entries
+}
+
+// formatLeft renders the time until expiry, e.g. "in 3h".
+func formatLeft(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "any moment"
+	case d < time.Hour:
+		return fmt.Sprintf("in %dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("in %dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("in %dd", int(d.Hours()/24))
+}
+
+// humanSize renders a byte count rounded to one decimal, e.g. "3.2 MB".
+func humanSize(n int64) string {
+	switch {
+	case n >= 1024*1024*1024:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1024*1024*1024))
+	case n >= 1024*1024:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+	case n >= 1024:
+		return fmt.Sprintf("%.1f KB", float64(n)/1024)
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+// adminHandler renders the stats dashboard. The sort query parameter
+// picks the order of the listing, newest first by default.
+func adminHandler(w http.ResponseWriter, r *http.Request) {
+	order := r.URL.Query().Get("sort")
+	if order == "" {
+		order = "newest"
+	}
+	if adminSorts[order] == nil {
+		http.Error(w, "Invalid sort: want newest, oldest or expiring", http.StatusBadRequest)
+		return
+	}
+	stats := *pasteStats()
+	stats.Sort = order
+	stats.Listing = adminListing(stats.live, order)
+	renderTemplate(w, r, "admin", stats)
+}
+
+// deletePaste removes every file stored for id without parsing it, so
+// pastes with corrupt metadata can be removed too. It reports whether
+// anything was there.
+func deletePaste(id string) (bool, error) {
+	files, err := store.Glob(bucket(id) + "/" + id + "_*.txt")
+	if err != nil || len(files) == 0 {
+		return false, err
+	}
+	for _, file := range files {
+		if err := removePaste(file); err != nil {
+			return true, err
+		}
+	}
+
+	// Drop cached listings that may still show the paste
+	recentMu.Lock()
+	recentAt = time.Time{}
+	recentMu.Unlock()
+	statsMu.Lock()
+	statsCache = nil
+	statsMu.Unlock()
+	return true, nil
+}
+
+// operatorAction is one line of the operator action log.
+type operatorAction struct {
+	Action    string    `json:"action"`
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	Reason    string    `json:"reason,omitempty"`
+	Requester string    `json:"requester,omitempty"`
+}
+
+var actionMu sync.Mutex
+
+// logAction appends a to the operator action log, stamped with the
+// current time.
+func logAction(a operatorAction) {
+	a.Time = time.Now().UTC()
+	line, err := json.Marshal(a)
+	if err != nil {
+		return
+	}
+	actionMu.Lock()
+	defer actionMu.Unlock()
+	f, err := os.OpenFile(config.ActionLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		slog.Error("open action log", "err", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		slog.Error("write action log", "err", err)
+	}
+}
+
+// adminDeleteHandler serves DELETE /admin/pastes/{id} for scripts and
+// POST /admin/pastes with an id field for the dashboard form. Both take an
+// optional reason.
+func adminDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	var id string
+	switch {
+	case r.Method == http.MethodDelete:
+		id = strings.TrimPrefix(r.URL.Path, "/admin/pastes/")
+	case r.Method == http.MethodPost && r.URL.Path == "/admin/pastes":
+		id = strings.TrimSpace(r.FormValue("id"))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isValidID(id) {
+		http.NotFound(w, r)
+		return
+	}
+	reason := r.FormValue("reason")
+
+	found, err := deletePaste(id)
+	if err != nil {
+		requestLog(r.Context()).Error("admin delete", "id", id, "err", err)
+		internalError(w, r)
+		return
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	if err := writeTombstone(id, removedByOperator, time.Now()); err != nil {
+		requestLog(r.Context()).Error("write tombstone", "id", id, "err", err)
+	}
+	logAction(operatorAction{Action: "delete", ID: id, Reason: reason})
+	slog.Info("operator deleted paste", "id", id)
+
+	if r.Method == http.MethodPost {
+		http.Redirect(w, r, sitePath("/admin"), http.StatusSeeOther)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
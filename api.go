@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// pasteJSON is a paste as served to clients asking for application/json.
+type pasteJSON struct {
+	ID           string   `json:"id"`
+	Title        string   `json:"title"`
+	Body         string   `json:"body"`
+	BodyEncoding string   `json:"body_encoding,omitempty"`
+	TTL          string   `json:"ttl"`
+	CreatedAt    string   `json:"created_at"`
+	ExpiresAt    string   `json:"expires_at"`
+	Size         int      `json:"size"`
+	SHA256       string   `json:"sha256"`
+	Language     string   `json:"language,omitempty"`
+	Filename     string   `json:"filename,omitempty"`
+	Cipher       string   `json:"cipher,omitempty"`
+	Compression  string   `json:"compression,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	Comments     *int     `json:"comments,omitempty"`
+	URL          string   `json:"url"`
+	RawURL       string   `json:"raw_url"`
+}
+
+// writeJSON sends v as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// servePasteJSON answers a JSON fetch of a paste.
+func servePasteJSON(w http.ResponseWriter, r *http.Request, p *Paste) {
+	body, encoding := string(p.Body), ""
+	if p.Opaque() {
+		body, encoding = base64.StdEncoding.EncodeToString(p.Body), "base64"
+	}
+	// Pastes that can't take comments leave the count out
+	var comments *int
+	if p.commentsOpen() {
+		n := commentCount(p.ID)
+		comments = &n
+	}
+	writeJSON(w, http.StatusOK, pasteJSON{
+		ID:           p.ID,
+		Title:        p.Title,
+		Body:         body,
+		BodyEncoding: encoding,
+		TTL:          p.TTL,
+		CreatedAt:    p.Created.UTC().Truncate(time.Second).Format(time.RFC3339),
+		ExpiresAt:    p.Expires().UTC().Truncate(time.Second).Format(time.RFC3339),
+		Size:         len(p.Body),
+		SHA256:       p.SHA256,
+		Language:     p.Language,
+		Filename:     p.Filename,
+		Cipher:       p.Cipher,
+		Compression:  p.Compression,
+		Tags:         p.Tags,
+		Comments:     comments,
+		URL:          absoluteURL(r, p.URLPath()),
+		RawURL:       absoluteURL(r, p.URLPath()+"/raw"),
+	})
+}
+
+// pasteErrorJSON answers a JSON fetch of a paste that can't be served:
+// 451 for takedowns, 410 with "expired" or "deleted" for pastes that
+// existed, and 404 for everything else, including held pastes.
+func pasteErrorJSON(w http.ResponseWriter, id string, err error) {
+	if err == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return
+	}
+	if loadTakedown(id) != nil {
+		writeJSON(w, http.StatusUnavailableForLegalReasons, map[string]string{"error": "removed for legal reasons"})
+		return
+	}
+	t := loadTombstone(id)
+	switch {
+	case errors.Is(err, ErrExpired), t != nil && t.Reason == removedExpired:
+		writeJSON(w, http.StatusGone, map[string]string{"error": "expired"})
+	case t != nil:
+		writeJSON(w, http.StatusGone, map[string]string{"error": "deleted"})
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+}
+
+// instanceConfig is the policy served at /api/config, so clients can check
+// a paste before submitting it.
+type instanceConfig struct {
+	TTLs           []string        `json:"ttls"`
+	DefaultTTL     string          `json:"default_ttl"`
+	TTLPolicy      []ttlPolicyJSON `json:"ttl_policy,omitempty"`
+	MaxBodySize    int             `json:"max_body_size"`
+	MaxTitleLength int             `json:"max_title_length"`
+	MaxLines       int             `json:"max_lines"`
+	MaxLineLength  int             `json:"max_line_length"`
+	TitleOptional  bool            `json:"title_optional"`
+	Features       map[string]bool `json:"features"`
+}
+
+// ttlPolicyJSON is one TTL_POLICY rule: bodies up to MaxSize bytes may
+// live at most MaxTTL.
+type ttlPolicyJSON struct {
+	MaxSize int    `json:"max_size"`
+	MaxTTL  string `json:"max_ttl"`
+}
+
+// configJSON renders instanceConfig once; the config doesn't change while
+// the server runs. It returns the body and its ETag.
+var configJSON = sync.OnceValues(func() ([]byte, string) {
+	c := instanceConfig{
+		TTLs:           ttlOptions,
+		DefaultTTL:     config.DefaultTTL,
+		MaxBodySize:    config.MaxBodySize,
+		MaxTitleLength: config.MaxTitleLength,
+		MaxLines:       config.MaxLines,
+		MaxLineLength:  config.MaxLineLength,
+		TitleOptional:  config.TitleOptional,
+		Features: map[string]bool{
+			"encryption":    true,
+			"highlighting":  true,
+			"markdown":      false,
+			"private":       true,
+			"recent":        config.RecentEnabled,
+			"source_url":    config.SourceURLEnabled,
+			"tags":          config.TagsEnabled,
+			"read_only":     config.Upstream != "",
+			"proof_of_work": config.PowDifficulty > 0,
+		},
+	}
+	for _, rule := range config.TTLPolicy {
+		c.TTLPolicy = append(c.TTLPolicy, ttlPolicyJSON{MaxSize: rule.MaxBytes, MaxTTL: rule.TTL})
+	}
+	body, _ := json.Marshal(c)
+	sum := sha256.Sum256(body)
+	return body, `"` + hex.EncodeToString(sum[:8]) + `"`
+})
+
+// configHandler serves GET /api/config. The answer only changes on a
+// restart, so it may be cached for a few minutes and revalidated by ETag.
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, etag := configJSON()
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(body, '\n'))
+}
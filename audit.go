@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditEntry is one line of the creation audit log. The client IP is only
+// kept as an HMAC so reports can be correlated without retaining it.
+type auditEntry struct {
+	ID     string    `json:"id"`
+	Time   time.Time `json:"time"`
+	Size   int       `json:"size"`
+	IPHMAC string    `json:"ip_hmac"`
+}
+
+var auditMu sync.Mutex
+
+// hashIP keys an HMAC over the canonical form of ip.
+func hashIP(key, ip string) string {
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		ip = addr.Unmap().String()
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// auditCreate appends a creation record. It does nothing unless an audit
+// key is configured.
+func auditCreate(p *Paste, ip string) {
+	if config.AuditKey == "" {
+		return
+	}
+	line, err := json.Marshal(auditEntry{
+		ID:     p.ID,
+		Time:   time.Now().UTC(),
+		Size:   len(p.Body),
+		IPHMAC: hashIP(config.AuditKey, ip),
+	})
+	if err != nil {
+		return
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	rotateAudit()
+	f, err := os.OpenFile(config.AuditFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		slog.Error("open audit log", "err", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		slog.Error("write audit log", "err", err)
+	}
+}
+
+// rotateAudit shifts the audit log to .1, .2, ... once it reaches the size
+// limit, dropping anything past the configured number of old files.
+// Callers hold auditMu.
+func rotateAudit() {
+	info, err := os.Stat(config.AuditFile)
+	if err != nil || info.Size() < int64(config.AuditMaxSize) {
+		return
+	}
+	if config.AuditKeep <= 0 {
+		removeFile(config.AuditFile)
+		return
+	}
+	removeFile(fmt.Sprintf("%s.%d", config.AuditFile, config.AuditKeep))
+	for i := config.AuditKeep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", config.AuditFile, i), fmt.Sprintf("%s.%d", config.AuditFile, i+1))
+	}
+	if err := os.Rename(config.AuditFile, config.AuditFile+".1"); err != nil {
+		slog.Error("rotate audit log", "err", err)
+	}
+}
+
+// runAudit implements "tinypaste audit --ip 1.2.3.4", printing every paste
+// created from that address that is still in the audit log.
+func runAudit(args []string) {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	ip := fs.String("ip", "", "client IP address to look up")
+	key := fs.String("key", envString("AUDIT_KEY", ""), "audit HMAC key")
+	file := fs.String("file", envString("AUDIT_FILE", "pastes/audit.jsonl"), "audit log file")
+	keep := fs.Int("keep", envInt("AUDIT_KEEP", 5), "number of rotated files to search")
+	fs.Parse(args)
+
+	if *ip == "" || *key == "" {
+		fmt.Fprintln(os.Stderr, "usage: tinypaste audit --ip ADDR (AUDIT_KEY or --key required)")
+		os.Exit(2)
+	}
+	want := hashIP(*key, *ip)
+
+	files := []string{*file}
+	for i := 1; i <= *keep; i++ {
+		files = append(files, fmt.Sprintf("%s.%d", *file, i))
+	}
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var e auditEntry
+			if json.Unmarshal(scanner.Bytes(), &e) == nil && hmac.Equal([]byte(e.IPHMAC), []byte(want)) {
+				fmt.Printf("%s\t%s\t%d\n", e.ID, e.Time.Format(time.RFC3339), e.Size)
+			}
+		}
+		f.Close()
+	}
+}
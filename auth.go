@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// authUsers maps usernames to bcrypt hashes for private-instance mode.
+var authUsers atomic.Pointer[map[string][]byte]
+
+// dummyHash is compared against for unknown users so a lookup miss takes
+// as long as a wrong password.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("tinypaste"), bcrypt.DefaultCost)
+	return hash
+})
+
+// loadAuthFile reads "username:bcrypt-hash" lines. Blank lines and lines
+// starting with # are skipped.
+func loadAuthFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	users := make(map[string][]byte)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(entry, ":")
+		if !ok || user == "" {
+			return fmt.Errorf("%s:%d: want username:bcrypt-hash", path, line)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		users[user] = []byte(hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		return fmt.Errorf("%s: no users", path)
+	}
+
+	authUsers.Store(&users)
+	slog.Info("loaded auth file", "file", path, "users", len(users))
+	return nil
+}
+
+// checkUser verifies Basic auth credentials against the auth file.
+func checkUser(user, password string) bool {
+	users := authUsers.Load()
+	if users == nil {
+		return false
+	}
+	hash, found := (*users)[user]
+	if !found {
+		hash = dummyHash()
+	}
+	ok := bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+	return found && ok
+}
+
+// requireLogin puts the whole site behind Basic auth when an auth file is
+// configured. It runs before routing, so unauthenticated requests get the
+// same 401 whether or not a paste exists. API and admin token holders pass.
+func requireLogin(next http.Handler) http.Handler {
+	if config.AuthFile == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.AuthExemptHealthz && r.URL.Path == "/healthz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Upload URLs carry their own authorization
+		if strings.HasPrefix(r.URL.Path, "/u/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if user, password, ok := r.BasicAuth(); ok && checkUser(user, password) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if apiClient(r) || adminAuthorized(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="tinypaste", charset="UTF-8"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
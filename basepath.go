@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// sitePath turns a path the handlers route on, such as /p/<id>, into the
+// one visitors use, under BASE_PATH.
+func sitePath(path string) string {
+	return config.BasePath + path
+}
+
+// validBasePath accepts BASE_PATH values such as /paste or /tools/paste:
+// no trailing slash, no dot segments, and nothing that needs escaping.
+func validBasePath(p string) bool {
+	if !strings.HasPrefix(p, "/") || strings.HasSuffix(p, "/") {
+		return false
+	}
+	for seg := range strings.SplitSeq(p[1:], "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return false
+		}
+	}
+	for _, c := range p {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', strings.ContainsRune("/-._~", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// stripBasePath removes BASE_PATH from request paths, so handlers route
+// as if mounted at the root. Requests outside it get a 404, unless
+// BASE_PATH_OPTIONAL lets them through as they are, for clients that
+// reach the server without the proxy.
+func stripBasePath(next http.Handler) http.Handler {
+	if config.BasePath == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, config.BasePath)
+		if !ok || (rest != "" && rest[0] != '/') {
+			if config.BasePathOptional {
+				next.ServeHTTP(w, r)
+				return
+			}
+			http.NotFound(w, r)
+			return
+		}
+		if rest == "" {
+			rest = "/"
+		}
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = rest
+		if r.URL.RawPath != "" {
+			r2.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, config.BasePath)
+		}
+		next.ServeHTTP(w, r2)
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// siteLinks returns the URLs a response points to: in href, src, action
+// and formaction attributes, and in the Location and Link headers.
+func siteLinks(w *httptest.ResponseRecorder) []string {
+	var links []string
+	attr := regexp.MustCompile(`\b(?:href|src|action|formaction)="([^"]*)"`)
+	for _, m := range attr.FindAllStringSubmatch(w.Body.String(), -1) {
+		links = append(links, strings.ReplaceAll(m[1], "&amp;", "&"))
+	}
+	if loc := w.Header().Get("Location"); loc != "" {
+		links = append(links, loc)
+	}
+	link := regexp.MustCompile(`<([^>]*)>`)
+	for _, h := range w.Header().Values("Link") {
+		for _, m := range link.FindAllStringSubmatch(h, -1) {
+			links = append(links, m[1])
+		}
+	}
+	return links
+}
+
+// Under BASE_PATH every page, redirect and API response must point into
+// it. The crawl starts at the front page and a paste of each kind, checks
+// every link it finds and follows those on the site.
+func TestBasePathCrawl(t *testing.T) {
+	useMemStore(t)
+	captureLog(t)
+	config.BasePath = "/paste"
+	config.TagsEnabled = true
+	config.RecentEnabled = true
+	config.HistoryEnabled = true
+	config.CommentsEnabled = true
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+	site := stripBasePath(mux)
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		site.ServeHTTP(w, r)
+		return w
+	}
+
+	// Create a public tagged paste from the form, and a private one through
+	// the API, whose URL must be under the base path too
+	form := url.Values{"title": {"crawl"}, "body": {"hello\n"}, "ttl": {"1h"}, "tags": {"go"},
+		"public": {"1"}, "comments": {"1"}, "form_token": {formToken()}}
+	r := httptest.NewRequest(http.MethodPost, "/paste/save", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	created := serve(r)
+	if created.Code >= 400 {
+		t.Fatalf("form save: status %d: %s", created.Code, created.Body)
+	}
+	form = url.Values{"title": {"private"}, "body": {"secret\n"}, "ttl": {"1h"}, "private": {"1"}}
+	r = httptest.NewRequest(http.MethodPost, "/paste/save", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Accept", "application/json")
+	api := serve(r)
+	var resp struct{ URL string }
+	if err := json.Unmarshal(api.Body.Bytes(), &resp); err != nil || api.Code != http.StatusCreated {
+		t.Fatalf("API save: status %d: %s", api.Code, api.Body)
+	}
+
+	cookies := created.Result().Cookies()
+	queue := []string{"/paste/", "/paste/about", "/paste/mine", "/paste/tags", "/paste/recent"}
+	queue = append(queue, siteLinks(created)...)
+	queue = append(queue, resp.URL)
+	seen := make(map[string]bool)
+	for len(queue) > 0 && len(seen) < 300 {
+		link := queue[0]
+		queue = queue[1:]
+		u, err := url.Parse(link)
+		if err != nil {
+			t.Errorf("bad link %q", link)
+			continue
+		}
+		if u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https" || u.Path == "" && u.Host == "" {
+			continue // mailto:, javascript:, #fragments and the like
+		}
+		if u.Host != "" && u.Host != "example.com" {
+			continue
+		}
+		if u.Path != "/paste" && !strings.HasPrefix(u.Path, "/paste/") {
+			t.Errorf("link %q is outside the base path", link)
+			continue
+		}
+		if seen[u.RequestURI()] {
+			continue
+		}
+		seen[u.RequestURI()] = true
+
+		r := httptest.NewRequest(http.MethodGet, u.RequestURI(), nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := serve(r)
+		if w.Code == http.StatusNotFound {
+			t.Errorf("link %q: 404", link)
+		}
+		for _, next := range siteLinks(w) {
+			if next, err := u.Parse(next); err == nil {
+				queue = append(queue, next.String())
+			}
+		}
+	}
+	if len(seen) < 10 {
+		t.Errorf("crawled only %d pages: %v", len(seen), seen)
+	}
+
+	// Outside the base path nothing is served, unless that is allowed
+	if w := serve(httptest.NewRequest(http.MethodGet, "/about", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("/about without the base path: status %d, want 404", w.Code)
+	}
+	config.BasePathOptional = true
+	site = stripBasePath(mux)
+	if w := serve(httptest.NewRequest(http.MethodGet, "/about", nil)); w.Code != http.StatusOK {
+		t.Errorf("/about with BASE_PATH_OPTIONAL: status %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// With dedup enabled, bodies live once in pastes/blobs/<hh>/<sha256>.txt
+// next to a <sha256>.ref file holding the number of pastes that point at
+// the blob. Paste files then carry only the header with the blob hash.
+
+var blobMu sync.Mutex
+
+func blobPath(sum string) string {
+	return filepath.Join("pastes", "blobs", sum[:2], sum+".txt")
+}
+
+func blobRefPath(sum string) string {
+	return filepath.Join("pastes", "blobs", sum[:2], sum+".ref")
+}
+
+// validBlob reports whether sum looks like a hex SHA-256, so a corrupt
+// header can't point outside the blob store.
+func validBlob(sum string) bool {
+	if len(sum) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(sum)
+	return err == nil && strings.ToLower(sum) == sum
+}
+
+func readRefs(sum string) int {
+	data, err := os.ReadFile(blobRefPath(sum))
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return n
+}
+
+func writeRefs(sum string, n int) error {
+	tmp := blobRefPath(sum) + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(n)), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, blobRefPath(sum))
+}
+
+// acquireBlob stores body in the blob store if it isn't there yet and adds
+// a reference to it, returning the blob hash.
+func acquireBlob(body []byte) (string, error) {
+	sum := bodyHash(body)
+
+	blobMu.Lock()
+	defer blobMu.Unlock()
+
+	refs := readRefs(sum)
+	if refs == 0 {
+		os.MkdirAll(filepath.Dir(blobPath(sum)), 0755)
+		file, err := os.OpenFile(blobPath(sum), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return "", err
+		}
+		_, err = file.Write(body)
+		if err == nil && config.SyncMode == syncAlways {
+			err = file.Sync()
+		}
+		file.Close()
+		if err == nil {
+			err = syncEntry(blobPath(sum))
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if err := writeRefs(sum, refs+1); err != nil {
+		return "", err
+	}
+	return sum, nil
+}
+
+// releaseBlob drops a reference, deleting the blob with its last one.
+func releaseBlob(sum string) {
+	if !validBlob(sum) {
+		return
+	}
+	blobMu.Lock()
+	defer blobMu.Unlock()
+
+	refs := readRefs(sum) - 1
+	if refs > 0 {
+		if err := writeRefs(sum, refs); err != nil {
+			slog.Error("update blob refs", "blob", sum, "err", err)
+		}
+		return
+	}
+	removeFile(blobPath(sum))
+	removeFile(blobRefPath(sum))
+}
+
+// readBlob returns the body stored under sum.
+func readBlob(sum string) ([]byte, error) {
+	if !validBlob(sum) {
+		return nil, fmt.Errorf("invalid paste metadata")
+	}
+	return os.ReadFile(blobPath(sum))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// blockRule is one compiled line of the blocklist file.
+type blockRule struct {
+	re     *regexp.Regexp
+	metric string
+}
+
+var blockRules atomic.Pointer[[]blockRule]
+
+// loadBlocklist compiles the blocklist file, one regex per line. Blank lines
+// and lines starting with # are skipped. Every bad line is logged and the
+// first error is returned, in which case the current rules stay in place.
+func loadBlocklist(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var rules []blockRule
+	var firstErr error
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		pattern := strings.TrimSpace(scanner.Text())
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			slog.Error("invalid blocklist pattern", "file", path, "line", line, "err", err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s:%d: %v", path, line, err)
+			}
+			continue
+		}
+		rules = append(rules, blockRule{
+			re:     re,
+			metric: fmt.Sprintf(`blocklist_matches_total{pattern="%s"}`, promEscape(pattern)),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+
+	blockRules.Store(&rules)
+	slog.Info("loaded blocklist", "file", path, "patterns", len(rules))
+	return nil
+}
+
+// promEscape escapes a Prometheus label value.
+func promEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// blocked reports whether the title or the scanned prefix of the body
+// matches a blocklist rule, counting the first rule that fires.
+func blocked(title, body string) bool {
+	rules := blockRules.Load()
+	if rules == nil {
+		return false
+	}
+	if len(body) > config.BlocklistScanBytes {
+		body = body[:config.BlocklistScanBytes]
+	}
+	for _, rule := range *rules {
+		if rule.re.MatchString(title) || rule.re.MatchString(body) {
+			metrics.Inc(rule.metric)
+			return true
+		}
+	}
+	return false
+}
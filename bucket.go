@@ -0,0 +1,70 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+// rateLimit describes a token bucket: it holds at most burst tokens and
+// refills at perSecond.
+type rateLimit struct {
+	burst     float64
+	perSecond float64
+}
+
+// tokenBucket is the mutable state of one bucket. A zero bucket is full.
+// The fields are exported so buckets can be persisted as JSON.
+type tokenBucket struct {
+	Tokens float64   `json:"tokens"`
+	Last   time.Time `json:"last"`
+}
+
+func (l rateLimit) refill(b *tokenBucket, now time.Time) {
+	if b.Last.IsZero() {
+		b.Tokens = l.burst
+	} else if elapsed := now.Sub(b.Last).Seconds(); elapsed > 0 {
+		b.Tokens = math.Min(l.burst, b.Tokens+elapsed*l.perSecond)
+	}
+	b.Last = now
+}
+
+// wait returns how long until n tokens are available, zero if they are
+// available now.
+func (l rateLimit) wait(b *tokenBucket, now time.Time, n float64) time.Duration {
+	l.refill(b, now)
+	if b.Tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.Tokens) / l.perSecond * float64(time.Second))
+}
+
+// take removes n tokens. Callers check wait first.
+func (l rateLimit) take(b *tokenBucket, n float64) {
+	b.Tokens -= n
+}
+
+// give puts back n tokens taken for something that didn't happen, up to
+// burst.
+func (l rateLimit) give(b *tokenBucket, n float64) {
+	b.Tokens = math.Min(l.burst, b.Tokens+n)
+}
+
+// full reports whether the bucket has refilled completely, at which point
+// it carries no state worth keeping.
+func (l rateLimit) full(b *tokenBucket, now time.Time) bool {
+	l.refill(b, now)
+	return b.Tokens >= l.burst
+}
+
+// untilFull returns how long the bucket takes to refill completely.
+func (l rateLimit) untilFull(b *tokenBucket, now time.Time) time.Duration {
+	l.refill(b, now)
+	return time.Duration((l.burst - b.Tokens) / l.perSecond * float64(time.Second))
+}
+
+// retryAfter formats d for a Retry-After header: whole seconds, rounded
+// up so a client waiting that long finds the bucket refilled.
+func retryAfter(d time.Duration) string {
+	return strconv.Itoa(max(1, int(math.Ceil(d.Seconds()))))
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBucketRefillBoundary(t *testing.T) {
+	// One token every 10 seconds, up to 3
+	l := rateLimit{burst: 3, perSecond: 0.1}
+	start := time.Unix(1_700_000_000, 0)
+
+	var b tokenBucket
+	if wait := l.wait(&b, start, 1); wait != 0 {
+		t.Fatalf("new bucket: wait %v, want 0", wait)
+	}
+	for range 3 {
+		if wait := l.wait(&b, start, 1); wait != 0 {
+			t.Fatalf("within burst: wait %v, want 0", wait)
+		}
+		l.take(&b, 1)
+	}
+
+	tests := []struct {
+		elapsed time.Duration
+		want    time.Duration
+	}{
+		{0, 10 * time.Second},
+		{time.Second, 9 * time.Second},
+		{9*time.Second + 999*time.Millisecond, time.Millisecond},
+		{10 * time.Second, 0},
+		{10*time.Second + time.Millisecond, 0},
+	}
+	for _, tt := range tests {
+		c := b
+		if got := l.wait(&c, start.Add(tt.elapsed), 1); (got - tt.want).Abs() > time.Microsecond {
+			t.Errorf("after %v: wait %v, want %v", tt.elapsed, got, tt.want)
+		}
+	}
+
+	// Refilling never goes past the burst
+	c := b
+	if !l.full(&c, start.Add(time.Hour)) || c.Tokens != l.burst {
+		t.Errorf("after an hour: %v tokens, want %v", c.Tokens, l.burst)
+	}
+	c = b
+	if got := l.untilFull(&c, start); (got - 30*time.Second).Abs() > time.Microsecond {
+		t.Errorf("untilFull when empty = %v, want 30s", got)
+	}
+	c = b
+	if got := l.untilFull(&c, start.Add(30*time.Second)); got != 0 {
+		t.Errorf("untilFull once refilled = %v, want 0", got)
+	}
+
+	// Time going backwards doesn't add tokens
+	c = b
+	l.refill(&c, start.Add(-time.Minute))
+	if c.Tokens != 0 {
+		t.Errorf("clock stepped back: %v tokens, want 0", c.Tokens)
+	}
+}
+
+func TestBucketGive(t *testing.T) {
+	l := rateLimit{burst: 2, perSecond: 1}
+	now := time.Now()
+	var b tokenBucket
+	l.wait(&b, now, 1)
+	l.take(&b, 1)
+	l.give(&b, 1)
+	l.give(&b, 1)
+	if b.Tokens != l.burst {
+		t.Errorf("after giving back more than was taken: %v tokens, want %v", b.Tokens, l.burst)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "1"},
+		{time.Millisecond, "1"},
+		{time.Second, "1"},
+		{time.Second + time.Nanosecond, "2"},
+		{90 * time.Second, "90"},
+		{24 * time.Hour, "86400"},
+	}
+	for _, tt := range tests {
+		if got := retryAfter(tt.d); got != tt.want {
+			t.Errorf("retryAfter(%v) = %s, want %s", tt.d, got, tt.want)
+		}
+	}
+}
+
+// The headers must add up: waiting X-RateLimit-Reset seconds refills the
+// bucket, and Retry-After on a 429 is enough for the next paste.
+func TestRateLimitHeaders(t *testing.T) {
+	useMemStore(t)
+	config.QuotaCount = 2
+	quotaMu.Lock()
+	quotas = make(map[string]*quotaState)
+	quotaMu.Unlock()
+
+	h := withRateLimitHeaders(saveHandler)
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/save", nil)
+		r.Form = map[string][]string{"title": {"t"}, "body": {body}, "ttl": {"1h"}}
+		r.Header.Set("Accept", "application/json")
+		h(w, r)
+		return w
+	}
+
+	day := 24 * 60 * 60
+	tests := []struct {
+		body         string
+		status       int
+		remaining    int
+		resetAtLeast int
+		retryAtLeast int
+	}{
+		{"", http.StatusBadRequest, 2, 0, 0},
+		{"one", http.StatusCreated, 1, day/2 - 1, 0},
+		{"two", http.StatusCreated, 0, day - 1, 0},
+		{"three", http.StatusTooManyRequests, 0, day - 1, day/2 - 1},
+	}
+	for _, tt := range tests {
+		w := post(tt.body)
+		if w.Code != tt.status {
+			t.Fatalf("body %q: status %d, want %d: %s", tt.body, w.Code, tt.status, w.Body)
+		}
+		if got := w.Header().Get("X-RateLimit-Limit"); got != "2" {
+			t.Errorf("body %q: X-RateLimit-Limit = %q, want 2", tt.body, got)
+		}
+		if got := w.Header().Get("X-RateLimit-Remaining"); got != strconv.Itoa(tt.remaining) {
+			t.Errorf("body %q: X-RateLimit-Remaining = %q, want %d", tt.body, got, tt.remaining)
+		}
+		reset, _ := strconv.Atoi(w.Header().Get("X-RateLimit-Reset"))
+		if reset < tt.resetAtLeast || reset > day {
+			t.Errorf("body %q: X-RateLimit-Reset = %d, want %d to %d", tt.body, reset, tt.resetAtLeast, day)
+		}
+		retry, _ := strconv.Atoi(w.Header().Get("Retry-After"))
+		if retry < tt.retryAtLeast || retry > reset {
+			t.Errorf("body %q: Retry-After = %d, want %d to %d", tt.body, retry, tt.retryAtLeast, reset)
+		}
+	}
+}
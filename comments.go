@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// comment is one entry of a paste's thread, a line of its comments file.
+type comment struct {
+	Author  string    `json:"author,omitempty"`
+	Text    string    `json:"text"`
+	Created time.Time `json:"created"`
+}
+
+const (
+	// maxCommentAuthor caps the optional author name, in bytes
+	maxCommentAuthor = 40
+
+	// maxComments caps a thread, keeping its file small
+	maxComments = 100
+)
+
+var errThreadFull = fmt.Errorf("This paste has reached its limit of %d comments", maxComments)
+
+// commentsPath is where the thread of paste id is kept, next to the paste
+// in its bucket. It goes when the paste does.
+func commentsPath(id string) string {
+	return bucket(id) + "/" + id + ".comments"
+}
+
+// commentsOpen reports whether p takes comments. Encrypted pastes don't:
+// readers without the key can't see what they'd comment on.
+func (p *Paste) commentsOpen() bool {
+	return config.CommentsEnabled && !p.NoComments && p.Cipher == ""
+}
+
+// commentMu serializes appends, which rewrite the whole file.
+var commentMu sync.Mutex
+
+// loadComments returns the thread of paste id, oldest first. Lines that
+// don't decode are skipped.
+func loadComments(id string) []comment {
+	data, err := store.ReadFile(commentsPath(id))
+	if err != nil {
+		return nil
+	}
+	var comments []comment
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var c comment
+		if json.Unmarshal(scanner.Bytes(), &c) == nil {
+			comments = append(comments, c)
+		}
+	}
+	return comments
+}
+
+// commentCount returns how many comments paste id has.
+func commentCount(id string) int {
+	data, err := store.ReadFile(commentsPath(id))
+	if err != nil {
+		return 0
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+// addComment appends c to the thread of paste id.
+func addComment(ctx context.Context, id string, c comment) error {
+	line, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	commentMu.Lock()
+	defer commentMu.Unlock()
+	data, err := store.ReadFile(commentsPath(id))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if bytes.Count(data, []byte("\n")) >= maxComments {
+		return errThreadFull
+	}
+	data = append(append(data, line...), '\n')
+	return saveRetrying(ctx, commentsPath(id), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// removeComments deletes the thread of paste id, if any.
+func removeComments(id string) {
+	commentMu.Lock()
+	defer commentMu.Unlock()
+	store.Remove(commentsPath(id))
+}
+
+// sweepComments removes threads in buckets start to end-1 whose paste is
+// gone, left behind by a comment racing the paste's removal.
+func sweepComments(start, end int) {
+	for i := start; i < end; i++ {
+		files, _ := store.Glob(fmt.Sprintf("pastes/%02x/*.comments", i))
+		for _, file := range files {
+			id := strings.TrimSuffix(filepath.Base(file), ".comments")
+			if !isValidID(id) {
+				continue
+			}
+			if pastes, _ := store.Glob(bucket(id) + "/" + id + "_*.txt"); len(pastes) == 0 {
+				removeComments(id)
+			}
+		}
+	}
+}
+
+// Comment posting is limited per client with a token bucket, refilled
+// over an hour.
+var (
+	commentLimitMu sync.Mutex
+	commentLimits  = make(map[string]*tokenBucket)
+)
+
+func commentLimit() rateLimit {
+	n := float64(config.CommentsPerHour)
+	return rateLimit{burst: n, perSecond: n / time.Hour.Seconds()}
+}
+
+// takeCommentLimit charges a comment to the client, or returns how long
+// until it may post one.
+func takeCommentLimit(r *http.Request) (time.Duration, bool) {
+	limit, key, now := commentLimit(), quotaKey(r), time.Now()
+	commentLimitMu.Lock()
+	defer commentLimitMu.Unlock()
+	b := commentLimits[key]
+	if b == nil {
+		b = &tokenBucket{}
+	}
+	if wait := limit.wait(b, now, 1); wait > 0 {
+		metrics.Inc("comments_rejected_total")
+		return wait, false
+	}
+	limit.take(b, 1)
+	commentLimits[key] = b
+	return 0, true
+}
+
+// sweepCommentLimits drops clients whose bucket has refilled.
+func sweepCommentLimits(now time.Time) {
+	limit := commentLimit()
+	commentLimitMu.Lock()
+	defer commentLimitMu.Unlock()
+	for key, b := range commentLimits {
+		if limit.full(b, now) {
+			delete(commentLimits, key)
+		}
+	}
+}
+
+// postComment serves POST /p/<id>[/<secret>]/comments from the form under
+// the paste and sends the poster back to the thread.
+func postComment(w http.ResponseWriter, r *http.Request, p *Paste) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if config.Upstream != "" {
+		http.Error(w, errReadOnly.Error(), http.StatusForbidden)
+		return
+	}
+	if !p.commentsOpen() {
+		http.Error(w, "Comments are turned off for this paste", http.StatusForbidden)
+		return
+	}
+	if err := http.NewCrossOriginProtection().Check(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, int64(config.MaxCommentLength)+4*1024)
+	author := strings.TrimSpace(r.FormValue("author"))
+	text := strings.TrimSpace(normalizeBody(r.FormValue("comment")))
+	switch {
+	case text == "":
+		http.Error(w, "Comment required", http.StatusBadRequest)
+		return
+	case len(text) > config.MaxCommentLength:
+		http.Error(w, fmt.Sprintf("Comment too long (max %d bytes)", config.MaxCommentLength), http.StatusBadRequest)
+		return
+	case len(author) > maxCommentAuthor:
+		http.Error(w, fmt.Sprintf("Name too long (max %d bytes)", maxCommentAuthor), http.StatusBadRequest)
+		return
+	case !utf8.ValidString(author) || !utf8.ValidString(text):
+		http.Error(w, "Comment must be valid UTF-8", http.StatusBadRequest)
+		return
+	}
+	if strings.IndexFunc(author, func(r rune) bool { return !titleRune(r) }) >= 0 {
+		http.Error(w, "Name must not contain control or bidirectional formatting characters", http.StatusBadRequest)
+		return
+	}
+	if blocked(author, text) {
+		http.Error(w, "Comment rejected by content policy", http.StatusUnprocessableEntity)
+		return
+	}
+	if wait, ok := takeCommentLimit(r); !ok {
+		throttled(w, r, http.StatusTooManyRequests, wait, "Too many comments, please try again later.")
+		return
+	}
+
+	err := addComment(r.Context(), p.ID, comment{Author: author, Text: text, Created: time.Now().UTC()})
+	if errors.Is(err, errThreadFull) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, errStoreFull) {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+	if err != nil {
+		requestLog(r.Context()).Error("add comment", "id", p.ID, "err", err)
+		internalError(w, r)
+		return
+	}
+	// The paste may have gone while the comment was written
+	if _, err := loadPaste(p.ID); err != nil {
+		removeComments(p.ID)
+		http.NotFound(w, r)
+		return
+	}
+	metrics.Inc("comments_total")
+	http.Redirect(w, r, sitePath(p.URLPath())+"#comments", http.StatusSeeOther)
+}
@@ -0,0 +1,611 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds runtime settings. Every flag falls back to an environment
+// variable so the app keeps working under Dokku/Heroku style deploys.
+type Config struct {
+	Port string
+
+	// BaseURL is the externally visible scheme and host, e.g.
+	// https://paste.example.com, without a trailing slash. Empty means
+	// derive it from the request.
+	BaseURL string
+
+	// BasePath mounts the site below a path, e.g. /paste, for reverse
+	// proxies serving it there. Every link and redirect carries it.
+	// BasePathOptional also serves requests that arrive without it.
+	BasePath         string
+	BasePathOptional bool
+
+	// IDPrefix starts every paste ID, so instances sharing a pastes
+	// directory neither collide nor clean up each other's pastes
+	IDPrefix string
+
+	// StaticDir holds logo.png, favicon.ico and custom.css overrides
+	StaticDir string
+
+	// RobotsFile replaces the built-in robots.txt
+	RobotsFile     string
+	SitemapEnabled bool
+
+	// ViewFooter is a line of text shown under every paste on its page,
+	// such as a branding or compliance notice
+	ViewFooter string
+
+	// AllowIndexing lets search engines index every paste, not just
+	// public ones
+	AllowIndexing bool
+
+	// Security headers sent on every response; "off" leaves one out
+	CSP            string
+	FrameOptions   string
+	ReferrerPolicy string
+
+	// AllowedHosts lists the Host headers served; empty allows any. The
+	// BaseURL host and localhost are always allowed.
+	AllowedHosts []string
+
+	// Creating pastes from a remote source_url (off by default)
+	SourceURLEnabled bool
+	SourceURLTimeout time.Duration
+	SourceURLAllow   []netip.Prefix
+	SourceURLDeny    []netip.Prefix
+
+	// MaxBodySize is the largest paste body accepted, in bytes
+	MaxBodySize int
+
+	// MaxTitleLength is the longest title accepted, in bytes
+	MaxTitleLength int
+
+	// Dedup stores identical bodies once, shared by reference count
+	Dedup bool
+
+	// Store is disk or memory. Memory keeps pastes only until a restart,
+	// never writing them to disk, and holds at most MemoryLimit bytes.
+	Store       string
+	MemoryLimit int
+
+	// SyncMode is always, batch or never. always flushes every paste to
+	// disk before answering; the others are much faster on slow disks, but
+	// a crash or power loss can lose the pastes written in the last second
+	// (batch) or few seconds (never) even though their links were already
+	// handed out.
+	SyncMode string
+
+	// Line guards applied at creation, and the view page's render threshold
+	MaxLines            int
+	MaxLineLength       int
+	RenderMaxLineLength int
+
+	// The view page shows only the start of pastes over PreviewLines lines
+	// or PreviewBytes bytes; 0 turns a limit off
+	PreviewLines int
+	PreviewBytes int
+
+	// DefaultTTL applies when the client doesn't pick one
+	DefaultTTL string
+
+	// CreationClosed lists the windows in which pastes can't be created,
+	// read in CreationTimezone
+	CreationClosed   []closedWindow
+	CreationTimezone *time.Location
+
+	// TitleChars is any, or printable to refuse control and bidirectional
+	// formatting characters in titles
+	TitleChars string
+
+	// TitleOptional lets pastes be created without a title; they are named
+	// after their first line instead
+	TitleOptional bool
+
+	// TTLPolicy caps the TTL by body size, smallest size first
+	TTLPolicy []ttlRule
+
+	// Rolling 24h creation quota per client IP or API token; 0 disables
+	QuotaCount int
+	QuotaBytes int
+	QuotaFile  string
+
+	// Instance-wide write ceiling; 0 disables either limit
+	GlobalRate           float64
+	GlobalBytesPerMinute int
+
+	// MaxConcurrentSaves bounds simultaneous disk writes; 0 is unlimited
+	MaxConcurrentSaves int
+	SaveQueueTimeout   time.Duration
+
+	// MaxInFlightPerIP bounds the requests one client has in progress;
+	// 0 is unlimited
+	MaxInFlightPerIP int
+
+	// IdempotencyTTL is how long an Idempotency-Key maps to its paste
+	IdempotencyTTL time.Duration
+
+	// StateMaxAge is how long in-memory per-client state is kept after its
+	// last use
+	StateMaxAge time.Duration
+
+	// IntegrityScan checks the paste store at startup; IntegrityRepair
+	// moves the bad files it finds aside
+	IntegrityScan   bool
+	IntegrityRepair bool
+
+	// Dev re-reads templates from disk for every page
+	Dev bool
+
+	// TagsEnabled lets pastes carry tags, listed at /tags/<tag>
+	TagsEnabled bool
+
+	// CommentsEnabled lets readers comment under pastes whose creator
+	// didn't turn comments off, each client at most CommentsPerHour times
+	// an hour
+	CommentsEnabled  bool
+	MaxCommentLength int
+	CommentsPerHour  int
+
+	// Upstream makes this instance a read-only mirror of another, fetching
+	// pastes it doesn't have from there on first view
+	Upstream string
+
+	// Public recent-pastes listing (off by default)
+	RecentEnabled bool
+	RecentLimit   int
+
+	// HistoryEnabled keeps the pastes a browser creates in a signed cookie,
+	// listed at /mine
+	HistoryEnabled bool
+
+	// Form spam checks; API token holders bypass them
+	FormSecret  string
+	FormMinTime time.Duration
+	APITokens   []string
+
+	// Proof of work for anonymous creation; difficulty 0 disables it
+	PowDifficulty    int
+	PowMaxDifficulty int
+	PowExpiry        time.Duration
+
+	// Client IP lists loaded from files, reloaded on SIGHUP
+	IPAllowFile      string
+	IPDenyFile       string
+	IPAllowWriteOnly bool
+
+	// TrustProxy takes the client address from X-Forwarded-For
+	TrustProxy bool
+
+	// H2C serves HTTP/2 without TLS to clients with prior knowledge
+	H2C bool
+
+	// Regex blocklist for titles and bodies, reloaded on SIGHUP
+	BlocklistFile      string
+	BlocklistScanBytes int
+
+	// Content scanner run before saving; see newScanner for URL forms
+	ScannerURL      string
+	ScannerTimeout  time.Duration
+	ScannerFailOpen bool
+
+	// Synchronous moderation webhook consulted before saving
+	ModerationURL      string
+	ModerationTimeout  time.Duration
+	ModerationDefault  string
+	ModerationSendBody bool
+
+	// PrivacyMode keeps client addresses out of logs and everything
+	// tinypaste stores, using salted hashes where it needs a key.
+	// PrivacySalt fixes the salt, which is otherwise random per process.
+	PrivacyMode bool
+	PrivacySalt string
+
+	// Hashed-IP audit log of creations, off unless AuditKey is set
+	AuditKey     string
+	AuditFile    string
+	AuditMaxSize int
+	AuditKeep    int
+
+	// Private-instance mode: Basic auth against a username:bcrypt file
+	AuthFile          string
+	AuthExemptHealthz bool
+
+	// AdminToken enables the /admin endpoints for bearer token holders
+	AdminToken string
+
+	// StatsToken, when set, is required as a bearer token for /api/stats
+	StatsToken string
+	ActionLog  string
+
+	// TombstoneTTL is how long removed pastes answer 410; 0 disables tombstones
+	TombstoneTTL time.Duration
+
+	// PruneBuckets removes bucket directories left empty by cleanup
+	PruneBuckets bool
+
+	// TakedownRetention is how long taken down pastes are kept for a
+	// counter-notice
+	TakedownRetention time.Duration
+
+	// LogFormat is text or json; AccessLog adds a line per request
+	LogFormat string
+	AccessLog bool
+
+	// SlowRequest logs requests taking at least this long; 0 disables it
+	SlowRequest time.Duration
+
+	// MetricsEnabled serves counters at /metrics
+	MetricsEnabled bool
+
+	// PublicStats serves rough totals to anyone at /stats.json
+	PublicStats bool
+}
+
+var config Config
+
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func envBool(key string, def bool) bool {
+	if v, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+func envFloat(key string, def float64) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return v
+	}
+	return def
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	if v, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+// parsePrefix parses a CIDR or a bare address, which becomes a single
+// address prefix.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return p.Masked(), nil
+}
+
+// parsePrefixes parses a comma-separated list of CIDRs or bare addresses.
+func parsePrefixes(name, list string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		p, err := parsePrefix(s)
+		if err != nil {
+			log.Fatalf("Invalid %s entry %q: %v", name, s, err)
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes
+}
+
+// parseList splits a comma-separated list, dropping empty entries.
+func parseList(list string) []string {
+	var items []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			items = append(items, s)
+		}
+	}
+	return items
+}
+
+// ttlRule allows bodies of up to MaxBytes to live at most TTL.
+type ttlRule struct {
+	MaxBytes int
+	TTL      string
+}
+
+// parseSize parses a byte count with an optional k, m or g suffix (powers
+// of 1024).
+func parseSize(s string) (int, error) {
+	mult := 1
+	switch {
+	case strings.HasSuffix(s, "k"):
+		mult, s = 1024, strings.TrimSuffix(s, "k")
+	case strings.HasSuffix(s, "m"):
+		mult, s = 1024*1024, strings.TrimSuffix(s, "m")
+	case strings.HasSuffix(s, "g"):
+		mult, s = 1024*1024*1024, strings.TrimSuffix(s, "g")
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
+
+// formatSize renders a byte count the way parseSize reads it, e.g. "1MB".
+func formatSize(n int) string {
+	switch {
+	case n >= 1024*1024*1024 && n%(1024*1024*1024) == 0:
+		return fmt.Sprintf("%dGB", n/(1024*1024*1024))
+	case n >= 1024*1024 && n%(1024*1024) == 0:
+		return fmt.Sprintf("%dMB", n/(1024*1024))
+	case n >= 1024 && n%1024 == 0:
+		return fmt.Sprintf("%dKB", n/1024)
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+// parseTTLPolicy parses a comma-separated list of size=ttl rules, e.g.
+// "64k=7d,1m=1h". "off" disables the policy.
+func parseTTLPolicy(name, list string) []ttlRule {
+	if strings.EqualFold(strings.TrimSpace(list), "off") {
+		return nil
+	}
+	var rules []ttlRule
+	for _, s := range parseList(strings.ToLower(list)) {
+		size, ttl, ok := strings.Cut(s, "=")
+		if !ok {
+			log.Fatalf("Invalid %s entry %q: want size=ttl", name, s)
+		}
+		n, err := parseSize(strings.TrimSpace(size))
+		if err != nil {
+			log.Fatalf("Invalid %s entry %q: %v", name, s, err)
+		}
+		ttl = strings.TrimSpace(ttl)
+		if _, ok := TTLHours[ttl]; !ok {
+			log.Fatalf("Invalid %s entry %q: unknown TTL %q", name, s, ttl)
+		}
+		rules = append(rules, ttlRule{MaxBytes: n, TTL: ttl})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].MaxBytes < rules[j].MaxBytes })
+	return rules
+}
+
+// validIDPrefix reports whether prefix is safe in URLs and file names,
+// where IDs are followed by _<ttl>. It must end in a character that
+// isn't hex, so no instance's ID, long or short, reads as another's: with
+// prefixes ab and ab12345678, ab12345678 plus a short ID would otherwise
+// be a long ID of ab.
+func validIDPrefix(prefix string) bool {
+	if len(prefix) > 16 {
+		return false
+	}
+	if prefix != "" && strings.ContainsRune("0123456789abcdef", rune(prefix[len(prefix)-1])) {
+		return false
+	}
+	for _, c := range prefix {
+		if !((c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func loadConfig() {
+	var creationClosed, creationTimezone, maxBodySize, memoryLimit, previewBytes, quotaBytes, globalBytes, allowedHosts, sourceAllow, sourceDeny, apiTokens, ttlPolicy string
+
+	// FSYNC=false predates SYNC_MODE and still picks never
+	defaultSync := syncAlways
+	if !envBool("FSYNC", true) {
+		defaultSync = syncNever
+	}
+
+	flag.StringVar(&config.Port, "port", envString("PORT", "8080"), "port to listen on")
+	flag.StringVar(&config.BaseURL, "base-url", envString("BASE_URL", ""), "external base URL used for absolute links")
+	flag.StringVar(&config.BasePath, "base-path", envString("BASE_PATH", ""), "path the site is served under, e.g. /paste (empty for the root)")
+	flag.BoolVar(&config.BasePathOptional, "base-path-optional", envBool("BASE_PATH_OPTIONAL", false), "also serve requests whose path lacks the base path")
+	flag.StringVar(&config.IDPrefix, "id-prefix", envString("ID_PREFIX", ""), "prefix of every paste ID, for instances sharing storage")
+	flag.StringVar(&config.CSP, "csp", envString("CONTENT_SECURITY_POLICY", defaultCSP), "Content-Security-Policy header (\"off\" to omit)")
+	flag.StringVar(&config.FrameOptions, "frame-options", envString("FRAME_OPTIONS", "DENY"), "X-Frame-Options header (\"off\" to omit)")
+	flag.StringVar(&config.ReferrerPolicy, "referrer-policy", envString("REFERRER_POLICY", "same-origin"), "Referrer-Policy header (\"off\" to omit)")
+	flag.StringVar(&config.StaticDir, "static-dir", envString("STATIC_DIR", ""), "directory whose files override the embedded static assets")
+	flag.StringVar(&config.ViewFooter, "view-footer", envString("VIEW_FOOTER", ""), "text shown under each paste on its page (empty shows none)")
+	flag.StringVar(&config.RobotsFile, "robots-file", envString("ROBOTS_FILE", ""), "file served as /robots.txt instead of the built-in one")
+	flag.BoolVar(&config.AllowIndexing, "allow-indexing", envBool("ALLOW_INDEXING", false), "let search engines index all pastes, not only public ones")
+	flag.BoolVar(&config.SitemapEnabled, "sitemap", envBool("SITEMAP_ENABLED", false), "serve /sitemap.xml listing the static pages")
+	flag.StringVar(&allowedHosts, "allowed-hosts", envString("ALLOWED_HOSTS", ""), "comma-separated Host names to accept (empty accepts any)")
+	flag.BoolVar(&config.SourceURLEnabled, "source-url", envBool("SOURCE_URL_ENABLED", false), "allow creating pastes from a remote source_url")
+	flag.DurationVar(&config.SourceURLTimeout, "source-url-timeout", envDuration("SOURCE_URL_TIMEOUT", 10*time.Second), "timeout for fetching a source_url")
+	flag.StringVar(&sourceAllow, "source-url-allow", envString("SOURCE_URL_ALLOW", ""), "comma-separated CIDRs source_url may reach even if internal")
+	flag.StringVar(&sourceDeny, "source-url-deny", envString("SOURCE_URL_DENY", ""), "comma-separated CIDRs source_url may never reach")
+	flag.StringVar(&maxBodySize, "max-body-size", envString("MAX_BODY_SIZE", "1m"), "largest paste body accepted, e.g. 256k or 10m")
+	flag.IntVar(&config.MaxTitleLength, "max-title-length", envInt("MAX_TITLE_LENGTH", 200), "longest paste title accepted, in bytes")
+	flag.StringVar(&config.SyncMode, "sync-mode", envString("SYNC_MODE", defaultSync), "when pastes are flushed to disk: always, batch (every second) or never")
+	flag.BoolVar(&config.Dedup, "dedup", envBool("DEDUP_ENABLED", false), "store identical paste bodies once")
+	flag.StringVar(&config.Store, "store", envString("STORE", "disk"), "where pastes are kept: disk, or memory to lose them on restart")
+	flag.StringVar(&memoryLimit, "memory-limit", envString("MEMORY_LIMIT", "256m"), "most paste data the memory store holds, e.g. 64m or 1g")
+	flag.IntVar(&config.MaxLines, "max-lines", envInt("MAX_LINES", 0), "maximum number of lines in a paste (0 is unlimited)")
+	flag.IntVar(&config.MaxLineLength, "max-line-length", envInt("MAX_LINE_LENGTH", 0), "maximum length of a single line in bytes (0 is unlimited)")
+	flag.IntVar(&config.RenderMaxLineLength, "render-max-line-length", envInt("RENDER_MAX_LINE_LENGTH", 16*1024), "longest line the view page renders inline")
+	flag.IntVar(&config.PreviewLines, "preview-lines", envInt("PREVIEW_LINES", 2000), "lines of a paste the view page shows before linking to the full paste (0 for no limit)")
+	flag.StringVar(&previewBytes, "preview-bytes", envString("PREVIEW_BYTES", "100k"), "bytes of a paste the view page shows before linking to the full paste (0 for no limit)")
+	flag.StringVar(&config.DefaultTTL, "default-ttl", envString("DEFAULT_TTL", "6h"), "TTL used, and preselected in the form, when none is chosen")
+	flag.StringVar(&creationClosed, "creation-closed", envString("CREATION_CLOSED", ""), "comma-separated windows without paste creation, e.g. 22:00-07:00 or sat+sun 00:00-24:00")
+	flag.StringVar(&creationTimezone, "creation-timezone", envString("CREATION_TIMEZONE", "Local"), "time zone of creation-closed, e.g. Europe/Berlin")
+	flag.StringVar(&config.TitleChars, "title-chars", envString("TITLE_CHARS", titleCharsAny), "characters allowed in titles: any, or printable to refuse control and bidi override characters")
+	flag.BoolVar(&config.TitleOptional, "title-optional", envBool("TITLE_OPTIONAL", false), "allow pastes without a title, naming them after their first line")
+	flag.StringVar(&ttlPolicy, "ttl-policy", envString("TTL_POLICY", ""), "comma-separated size=ttl caps on retention, e.g. 64k=7d,1m=1h (empty or \"off\" disables)")
+	flag.IntVar(&config.QuotaCount, "quota-count", envInt("QUOTA_COUNT", 0), "pastes each client may create per 24 hours (0 disables)")
+	flag.StringVar(&quotaBytes, "quota-bytes", envString("QUOTA_BYTES", "0"), "bytes each client may paste per 24 hours, e.g. 50m (0 disables)")
+	flag.StringVar(&config.QuotaFile, "quota-file", envString("QUOTA_FILE", "pastes/quota.json"), "where quota counters are persisted")
+	flag.Float64Var(&config.GlobalRate, "global-rate", envFloat("GLOBAL_RATE", 0), "paste writes per second for the whole instance (0 disables)")
+	flag.StringVar(&globalBytes, "global-bytes-per-minute", envString("GLOBAL_BYTES_PER_MINUTE", "0"), "bytes written per minute for the whole instance, e.g. 50m (0 disables)")
+	flag.IntVar(&config.MaxConcurrentSaves, "max-concurrent-saves", envInt("MAX_CONCURRENT_SAVES", 0), "pastes written to disk at once (0 is unlimited)")
+	flag.IntVar(&config.MaxInFlightPerIP, "max-inflight-per-ip", envInt("MAX_INFLIGHT_PER_IP", 0), "requests one client may have in progress at once (0 is unlimited)")
+	flag.DurationVar(&config.SaveQueueTimeout, "save-queue-timeout", envDuration("SAVE_QUEUE_TIMEOUT", 2*time.Second), "how long a paste waits for a write slot before a 503")
+	flag.DurationVar(&config.IdempotencyTTL, "idempotency-ttl", envDuration("IDEMPOTENCY_TTL", time.Hour), "how long an Idempotency-Key is remembered")
+	flag.DurationVar(&config.StateMaxAge, "state-max-age", envDuration("STATE_MAX_AGE", 24*time.Hour), "how long idle per-client quota and idempotency state is kept in memory")
+	flag.BoolVar(&config.IntegrityScan, "integrity-scan", envBool("INTEGRITY_SCAN", false), "check the paste store for malformed, truncated and orphaned files at startup")
+	flag.BoolVar(&config.IntegrityRepair, "repair", envBool("INTEGRITY_REPAIR", false), "move files the integrity scan flags to pastes/quarantine")
+	flag.BoolVar(&config.Dev, "dev", envBool("DEV", false), "re-read templates from the templates directory for every page, show template errors and accept localhost outside ALLOWED_HOSTS")
+	flag.BoolVar(&config.TagsEnabled, "tags", envBool("TAGS_ENABLED", false), "let pastes carry tags, listed at /tags/<tag>")
+	flag.BoolVar(&config.CommentsEnabled, "comments", envBool("COMMENTS_ENABLED", false), "let readers comment under pastes")
+	flag.IntVar(&config.MaxCommentLength, "max-comment-length", envInt("MAX_COMMENT_LENGTH", 1000), "longest comment accepted, in bytes")
+	flag.IntVar(&config.CommentsPerHour, "comments-per-hour", envInt("COMMENTS_PER_HOUR", 10), "comments each client may post per hour")
+	flag.StringVar(&config.Upstream, "upstream", envString("UPSTREAM_URL", ""), "base URL of an instance to mirror read-only (empty disables mirroring)")
+	flag.BoolVar(&config.RecentEnabled, "recent", envBool("RECENT_ENABLED", false), "let pastes opt in to the public /recent listing")
+	flag.BoolVar(&config.HistoryEnabled, "history", envBool("HISTORY_ENABLED", false), "list the pastes a browser created at /mine, kept in a signed cookie")
+	flag.IntVar(&config.RecentLimit, "recent-limit", envInt("RECENT_LIMIT", 50), "number of pastes shown on /recent")
+	flag.StringVar(&config.FormSecret, "form-secret", envString("FORM_SECRET", ""), "key for signing form timestamps (random per process if empty)")
+	flag.DurationVar(&config.FormMinTime, "form-min-time", envDuration("FORM_MIN_TIME", 0), "reject form submissions sent sooner than this after the form was served (0 disables)")
+	flag.StringVar(&apiTokens, "api-tokens", envString("API_TOKENS", ""), "comma-separated bearer tokens that skip form spam checks")
+	flag.IntVar(&config.PowDifficulty, "pow-difficulty", envInt("POW_DIFFICULTY", 0), "base proof-of-work difficulty in bits (0 disables)")
+	flag.IntVar(&config.PowMaxDifficulty, "pow-max-difficulty", envInt("POW_MAX_DIFFICULTY", 24), "ceiling for per-IP escalated difficulty")
+	flag.DurationVar(&config.PowExpiry, "pow-expiry", envDuration("POW_EXPIRY", 5*time.Minute), "how long a proof-of-work challenge stays valid")
+	flag.StringVar(&config.IPAllowFile, "ip-allow-file", envString("IP_ALLOW_FILE", ""), "file of CIDRs allowed to use the site (empty allows all)")
+	flag.StringVar(&config.IPDenyFile, "ip-deny-file", envString("IP_DENY_FILE", ""), "file of CIDRs refused on every route")
+	flag.BoolVar(&config.IPAllowWriteOnly, "ip-allow-write-only", envBool("IP_ALLOW_WRITE_ONLY", false), "apply the allow list only to writes, letting anyone read")
+	flag.BoolVar(&config.TrustProxy, "trust-proxy", envBool("TRUST_PROXY", false), "use X-Forwarded-For as the client address")
+	flag.BoolVar(&config.H2C, "enable-h2c", envBool("H2C_ENABLED", false), "also serve cleartext HTTP/2 (h2c) with prior knowledge, for proxies")
+	flag.BoolVar(&config.MetricsEnabled, "metrics", envBool("METRICS_ENABLED", false), "serve counters at /metrics")
+	flag.BoolVar(&config.PublicStats, "public-stats", envBool("PUBLIC_STATS_ENABLED", false), "serve rough paste totals to anyone at /stats.json")
+	flag.StringVar(&config.BlocklistFile, "blocklist", envString("BLOCKLIST_FILE", ""), "file of regexes (one per line) that reject matching pastes")
+	flag.IntVar(&config.BlocklistScanBytes, "blocklist-scan-bytes", envInt("BLOCKLIST_SCAN_BYTES", 256*1024), "how much of the body the blocklist scans")
+	flag.StringVar(&config.ScannerURL, "scanner-url", envString("SCANNER_URL", ""), "content scanner: http(s)://..., clamd://host:port or clamd:///socket")
+	flag.DurationVar(&config.ScannerTimeout, "scanner-timeout", envDuration("SCANNER_TIMEOUT", 10*time.Second), "timeout for a content scan")
+	flag.BoolVar(&config.ScannerFailOpen, "scanner-fail-open", envBool("SCANNER_FAIL_OPEN", false), "accept pastes when the scanner is unavailable")
+	flag.StringVar(&config.ModerationURL, "moderation-url", envString("MODERATION_URL", ""), "webhook that allows, denies or quarantines each paste")
+	flag.DurationVar(&config.ModerationTimeout, "moderation-timeout", envDuration("MODERATION_TIMEOUT", 3*time.Second), "timeout for the moderation webhook")
+	flag.StringVar(&config.ModerationDefault, "moderation-default", envString("MODERATION_DEFAULT", "deny"), "action when the moderation webhook fails: allow, deny or quarantine")
+	flag.BoolVar(&config.ModerationSendBody, "moderation-send-body", envBool("MODERATION_SEND_BODY", false), "include the paste body in moderation requests")
+	flag.BoolVar(&config.PrivacyMode, "privacy", envBool("PRIVACY_MODE", false), "never log or store client IP addresses")
+	flag.StringVar(&config.PrivacySalt, "privacy-salt", envString("PRIVACY_SALT", ""), "salt for the address hashes in privacy mode, so quotas survive a restart (random per process if empty)")
+	flag.StringVar(&config.AuditKey, "audit-key", envString("AUDIT_KEY", ""), "HMAC key for the creation audit log (empty disables it)")
+	flag.StringVar(&config.AuditFile, "audit-file", envString("AUDIT_FILE", "pastes/audit.jsonl"), "creation audit log file")
+	flag.IntVar(&config.AuditMaxSize, "audit-max-size", envInt("AUDIT_MAX_SIZE", 10*1024*1024), "rotate the audit log at this many bytes")
+	flag.IntVar(&config.AuditKeep, "audit-keep", envInt("AUDIT_KEEP", 5), "number of rotated audit logs to keep")
+	flag.StringVar(&config.AuthFile, "auth-file", envString("AUTH_FILE", ""), "file of username:bcrypt-hash lines; puts the whole site behind Basic auth")
+	flag.BoolVar(&config.AuthExemptHealthz, "auth-exempt-healthz", envBool("AUTH_EXEMPT_HEALTHZ", true), "serve /healthz without credentials in private mode")
+	flag.StringVar(&config.AdminToken, "admin-token", envString("ADMIN_TOKEN", ""), "bearer token for the /admin endpoints (empty disables them)")
+	flag.StringVar(&config.StatsToken, "stats-token", envString("STATS_TOKEN", ""), "bearer token required for /api/stats (empty leaves it open)")
+	flag.StringVar(&config.ActionLog, "action-log", envString("ACTION_LOG", "pastes/actions.jsonl"), "log of operator actions such as deletions")
+	flag.DurationVar(&config.TombstoneTTL, "tombstone-ttl", envDuration("TOMBSTONE_TTL", 30*24*time.Hour), "how long removed pastes answer 410 Gone (0 to disable)")
+	flag.BoolVar(&config.PruneBuckets, "prune-buckets", envBool("PRUNE_BUCKETS", true), "remove bucket directories left empty by cleanup")
+	flag.DurationVar(&config.TakedownRetention, "takedown-retention", envDuration("TAKEDOWN_RETENTION", 90*24*time.Hour), "how long taken down pastes are kept before deletion")
+	flag.StringVar(&config.LogFormat, "log-format", envString("LOG_FORMAT", "text"), "log output format: text or json")
+	flag.BoolVar(&config.AccessLog, "access-log", envBool("ACCESS_LOG", false), "log every request")
+	flag.DurationVar(&config.SlowRequest, "slow-request", envDuration("SLOW_REQUEST", 0), "log requests taking at least this long at WARN, with where the time went (0 disables)")
+	flag.Parse()
+
+	setupLogger()
+
+	size, err := parseSize(strings.ToLower(strings.TrimSpace(maxBodySize)))
+	if err != nil || size <= 0 {
+		log.Fatalf("Invalid max-body-size %q", maxBodySize)
+	}
+	config.MaxBodySize = size
+	switch config.Store {
+	case "disk":
+	case "memory":
+		if config.MemoryLimit, err = parseSize(strings.ToLower(strings.TrimSpace(memoryLimit))); err != nil || config.MemoryLimit <= 0 {
+			log.Fatalf("Invalid memory-limit %q", memoryLimit)
+		}
+		if config.Dedup {
+			log.Fatalf("dedup cannot be combined with the memory store: shared bodies are kept on disk")
+		}
+	default:
+		log.Fatalf("Invalid store %q: want disk or memory", config.Store)
+	}
+	if config.MaxInFlightPerIP < 0 {
+		log.Fatalf("Invalid max-inflight-per-ip %d: must not be negative", config.MaxInFlightPerIP)
+	}
+	if config.MaxCommentLength <= 0 || config.CommentsPerHour <= 0 {
+		log.Fatalf("Invalid comment limits: max-comment-length and comments-per-hour must be positive")
+	}
+	if config.TitleChars != titleCharsAny && config.TitleChars != titleCharsPrintable {
+		log.Fatalf("Invalid title-chars %q: want any or printable", config.TitleChars)
+	}
+	if config.MaxTitleLength <= 0 {
+		log.Fatalf("Invalid max-title-length %d: must be positive", config.MaxTitleLength)
+	}
+	if config.PreviewBytes, err = parseSize(strings.ToLower(strings.TrimSpace(previewBytes))); err != nil {
+		log.Fatalf("Invalid preview-bytes %q", previewBytes)
+	}
+	if !validIDPrefix(config.IDPrefix) {
+		log.Fatalf("Invalid id-prefix %q: want up to 16 of a-z, 0-9 and -, ending in - or g-z", config.IDPrefix)
+	}
+	config.BasePath = strings.TrimSuffix(config.BasePath, "/")
+	if config.BasePath != "" && !validBasePath(config.BasePath) {
+		log.Fatalf("Invalid base-path %q: want a path such as /paste", config.BasePath)
+	}
+	if config.PrivacyMode && config.AuditKey != "" {
+		log.Fatalf("privacy and audit-key cannot be combined: the audit log records hashed client addresses")
+	}
+	if config.QuotaBytes, err = parseSize(strings.ToLower(strings.TrimSpace(quotaBytes))); err != nil {
+		log.Fatalf("Invalid quota-bytes %q", quotaBytes)
+	}
+	if config.GlobalBytesPerMinute, err = parseSize(strings.ToLower(strings.TrimSpace(globalBytes))); err != nil {
+		log.Fatalf("Invalid global-bytes-per-minute %q", globalBytes)
+	}
+	if config.BaseURL != "" {
+		u, err := url.Parse(config.BaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil ||
+			strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" {
+			log.Fatalf("Invalid base-url %q: want a scheme and host such as https://paste.example.com (set base-path for a path)", config.BaseURL)
+		}
+		config.BaseURL = u.Scheme + "://" + u.Host
+	}
+	config.AllowedHosts = parseList(strings.ToLower(allowedHosts))
+	if u, err := url.Parse(config.BaseURL); err == nil && u.Hostname() != "" && len(config.AllowedHosts) > 0 {
+		config.AllowedHosts = append(config.AllowedHosts, strings.ToLower(u.Hostname()))
+	}
+	config.SourceURLAllow = parsePrefixes("source-url-allow", sourceAllow)
+	config.SourceURLDeny = parsePrefixes("source-url-deny", sourceDeny)
+	config.APITokens = parseList(apiTokens)
+	if _, ok := TTLHours[config.DefaultTTL]; !ok {
+		log.Fatalf("Invalid default-ttl %q", config.DefaultTTL)
+	}
+	config.TTLPolicy = parseTTLPolicy("ttl-policy", ttlPolicy)
+	config.CreationClosed = parseSchedule("creation-closed", creationClosed)
+	if config.CreationTimezone, err = time.LoadLocation(creationTimezone); err != nil {
+		log.Fatalf("Invalid creation-timezone %q: %v", creationTimezone, err)
+	}
+	if !slices.Contains(syncModes, config.SyncMode) {
+		log.Fatalf("Invalid sync-mode %q: want always, batch or never", config.SyncMode)
+	}
+	if config.Upstream != "" {
+		u, err := url.Parse(config.Upstream)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			log.Fatalf("Invalid upstream %q: want an http(s) base URL", config.Upstream)
+		}
+		if strings.TrimSuffix(config.Upstream, "/") == strings.TrimSuffix(config.BaseURL, "/") {
+			log.Fatalf("upstream cannot be this instance's own base-url")
+		}
+	}
+}
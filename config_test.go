@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"512", 512, true},
+		{"64k", 64 << 10, true},
+		{"64m", 64 << 20, true},
+		{"1g", 1 << 30, true},
+		{"1024m", 1 << 30, true},
+		{"", 0, false},
+		{"g", 0, false},
+		{"1t", 0, false},
+		{"-1m", 0, false},
+		{"1.5g", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v; want %d, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	for n, want := range map[int]string{
+		100:       "100 bytes",
+		64 << 10:  "64KB",
+		64 << 20:  "64MB",
+		1 << 30:   "1GB",
+		3 << 29:   "1536MB",
+		1<<30 + 1: "1073741825 bytes",
+	} {
+		if got := formatSize(n); got != want {
+			t.Errorf("formatSize(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+)
+
+// devTemplateDir is where dev mode reads templates from, relative to the
+// working directory.
+const devTemplateDir = "templates"
+
+// devTemplates parses the templates afresh for one page in dev mode, from
+// devTemplateDir if it exists and the embedded copies otherwise, bound for
+// the visitor like pageTemplates.
+func devTemplates(key pageKey) (*template.Template, error) {
+	var fsys fs.FS = templateFiles
+	pattern := "templates/*.html"
+	if info, err := os.Stat(devTemplateDir); err == nil && info.IsDir() {
+		fsys, pattern = os.DirFS(devTemplateDir), "*.html"
+	}
+	t, err := template.New("").Funcs(templateFuncs).ParseFS(fsys, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return t.Funcs(pageFuncs(key.theme, key.lang)), nil
+}
+
+// templateError answers a page that failed to parse or render. Dev mode
+// shows the error, with the template's line number, in the browser.
+func templateError(w http.ResponseWriter, r *http.Request, err error) {
+	if !config.Dev {
+		internalError(w, r)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func renderAbout() *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	renderTemplate(w, httptest.NewRequest(http.MethodGet, "/about", nil), "about", nil)
+	return w
+}
+
+func writeTemplate(t *testing.T, name, text string) {
+	t.Helper()
+	if err := os.MkdirAll(devTemplateDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(devTemplateDir+"/"+name, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Without a templates directory dev mode renders the embedded templates.
+func TestDevTemplatesFallback(t *testing.T) {
+	useConfig(t)
+	t.Chdir(t.TempDir())
+	embedded := renderAbout()
+	if embedded.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", embedded.Code, embedded.Body)
+	}
+
+	config.Dev = true
+	w := renderAbout()
+	if w.Code != http.StatusOK || w.Body.String() != embedded.Body.String() {
+		t.Errorf("dev mode without %s/: status %d, page differs from the embedded one", devTemplateDir, w.Code)
+	}
+}
+
+func TestDevTemplatesFromDisk(t *testing.T) {
+	useConfig(t)
+	t.Chdir(t.TempDir())
+	writeTemplate(t, "about.html", `edited {{lang}}`)
+
+	config.Dev = true
+	if w := renderAbout(); w.Body.String() != "edited en" {
+		t.Errorf("dev mode = %q, want the template from disk", w.Body)
+	}
+	// Edits show up on the next page
+	writeTemplate(t, "about.html", `edited again`)
+	if w := renderAbout(); w.Body.String() != "edited again" {
+		t.Errorf("after an edit = %q, want it re-read", w.Body)
+	}
+
+	config.Dev = false
+	if w := renderAbout(); strings.Contains(w.Body.String(), "edited") {
+		t.Error("templates were read from disk outside dev mode")
+	}
+}
+
+// Dev mode shows template errors with their line; otherwise they stay in
+// the log.
+func TestDevTemplateErrors(t *testing.T) {
+	useConfig(t)
+	t.Chdir(t.TempDir())
+	captureLog(t)
+	config.Dev = true
+
+	writeTemplate(t, "about.html", "line one\n{{if}}")
+	w := renderAbout()
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "about.html:2") {
+		t.Errorf("parse error: status %d, %q, want the file and line", w.Code, w.Body)
+	}
+
+	writeTemplate(t, "about.html", "line one\n{{template \"missing\"}}")
+	w = renderAbout()
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "missing") {
+		t.Errorf("render error: status %d, %q, want the error", w.Code, w.Body)
+	}
+
+	config.Dev = false
+	w = httptest.NewRecorder()
+	renderTemplate(w, httptest.NewRequest(http.MethodGet, "/", nil), "nonexistent", nil)
+	if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "nonexistent") {
+		t.Errorf("outside dev mode: status %d, %q, want the error kept out of the page", w.Code, w.Body)
+	}
+}
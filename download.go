@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxFilenameLength bounds stored upload names, in bytes.
+const maxFilenameLength = 128
+
+// sanitizeFilename reduces a client-supplied name to a bare file name that
+// is safe to put in a header: no directories, control characters, quotes
+// or backslashes. It returns "" if nothing usable is left.
+func sanitizeFilename(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = path.Base(strings.ToValidUTF8(name, ""))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' || r == '/' {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(strings.Trim(name, "."))
+	for len(name) > maxFilenameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
+
+// readUpload returns the contents and name of the "file" part of a
+// multipart create request, reading at most limit+1 bytes so oversized
+// uploads fail the normal size check. It returns "" if there is no file.
+func readUpload(r *http.Request, limit int) (body, filename string, err error) {
+	file, header, err := r.FormFile("file")
+	if errors.Is(err, http.ErrMissingFile) || errors.Is(err, http.ErrNotMultipart) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, int64(limit)+1))
+	if err != nil {
+		return "", "", err
+	}
+	return string(data), header.Filename, nil
+}
+
+// serveBody sends the paste body through http.ServeContent, so clients can
+// resume with byte ranges and revalidate against the body's SHA-256.
+// Content-Type must already be set.
+func serveBody(w http.ResponseWriter, r *http.Request, p *Paste) {
+	w.Header().Set("ETag", `"`+p.SHA256+`"`)
+	http.ServeContent(w, r, "", p.Created, bytes.NewReader(p.Body))
+}
+
+// serveDownload sends the paste body as an attachment named after the
+// uploaded file, or paste_<id>.txt (paste_<id>.age for age pastes).
+func serveDownload(w http.ResponseWriter, r *http.Request, p *Paste) {
+	name := p.Filename
+	switch {
+	case name != "":
+	case p.Opaque():
+		name = "paste_" + p.ID + "." + p.Cipher
+	default:
+		name = "paste_" + p.ID + ".txt"
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	serveBody(w, r, p)
+}
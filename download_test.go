@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Ranges on the raw and download views count from the start of the body,
+// not the stored file with its header.
+func TestServeBodyRange(t *testing.T) {
+	useDiskStore(t)
+	var b strings.Builder
+	for i := range 500 {
+		b.WriteString("line " + strconv.Itoa(i) + "\n")
+	}
+	body := b.String()
+	w := postSave(t, url.Values{"title": {"log"}, "body": {body}, "ttl": {"1h"}})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("save: status %d: %s", w.Code, w.Body)
+	}
+	id := createdID(t, w)
+
+	get := func(path string, header map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		pasteHandler(w, r)
+		return w
+	}
+
+	for _, view := range []string{"/raw", "/download"} {
+		t.Run(view, func(t *testing.T) {
+			path := "/p/" + id + view
+
+			w := get(path, nil)
+			if w.Code != http.StatusOK || w.Body.String() != body {
+				t.Fatalf("whole body: status %d, %d bytes, want %d", w.Code, w.Body.Len(), len(body))
+			}
+			if got := w.Header().Get("Accept-Ranges"); got != "bytes" {
+				t.Errorf("Accept-Ranges = %q, want bytes", got)
+			}
+			if got := w.Header().Get("Content-Length"); got != strconv.Itoa(len(body)) {
+				t.Errorf("Content-Length = %s, want the body length %d", got, len(body))
+			}
+			etag := w.Header().Get("ETag")
+
+			w = get(path, map[string]string{"Range": "bytes=1000-1999"})
+			if w.Code != http.StatusPartialContent {
+				t.Fatalf("middle range: status %d, want %d", w.Code, http.StatusPartialContent)
+			}
+			if w.Body.String() != body[1000:2000] {
+				t.Errorf("middle range = %q, want %q", w.Body, body[1000:2000])
+			}
+			want := "bytes 1000-1999/" + strconv.Itoa(len(body))
+			if got := w.Header().Get("Content-Range"); got != want {
+				t.Errorf("Content-Range = %q, want %q", got, want)
+			}
+
+			w = get(path, map[string]string{"Range": "bytes=-10"})
+			if w.Code != http.StatusPartialContent || w.Body.String() != body[len(body)-10:] {
+				t.Errorf("suffix range: status %d, %q, want %q", w.Code, w.Body, body[len(body)-10:])
+			}
+
+			w = get(path, map[string]string{"Range": "bytes=" + strconv.Itoa(len(body)) + "-"})
+			if w.Code != http.StatusRequestedRangeNotSatisfiable {
+				t.Errorf("range past the end: status %d, want %d", w.Code, http.StatusRequestedRangeNotSatisfiable)
+			}
+			want = "bytes */" + strconv.Itoa(len(body))
+			if got := w.Header().Get("Content-Range"); got != want {
+				t.Errorf("416 Content-Range = %q, want %q", got, want)
+			}
+
+			// A stale If-Range gets the whole body instead of the range
+			w = get(path, map[string]string{"Range": "bytes=0-9", "If-Range": etag})
+			if w.Code != http.StatusPartialContent || w.Body.String() != body[:10] {
+				t.Errorf("matching If-Range: status %d, %q", w.Code, w.Body)
+			}
+			w = get(path, map[string]string{"Range": "bytes=0-9", "If-Range": `"stale"`})
+			if w.Code != http.StatusOK || w.Body.String() != body {
+				t.Errorf("stale If-Range: status %d, %d bytes, want the whole body", w.Code, w.Body.Len())
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Sync modes, trading durability for write throughput:
+//   - always fsyncs each file and its directory before a save returns
+//   - batch fsyncs everything written in the last batchInterval together,
+//     so a crash can lose that much
+//   - never leaves flushing to the OS
+const (
+	syncAlways = "always"
+	syncBatch  = "batch"
+	syncNever  = "never"
+)
+
+var syncModes = []string{syncAlways, syncBatch, syncNever}
+
+// batchInterval is how often batch mode flushes.
+const batchInterval = time.Second
+
+// syncDir fsyncs a directory, making the entries created or renamed in it
+// survive a crash. Tests replace it to see which directories are synced.
+var syncDir = func(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+var (
+	batchMu    sync.Mutex
+	batchFiles = make(map[string]bool)
+)
+
+// queueSync marks a file renamed into place for the next batch flush.
+func queueSync(path string) {
+	batchMu.Lock()
+	batchFiles[path] = true
+	batchMu.Unlock()
+}
+
+// flushBatch fsyncs the files queued since the last flush, then their
+// directories. Files deleted in the meantime are skipped.
+func flushBatch() {
+	batchMu.Lock()
+	files := batchFiles
+	batchFiles = make(map[string]bool)
+	batchMu.Unlock()
+
+	dirs := make(map[string]bool)
+	for path := range files {
+		f, err := os.Open(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err == nil {
+			err = f.Sync()
+			f.Close()
+		}
+		if err != nil {
+			slog.Error("batch sync", "path", path, "err", err)
+			continue
+		}
+		dirs[filepath.Dir(path)] = true
+	}
+	for dir := range dirs {
+		if err := syncDir(dir); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Error("batch sync", "path", dir, "err", err)
+		}
+	}
+}
+
+// syncEntry makes a file just created or renamed to path durable as the
+// sync mode asks. In always mode the caller has synced the file's data;
+// only its directory entry is left.
+func syncEntry(path string) error {
+	switch config.SyncMode {
+	case syncAlways:
+		return syncDir(filepath.Dir(path))
+	case syncBatch:
+		queueSync(path)
+	}
+	return nil
+}
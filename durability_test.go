@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// BenchmarkSave measures creating pastes on disk in each sync mode. The
+// pastes go under TMPDIR, so point it at the disk that matters; on tmpfs
+// fsync is free. Batch mode flushes on its timer as the server does.
+func BenchmarkSave(b *testing.B) {
+	for _, mode := range syncModes {
+		b.Run(mode, func(b *testing.B) {
+			saved := config
+			b.Cleanup(func() { config = saved })
+			b.Chdir(b.TempDir())
+			old := store
+			store = diskStore{}
+			b.Cleanup(func() { store = old })
+			config.SyncMode = mode
+			if mode == syncBatch {
+				stop := make(chan struct{})
+				var wg sync.WaitGroup
+				wg.Go(func() {
+					tick := time.NewTicker(batchInterval)
+					defer tick.Stop()
+					for {
+						select {
+						case <-tick.C:
+							flushBatch()
+						case <-stop:
+							flushBatch()
+							return
+						}
+					}
+				})
+				b.Cleanup(func() { close(stop); wg.Wait() })
+			}
+
+			body := make([]byte, 4096)
+			for i := range body {
+				body[i] = byte('a' + i%26)
+			}
+			b.SetBytes(int64(len(body)))
+			ctx := context.Background()
+			for i := 0; b.Loop(); i++ {
+				p := &Paste{ID: fmt.Sprintf("%016x", i), Title: "bench", Body: body, TTL: "1h", Normalized: true}
+				if err := p.save(ctx); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// recordDirSyncs records the directories syncDir is asked to sync, and
+// how many paste files each held at the time.
+func recordDirSyncs(t *testing.T) func() []string {
+	t.Helper()
+	var (
+		mu     sync.Mutex
+		synced []string
+	)
+	old := syncDir
+	syncDir = func(dir string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		entries, _ := filepath.Glob(filepath.Join(dir, "*.txt"))
+		synced = append(synced, fmt.Sprintf("%s (%d files)", dir, len(entries)))
+		return old(dir)
+	}
+	t.Cleanup(func() { syncDir = old })
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(synced)
+	}
+}
+
+func TestSyncModeDirectory(t *testing.T) {
+	for _, mode := range syncModes {
+		t.Run(mode, func(t *testing.T) {
+			useDiskStore(t)
+			config.SyncMode = mode
+			flushBatch()
+			synced := recordDirSyncs(t)
+
+			p := savePaste(t, "00aaaaaaaaaaaaaa", "1h", time.Now())
+			if _, err := os.Stat(p.path()); err != nil {
+				t.Fatal(err)
+			}
+			// The paste's own directory, synced once the file is in it
+			want := []string{filepath.Dir(p.path()) + " (1 files)"}
+
+			switch mode {
+			case syncAlways:
+				if got := synced(); !slices.Equal(got, want) {
+					t.Errorf("synced %v before save returned, want %v", got, want)
+				}
+			case syncBatch:
+				if got := synced(); len(got) != 0 {
+					t.Errorf("synced %v before the batch flush", got)
+				}
+				flushBatch()
+				if got := synced(); !slices.Equal(got, want) {
+					t.Errorf("batch flush synced %v, want %v", got, want)
+				}
+			case syncNever:
+				flushBatch()
+				if got := synced(); len(got) != 0 {
+					t.Errorf("synced %v", got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// Encrypted pastes are encrypted in the browser with a key that only ever
+// lives in the URL fragment. The server stores the base64 ciphertext as an
+// opaque blob: it is never normalized, scanned or matched against the
+// blocklist, and raw access returns it unchanged.
+
+// cipherSuites lists the accepted cipher tags. The ciphertext is
+// base64(iv || ciphertext || tag).
+var cipherSuites = map[string]int{
+	"aes-256-gcm": 12 + 16, // IV plus authentication tag
+}
+
+// opaqueCiphers are decrypted outside the browser, by tools such as age.
+// Their body is the exact bytes sent, binary or not, and is served back
+// unchanged; only the format's plaintext header is checked.
+var opaqueCiphers = map[string][]string{
+	"age": {"age-encryption.org/v1\n", "-----BEGIN AGE ENCRYPTED FILE-----"},
+}
+
+// Opaque reports whether p is encrypted with one of opaqueCiphers, so the
+// browser can't decrypt it and its body may be binary.
+func (p *Paste) Opaque() bool {
+	_, ok := opaqueCiphers[p.Cipher]
+	return ok
+}
+
+// compressions lists how the plaintext may have been compressed before
+// encryption.
+var compressions = map[string]bool{
+	"none":    true,
+	"deflate": true,
+}
+
+// validateEncrypted checks the envelope of an encrypted paste without
+// looking at what is inside it.
+func validateEncrypted(cipher, compression, body string) error {
+	if headers, ok := opaqueCiphers[cipher]; ok {
+		if compression != "none" {
+			return errors.New("Unsupported compression")
+		}
+		for _, h := range headers {
+			if strings.HasPrefix(body, h) {
+				return nil
+			}
+		}
+		return errors.New("Content is not " + cipher + " encrypted")
+	}
+	overhead, ok := cipherSuites[cipher]
+	if !ok {
+		return errors.New("Unsupported cipher")
+	}
+	if !compressions[compression] {
+		return errors.New("Unsupported compression")
+	}
+	raw, err := base64.StdEncoding.Strict().DecodeString(body)
+	if err != nil {
+		return errors.New("Encrypted content must be base64")
+	}
+	if len(raw) <= overhead {
+		return errors.New("Encrypted content too short")
+	}
+	return nil
+}
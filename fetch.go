@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// internalPrefixes are never dialed when fetching a source_url unless the
+// operator explicitly allows them.
+var internalPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("224.0.0.0/4"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("::/128"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("64:ff9b::/96"), // NAT64, which can reach any IPv4 address
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("2002::/16"), // 6to4, likewise
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("ff00::/8"),
+}
+
+// fetchAllowed decides whether a resolved address may be dialed. The
+// operator allowlist wins over everything, then the denylist and the
+// built-in internal ranges block.
+func fetchAllowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range config.SourceURLAllow {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	for _, p := range config.SourceURLDeny {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	for _, p := range internalPrefixes {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// fetchClient checks every address at dial time, after DNS resolution, so a
+// hostname can't be rebound to an internal address between check and use.
+// Redirects go through the same dialer.
+var fetchClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(network, address string, c syscall.RawConn) error {
+				ap, err := netip.ParseAddrPort(address)
+				if err != nil {
+					return err
+				}
+				if !fetchAllowed(ap.Addr()) {
+					return fmt.Errorf("address %s is not allowed", ap.Addr())
+				}
+				return nil
+			},
+		}).DialContext,
+		ResponseHeaderTimeout: 5 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 3 {
+			return fmt.Errorf("too many redirects")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("unsupported redirect scheme")
+		}
+		return nil
+	},
+}
+
+// fetchSource downloads a remote text file to use as a paste body. At most
+// limit bytes are accepted.
+func fetchSource(ctx context.Context, rawURL string, limit int) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid source URL")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.SourceURLTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid source URL")
+	}
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not fetch source URL")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("source URL returned %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(limit)+1))
+	if err != nil {
+		return "", fmt.Errorf("could not fetch source URL")
+	}
+	if len(data) > limit {
+		return "", fmt.Errorf("source content too large")
+	}
+	return string(data), nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// gzipBody wraps a gzip request body so it is decompressed while the form
+// is parsed, stopping after limit decompressed bytes.
+type gzipBody struct {
+	io.Reader
+	gz   *gzip.Reader
+	body io.ReadCloser
+}
+
+func (b *gzipBody) Close() error {
+	b.gz.Close()
+	return b.body.Close()
+}
+
+// readForm parses the create form, decompressing gzip bodies first. Both
+// the compressed and the decompressed stream are capped at limit, so a
+// small zip bomb cannot expand into memory. The returned status is 0 on
+// success.
+func readForm(w http.ResponseWriter, r *http.Request, limit int64) (int, error) {
+	switch enc := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); enc {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return http.StatusBadRequest, errors.New("Invalid gzip body")
+		}
+		r.Body = &gzipBody{Reader: http.MaxBytesReader(w, io.NopCloser(gz), limit), gz: gz, body: r.Body}
+		r.Header.Del("Content-Encoding")
+		r.ContentLength = -1
+	default:
+		return http.StatusUnsupportedMediaType, fmt.Errorf("Unsupported Content-Encoding %q", enc)
+	}
+
+	// ParseMultipartForm drops urlencoded read errors, so parse those first
+	err := r.ParseForm()
+	if err == nil {
+		err = r.ParseMultipartForm(32 << 20)
+	}
+	if err == nil || errors.Is(err, http.ErrNotMultipart) {
+		return 0, nil
+	}
+	var overLimit *http.MaxBytesError
+	if errors.As(err, &overLimit) {
+		return http.StatusRequestEntityTooLarge, fmt.Errorf("Content too large (max %s)", formatSize(config.MaxBodySize))
+	}
+	if errors.Is(err, gzip.ErrChecksum) || errors.Is(err, gzip.ErrHeader) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return http.StatusBadRequest, errors.New("Invalid gzip body")
+	}
+	return http.StatusBadRequest, errors.New("Invalid request body")
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// startServer serves the paste routes through newServer on a local port
+// and returns its base URL.
+func startServer(t *testing.T) string {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/save", saveHandler)
+	mux.HandleFunc("/p/", pasteHandler)
+	srv := newServer(mux)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return "http://" + ln.Addr().String()
+}
+
+// h2cClient speaks only HTTP/2 with prior knowledge, as a load balancer
+// in front of tinypaste would.
+func h2cClient() *http.Client {
+	tr := &http.Transport{Protocols: new(http.Protocols)}
+	tr.Protocols.SetUnencryptedHTTP2(true)
+	return &http.Client{Transport: tr, CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+}
+
+func TestH2CRoundTrip(t *testing.T) {
+	useMemStore(t)
+	config.H2C = true
+	base := startServer(t)
+	client := h2cClient()
+	defer client.CloseIdleConnections()
+
+	// Large enough to take several DATA frames
+	body := strings.Repeat("0123456789abcdef\n", 20000)
+	form := url.Values{"title": {"over h2c"}, "body": {body}, "ttl": {"1h"}}
+	req, _ := http.NewRequest(http.MethodPost, base+"/save", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Proto != "HTTP/2.0" || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create: %s %d: %s", resp.Proto, resp.StatusCode, created)
+	}
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || loc.Path == "" {
+		t.Fatalf("create: Location %q", resp.Header.Get("Location"))
+	}
+
+	resp, err = client.Get(base + loc.Path + "/raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.Proto != "HTTP/2.0" || resp.StatusCode != http.StatusOK {
+		t.Fatalf("view: %s %d, %v", resp.Proto, resp.StatusCode, err)
+	}
+	if string(got) != body {
+		t.Errorf("view: got %d bytes, want the %d uploaded", len(got), len(body))
+	}
+
+	// HTTP/1.1 clients still work on the same port
+	resp, err = http.Get(base + loc.Path + "/raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Proto != "HTTP/1.1" || resp.StatusCode != http.StatusOK {
+		t.Errorf("HTTP/1.1 view: %s %d", resp.Proto, resp.StatusCode)
+	}
+}
+
+func TestH2COffByDefault(t *testing.T) {
+	useMemStore(t)
+	base := startServer(t)
+	client := h2cClient()
+	defer client.CloseIdleConnections()
+	if resp, err := client.Get(base + "/p/00aaaaaaaaaaaaaa"); err == nil {
+		resp.Body.Close()
+		t.Errorf("h2c request answered with %s %d, want it refused", resp.Proto, resp.StatusCode)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"html/template"
+	"slices"
+	"strings"
+)
+
+// syntax is what highlight needs to know about a language: how comments
+// and strings start and which words are keywords.
+type syntax struct {
+	line                 string // line comment, e.g. "//"
+	blockStart, blockEnd string // block comment, e.g. "/*" and "*/"
+	quotes               string // characters that open a string
+	keywords             []string
+	foldCase             bool // keywords match in any case, as in SQL
+}
+
+var (
+	cSyntax = &syntax{line: "//", blockStart: "/*", blockEnd: "*/", quotes: `"'`, keywords: strings.Fields(`
+		auto break case char const continue default do double else enum extern float for goto if
+		inline int long register return short signed sizeof static struct switch typedef union
+		unsigned void volatile while class namespace public private protected template typename
+		virtual new delete this true false nullptr bool`)}
+	goSyntax = &syntax{line: "//", blockStart: "/*", blockEnd: "*/", quotes: "\"'`", keywords: strings.Fields(`
+		break case chan const continue default defer else fallthrough for func go goto if import
+		interface map package range return select struct switch type var nil true false iota`)}
+	javaSyntax = &syntax{line: "//", blockStart: "/*", blockEnd: "*/", quotes: `"'`, keywords: strings.Fields(`
+		abstract boolean break byte case catch char class continue default do double else enum
+		extends final finally float for if implements import instanceof int interface long new
+		null package private protected public return short static super switch this throw
+		throws try void while true false var record`)}
+	jsSyntax = &syntax{line: "//", blockStart: "/*", blockEnd: "*/", quotes: "\"'`", keywords: strings.Fields(`
+		async await break case catch class const continue default delete do else export extends
+		false finally for function if import in instanceof let new null return super switch this
+		throw true try typeof undefined var void while yield interface type enum`)}
+	rustSyntax = &syntax{line: "//", blockStart: "/*", blockEnd: "*/", quotes: `"`, keywords: strings.Fields(`
+		as async await break const continue crate else enum extern false fn for if impl in let
+		loop match mod move mut pub ref return self Self static struct super trait true type
+		unsafe use where while`)}
+	pythonSyntax = &syntax{line: "#", quotes: `"'`, keywords: strings.Fields(`
+		and as assert async await break class continue def del elif else except False finally
+		for from global if import in is lambda None nonlocal not or pass raise return True try
+		while with yield`)}
+	shellSyntax = &syntax{line: "#", quotes: `"'`, keywords: strings.Fields(`
+		case do done elif else esac export fi for function if in local return then until while`)}
+	sqlSyntax = &syntax{line: "--", blockStart: "/*", blockEnd: "*/", quotes: `'"`, keywords: strings.Fields(`
+		select from where insert into values update set delete create table drop alter index
+		join left right inner outer on group by order having limit and or not null as distinct
+		primary key references union`), foldCase: true}
+	yamlSyntax = &syntax{line: "#", quotes: `"'`, keywords: strings.Fields(`true false null yes no`)}
+)
+
+// syntaxes maps the language hint, lowercased, to its syntax.
+var syntaxes = map[string]*syntax{
+	"c": cSyntax, "cpp": cSyntax, "c++": cSyntax, "h": cSyntax,
+	"go":   goSyntax,
+	"java": javaSyntax, "kotlin": javaSyntax,
+	"javascript": jsSyntax, "js": jsSyntax, "typescript": jsSyntax, "ts": jsSyntax,
+	"rust": rustSyntax, "rs": rustSyntax,
+	"python": pythonSyntax, "py": pythonSyntax,
+	"sh": shellSyntax, "bash": shellSyntax, "shell": shellSyntax,
+	"sql":  sqlSyntax,
+	"yaml": yamlSyntax, "yml": yamlSyntax,
+}
+
+// highlight renders body as HTML for a <pre>, with the comments, strings,
+// numbers and keywords of language wrapped in spans classed hl-c, hl-s,
+// hl-n and hl-k. All of the body is escaped and the spans are the only
+// markup added, so the result is safe to emit as is. Languages it doesn't
+// know come back escaped and nothing more.
+func highlight(body []byte, language string) template.HTML {
+	s := string(body)
+	sx := syntaxes[strings.ToLower(strings.TrimSpace(language))]
+	if sx == nil {
+		return template.HTML(template.HTMLEscapeString(s))
+	}
+	var b strings.Builder
+	span := func(class, text string) {
+		b.WriteString(`<span class="` + class + `">`)
+		b.WriteString(template.HTMLEscapeString(text))
+		b.WriteString(`</span>`)
+	}
+	for i := 0; i < len(s); {
+		rest := s[i:]
+		switch {
+		case sx.line != "" && strings.HasPrefix(rest, sx.line):
+			n := strings.IndexByte(rest, '\n')
+			if n < 0 {
+				n = len(rest)
+			}
+			span("hl-c", rest[:n])
+			i += n
+		case sx.blockStart != "" && strings.HasPrefix(rest, sx.blockStart):
+			n := len(rest)
+			if end := strings.Index(rest[len(sx.blockStart):], sx.blockEnd); end >= 0 {
+				n = len(sx.blockStart) + end + len(sx.blockEnd)
+			}
+			span("hl-c", rest[:n])
+			i += n
+		case strings.IndexByte(sx.quotes, rest[0]) >= 0:
+			n := stringLength(rest)
+			span("hl-s", rest[:n])
+			i += n
+		case isDigit(rest[0]) && (i == 0 || !isWordByte(s[i-1])):
+			n := 1
+			for n < len(rest) && (isWordByte(rest[n]) || rest[n] == '.') {
+				n++
+			}
+			span("hl-n", rest[:n])
+			i += n
+		case isWordByte(rest[0]):
+			n := 1
+			for n < len(rest) && isWordByte(rest[n]) {
+				n++
+			}
+			if word := rest[:n]; sx.isKeyword(word) {
+				span("hl-k", word)
+			} else {
+				b.WriteString(word)
+			}
+			i += n
+		default:
+			b.WriteString(template.HTMLEscapeString(rest[:1]))
+			i++
+		}
+	}
+	return template.HTML(b.String())
+}
+
+// stringLength returns the length of the string literal s starts with,
+// up to its closing quote. Backslashes escape the next byte, and only
+// backquoted strings run past the end of the line.
+func stringLength(s string) int {
+	quote := s[0]
+	for n := 1; n < len(s); n++ {
+		switch s[n] {
+		case '\\':
+			n++
+		case quote:
+			return n + 1
+		case '\n':
+			if quote != '`' {
+				return n
+			}
+		}
+	}
+	return len(s)
+}
+
+func (sx *syntax) isKeyword(word string) bool {
+	if sx.foldCase {
+		word = strings.ToLower(word)
+	}
+	return slices.Contains(sx.keywords, word)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
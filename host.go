@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// hostAllowed reports whether the request's Host header names this site.
+// In dev mode localhost passes too, so the app stays usable locally;
+// otherwise a spoofed localhost Host could get past the list.
+func hostAllowed(host string) bool {
+	if len(config.AllowedHosts) == 0 {
+		return true
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	if config.Dev {
+		switch host {
+		case "localhost", "127.0.0.1", "::1":
+			return true
+		}
+	}
+	return slices.Contains(config.AllowedHosts, host)
+}
+
+// requestOrigin returns the scheme and host the client addressed, such as
+// https://paste.example.com. Behind a trusted proxy, X-Forwarded-Proto and
+// X-Forwarded-Host say what the proxy was asked for; otherwise they are
+// ignored, since any client could send them.
+func requestOrigin(r *http.Request) string {
+	scheme, host := "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if config.TrustProxy {
+		if proto := forwardedValue(r, "X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if fwd := forwardedValue(r, "X-Forwarded-Host"); fwd != "" && hostAllowed(fwd) {
+			if u, err := url.Parse("//" + fwd); err == nil && u.Host == fwd && u.User == nil {
+				host = fwd
+			}
+		}
+	}
+	return scheme + "://" + host
+}
+
+// forwardedValue returns the last value of a forwarding header, the one
+// added by the proxy in front of tinypaste, as clientIP does for
+// X-Forwarded-For.
+func forwardedValue(r *http.Request, name string) string {
+	values := strings.Split(r.Header.Get(name), ",")
+	return strings.ToLower(strings.TrimSpace(values[len(values)-1]))
+}
+
+// checkHost rejects requests for unknown hosts before any handler can
+// build links or redirects from a spoofed Host header.
+func checkHost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hostAllowed(r.Host) {
+			http.Error(w, "Invalid host", http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAbsoluteURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		baseURL  string
+		basePath string
+		trust    bool
+		allowed  []string
+		tls      bool
+		header   map[string]string
+		want     string
+	}{
+		// Configured: the request has no say
+		{"configured", "https://paste.example.com", "", false, nil, false, nil,
+			"https://paste.example.com/p/abc"},
+		{"configured ignores proxy", "https://paste.example.com", "", true, nil, false,
+			map[string]string{"X-Forwarded-Proto": "http", "X-Forwarded-Host": "evil.example"},
+			"https://paste.example.com/p/abc"},
+		{"configured with base path", "https://intranet.example.com", "/paste", false, nil, false, nil,
+			"https://intranet.example.com/paste/p/abc"},
+
+		// Derived from the request
+		{"derived", "", "", false, nil, false, nil, "http://localhost:8080/p/abc"},
+		{"derived TLS", "", "", false, nil, true, nil, "https://localhost:8080/p/abc"},
+		{"untrusted forwarding ignored", "", "", false, nil, false,
+			map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "paste.example.com"},
+			"http://localhost:8080/p/abc"},
+		{"trusted proxy", "", "", true, nil, false,
+			map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "paste.example.com"},
+			"https://paste.example.com/p/abc"},
+		{"trusted proxy, last hop", "", "", true, nil, false,
+			map[string]string{"X-Forwarded-Proto": "http, https", "X-Forwarded-Host": "evil.example, paste.example.com"},
+			"https://paste.example.com/p/abc"},
+		{"trusted proxy, odd scheme", "", "", true, nil, false,
+			map[string]string{"X-Forwarded-Proto": "javascript"},
+			"http://localhost:8080/p/abc"},
+		{"trusted proxy, host not allowed", "", "", true, []string{"localhost", "paste.example.com"}, false,
+			map[string]string{"X-Forwarded-Host": "evil.example"},
+			"http://localhost:8080/p/abc"},
+		{"trusted proxy, host with userinfo", "", "", true, nil, false,
+			map[string]string{"X-Forwarded-Host": "user@evil.example"},
+			"http://localhost:8080/p/abc"},
+		{"derived with base path", "", "/paste", true, nil, false,
+			map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "intranet.example.com"},
+			"https://intranet.example.com/paste/p/abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t)
+			config.BaseURL = tt.baseURL
+			config.BasePath = tt.basePath
+			config.TrustProxy = tt.trust
+			config.AllowedHosts = tt.allowed
+			r := httptest.NewRequest(http.MethodGet, "/p/abc", nil)
+			r.Host = "localhost:8080"
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			if got := absoluteURL(r, "/p/abc"); got != tt.want {
+				t.Errorf("absoluteURL = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// The API answers with URLs from BASE_URL, not the Host it was reached at.
+func TestBaseURLInAPIResponse(t *testing.T) {
+	for _, tt := range []struct{ baseURL, want string }{
+		{"https://paste.example.com", "https://paste.example.com/p/"},
+		{"", "http://example.com/p/"},
+	} {
+		useMemStore(t)
+		config.BaseURL = tt.baseURL
+		w := postSave(t, url.Values{"title": {"t"}, "body": {"hello"}, "ttl": {"1h"}})
+		var created struct{ URL string }
+		if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || w.Code != http.StatusCreated {
+			t.Fatalf("save: status %d: %s", w.Code, w.Body)
+		}
+		if !strings.HasPrefix(created.URL, tt.want) {
+			t.Errorf("BASE_URL %q: url = %q, want it under %s", tt.baseURL, created.URL, tt.want)
+		}
+		if loc := w.Header().Get("Location"); loc != created.URL {
+			t.Errorf("BASE_URL %q: Location = %q, want %q", tt.baseURL, loc, created.URL)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// defaultLang is the language pages fall back to. Its catalog must have
+// every key the templates use.
+const defaultLang = "en"
+
+const langCookie = "lang"
+
+// catalogs maps a language code to its messages, one catalog per file in
+// locales/.
+var catalogs = func() map[string]map[string]string {
+	files, _ := localeFiles.ReadDir("locales")
+	m := make(map[string]map[string]string)
+	for _, f := range files {
+		data, err := localeFiles.ReadFile("locales/" + f.Name())
+		if err != nil {
+			panic(err)
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			panic(fmt.Sprintf("locales/%s: %v", f.Name(), err))
+		}
+		m[strings.TrimSuffix(f.Name(), path.Ext(f.Name()))] = messages
+	}
+	return m
+}()
+
+// languages lists the languages there are catalogs for, sorted.
+var languages = func() []string {
+	var langs []string
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}()
+
+// missingKeys remembers keys already warned about, so a missing
+// translation is logged once rather than on every page view.
+var missingKeys sync.Map
+
+// translate returns the message for key in lang, formatted with args if
+// there are any. Keys missing from lang fall back to the default language.
+func translate(lang, key string, args ...any) string {
+	msg, ok := catalogs[lang][key]
+	if !ok {
+		if _, warned := missingKeys.LoadOrStore(lang+":"+key, true); !warned {
+			slog.Warn("missing translation", "lang", lang, "key", key)
+		}
+		if msg, ok = catalogs[defaultLang][key]; !ok {
+			return key
+		}
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// requestLang picks the language for a page: a ?lang= override, then the
+// lang cookie, then the best match in Accept-Language.
+func requestLang(r *http.Request) string {
+	if lang := r.URL.Query().Get("lang"); catalogs[lang] != nil {
+		return lang
+	}
+	if c, err := r.Cookie(langCookie); err == nil && catalogs[c.Value] != nil {
+		return c.Value
+	}
+	return acceptLanguage(r.Header.Get("Accept-Language"))
+}
+
+// acceptLanguage returns the supported language the header prefers most,
+// matching regional variants such as fr-CA to their base language.
+func acceptLanguage(header string) string {
+	best, bestQ := defaultLang, 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		base, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if catalogs[base] != nil && q > bestQ {
+			best, bestQ = base, q
+		}
+	}
+	return best
+}
+
+// rememberLang keeps a ?lang= choice in a cookie for later pages.
+func rememberLang(w http.ResponseWriter, r *http.Request) {
+	lang := r.URL.Query().Get("lang")
+	if catalogs[lang] == nil {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     langCookie,
+		Value:    lang,
+		Path:     sitePath("/"),
+		MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// usedKeys collects the catalog keys the templates and Go code ask for,
+// including the ones built from the TTL and theme lists.
+func usedKeys(t *testing.T) []string {
+	t.Helper()
+	keys := map[string]bool{}
+	patterns := map[string]*regexp.Regexp{
+		".html": regexp.MustCompile(`\bT "([^"]+)"`),
+		".go":   regexp.MustCompile(`translate\(\w+, "([^"]+)"`),
+	}
+	for _, dir := range []string{"templates", "."} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			re := patterns[filepath.Ext(e.Name())]
+			if re == nil || strings.HasSuffix(e.Name(), "_test.go") {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, m := range re.FindAllStringSubmatch(string(data), -1) {
+				keys[m[1]] = true
+			}
+		}
+	}
+	for _, ttl := range ttlOptions {
+		keys["ttl."+ttl] = true
+	}
+	for _, theme := range themes {
+		keys["theme."+theme] = true
+	}
+	if len(keys) < 20 {
+		t.Fatalf("found only %d keys in use; is the pattern still right?", len(keys))
+	}
+	return slices.Sorted(func(yield func(string) bool) {
+		for k := range keys {
+			if !yield(k) {
+				return
+			}
+		}
+	})
+}
+
+func TestDefaultCatalogHasUsedKeys(t *testing.T) {
+	for _, key := range usedKeys(t) {
+		if _, ok := catalogs[defaultLang][key]; !ok {
+			t.Errorf("%s is used but missing from locales/%s.json", key, defaultLang)
+		}
+	}
+}
+
+// Every locale translates every key, with the same format verbs, and has
+// no keys the default doesn't.
+func TestLocalesCoverCatalog(t *testing.T) {
+	files, err := fs.Glob(localeFiles, "locales/*.json")
+	if err != nil || len(files) < 2 {
+		t.Fatalf("locales: %v, %v", files, err)
+	}
+	verbs := regexp.MustCompile(`%[-+# 0-9.]*[a-zA-Z%]`)
+	base := catalogs[defaultLang]
+	for _, lang := range languages {
+		messages := catalogs[lang]
+		for key, msg := range base {
+			got, ok := messages[key]
+			if !ok {
+				t.Errorf("%s: missing %s", lang, key)
+				continue
+			}
+			if strings.TrimSpace(got) == "" {
+				t.Errorf("%s: %s is empty", lang, key)
+			}
+			if want, have := verbs.FindAllString(msg, -1), verbs.FindAllString(got, -1); !slices.Equal(want, have) {
+				t.Errorf("%s: %s has format verbs %v, want %v", lang, key, have, want)
+			}
+		}
+		for key := range messages {
+			if _, ok := base[key]; !ok {
+				t.Errorf("%s: %s isn't in locales/%s.json", lang, key, defaultLang)
+			}
+		}
+	}
+}
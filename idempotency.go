@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// idemEntry remembers the response a create sent for an idempotency key.
+// A nil resp means the first request is still in flight. sum fingerprints
+// the submission the key was first used for.
+type idemEntry struct {
+	resp    *idemResponse
+	sum     string
+	used    time.Time
+	expires time.Time
+}
+
+// idemResponse is a create response kept to be sent again on a retry.
+type idemResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+var (
+	idemMu   sync.Mutex
+	idemKeys = make(map[string]idemEntry)
+)
+
+// idempotencyKey returns the client's key, scoped to the client so two
+// clients picking the same key don't collide, and the fingerprint of the
+// submission. Empty means no key was sent.
+func idempotencyKey(r *http.Request) (key, sum string) {
+	sum = submissionHash(r)
+	key = r.Header.Get("Idempotency-Key")
+	if key == "" {
+		key = r.FormValue("idempotency_key")
+	}
+	if len(key) > 255 {
+		return "", ""
+	}
+	// Each served form submits once, so refreshing the created page
+	// replays instead of making a duplicate paste. Going back and changing
+	// the form is a new submission, not a retry.
+	if token := r.FormValue("form_token"); key == "" && token != "" {
+		if _, ok := formAge(token); ok {
+			key = "form:" + token + ":" + sum
+		}
+	}
+	if key == "" {
+		return "", ""
+	}
+	return quotaKey(r) + "|" + key, sum
+}
+
+// idemVolatile are fields that differ between submissions of the same
+// paste, left out of its fingerprint.
+var idemVolatile = []string{"form_token", "idempotency_key", "pow_challenge", "pow_nonce"}
+
+// submissionHash fingerprints what a create request asks for, with the
+// body normalized as it would be saved.
+func submissionHash(r *http.Request) string {
+	h := sha256.New()
+	for _, k := range slices.Sorted(maps.Keys(r.Form)) {
+		if slices.Contains(idemVolatile, k) {
+			continue
+		}
+		for _, v := range r.Form[k] {
+			if k == "body" {
+				v = normalizeBody(v)
+			}
+			fmt.Fprintf(h, "%s %d\n", k, len(v))
+			io.WriteString(h, v)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// claimIdempotency looks up key. If it's new, it is reserved for this
+// request and ok is true; otherwise e is what the earlier request left.
+func claimIdempotency(key, sum string) (e idemEntry, ok bool) {
+	now := time.Now()
+	idemMu.Lock()
+	defer idemMu.Unlock()
+
+	if e, found := idemKeys[key]; found && now.Before(e.expires) {
+		return e, false
+	}
+	idemKeys[key] = idemEntry{sum: sum, used: now, expires: now.Add(config.IdempotencyTTL)}
+	return idemEntry{}, true
+}
+
+// completeIdempotency records the response sent for a claimed key.
+func completeIdempotency(key string, rec *idemRecorder) {
+	now := time.Now()
+	resp := &idemResponse{status: rec.status, header: rec.Header().Clone(), body: bytes.Clone(rec.body.Bytes())}
+	idemMu.Lock()
+	e := idemKeys[key]
+	e.resp, e.used, e.expires = resp, now, now.Add(config.IdempotencyTTL)
+	idemKeys[key] = e
+	idemMu.Unlock()
+}
+
+// replayIdempotent sends a recorded response again, headers and all, so a
+// retry gets exactly what the first request got. The request ID stays the
+// retry's own.
+func replayIdempotent(w http.ResponseWriter, resp *idemResponse) {
+	for k, v := range resp.header {
+		if k != requestIDHeader {
+			w.Header()[k] = slices.Clone(v)
+		}
+	}
+	w.WriteHeader(resp.status)
+	w.Write(resp.body)
+}
+
+// idemRecorder copies the response a create writes, for completeIdempotency.
+type idemRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idemRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idemRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *idemRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// sweepIdempotency drops keys that have expired or outlived STATE_MAX_AGE.
+func sweepIdempotency(now time.Time) {
+	idemMu.Lock()
+	defer idemMu.Unlock()
+	for k, e := range idemKeys {
+		if now.After(e.expires) || now.Sub(e.used) > config.StateMaxAge {
+			delete(idemKeys, k)
+		}
+	}
+}
+
+// releaseIdempotency frees a claimed key whose request failed, so a retry
+// can go through. It does nothing once the key is completed.
+func releaseIdempotency(key string) {
+	idemMu.Lock()
+	if idemKeys[key].resp == nil {
+		delete(idemKeys, key)
+	}
+	idemMu.Unlock()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// idSource supplies the randomness behind paste IDs, secrets and delete
+// tokens. When it fails, creation fails; nothing falls back to a weaker
+// source, since a predictable or repeated ID would hand out other people's
+// pastes.
+var idSource io.Reader = rand.Reader
+
+// randomHex returns n bytes from idSource, hex encoded.
+func randomHex(n int) (string, error) {
+	b, err := randomBytes(n)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// randomBytes returns n bytes from idSource.
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(idSource, b); err != nil {
+		return nil, fmt.Errorf("read random bytes: %w", err)
+	}
+	return b, nil
+}
+
+// mustRandomBytes is randomBytes for keys made at startup, which the
+// server can't run without.
+func mustRandomBytes(n int) []byte {
+	b, err := randomBytes(n)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// idAttempts bounds how often generateID draws again after a collision.
+const idAttempts = 5
+
+// Paste IDs are 16 hex characters, too many to guess, or 8 for creators
+// who ask for a short link and accept that others may find it. The
+// length alone tells them apart.
+const (
+	longIDBytes  = 8
+	shortIDBytes = 4
+)
+
+// IDs handed out whose paste isn't written yet. The check for a free ID
+// and the reservation happen under idMu, so two creates can't be given
+// the same ID while neither file exists, and one paste can't replace or
+// shadow another.
+var (
+	idMu       sync.Mutex
+	idReserved = make(map[string]bool)
+)
+
+// generateID returns a new paste ID, short if asked, that no paste,
+// tombstone or takedown notice uses yet, and reserves it. The caller
+// calls releaseID once the paste is saved or given up. Every paste ID is
+// made here.
+func generateID(short bool) (string, error) {
+	n := longIDBytes
+	if short {
+		n = shortIDBytes
+	}
+	for range idAttempts {
+		suffix, err := randomHex(n)
+		if err != nil {
+			return "", err
+		}
+		id := config.IDPrefix + suffix
+		idMu.Lock()
+		free := !idReserved[id] && !idTaken(id)
+		if free {
+			idReserved[id] = true
+		}
+		idMu.Unlock()
+		if free {
+			return id, nil
+		}
+	}
+	return "", errors.New("no unused paste ID found")
+}
+
+// releaseID drops the reservation generateID made. After a save the
+// paste file itself keeps the ID taken.
+func releaseID(id string) {
+	idMu.Lock()
+	delete(idReserved, id)
+	idMu.Unlock()
+}
+
+// idTaken reports whether id is in use or was used before. Anything
+// that can't be checked counts as taken.
+func idTaken(id string) bool {
+	if files, _ := store.Glob(bucket(id) + "/" + id + "_*.txt"); len(files) > 0 {
+		return true
+	}
+	for _, path := range []string{tombstonePath(id), noticePath(id)} {
+		if _, err := store.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// failingReader stands in for a random source that has broken.
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("entropy unavailable")
+}
+
+func withFailingRandom(t *testing.T) {
+	t.Helper()
+	old := idSource
+	idSource = failingReader{}
+	t.Cleanup(func() { idSource = old })
+}
+
+func TestRandomFailureFailsCreation(t *testing.T) {
+	withFailingRandom(t)
+
+	for _, short := range []bool{false, true} {
+		if id, err := generateID(short); err == nil {
+			t.Errorf("generateID(%v) = %q, want an error", short, id)
+		}
+	}
+	if secret, err := generateSecret(); err == nil {
+		t.Errorf("generateSecret() = %q, want an error", secret)
+	}
+	if token, err := generateDeleteToken(); err == nil {
+		t.Errorf("generateDeleteToken() = %q, want an error", token)
+	}
+}
+
+func TestRandomFailureFailsChallenge(t *testing.T) {
+	withFailingRandom(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/challenge", nil)
+	if challenge, _, err := newChallenge(r); err == nil {
+		t.Errorf("newChallenge() = %q, want an error", challenge)
+	}
+}
+
+func TestRandomFailureFailsFormKey(t *testing.T) {
+	withFailingRandom(t)
+	oldKey, oldSecret := formKey, config.FormSecret
+	t.Cleanup(func() { formKey, config.FormSecret = oldKey, oldSecret })
+
+	config.FormSecret = ""
+	if err := initFormKey(); err == nil {
+		t.Error("initFormKey() succeeded, want an error")
+	}
+	config.FormSecret = "configured"
+	if err := initFormKey(); err != nil {
+		t.Errorf("initFormKey() with FORM_SECRET = %v, want no error", err)
+	}
+}
+
+func TestRandomFailureFailsNonce(t *testing.T) {
+	withFailingRandom(t)
+
+	called := false
+	h := securityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if called {
+		t.Error("handler ran without a CSP nonce")
+	}
+}
+
+func TestRandomHex(t *testing.T) {
+	s, err := randomHex(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s) != 16 {
+		t.Errorf("randomHex(8) = %q, want 16 hex characters", s)
+	}
+}
+
+func TestValidIDPrefix(t *testing.T) {
+	for prefix, want := range map[string]bool{
+		"":                  true,
+		"team-a-":           true,
+		"abz":               true,
+		"ab-12345678-":      true,
+		"ab":                false,
+		"ab12345678":        false,
+		"team-1":            false,
+		"Team-":             false,
+		"a_b-":              false,
+		"abcdefghijklmnop-": false,
+	} {
+		if got := validIDPrefix(prefix); got != want {
+			t.Errorf("validIDPrefix(%q) = %v, want %v", prefix, got, want)
+		}
+	}
+}
+
+// No instance takes another's pastes for its own, long or short, even
+// when one prefix starts with the other.
+func TestIDPrefixesDontOverlap(t *testing.T) {
+	useConfig(t)
+	prefixes := []string{"", "ab-", "ab-12345678-", "ab-c-", "abz"}
+	ids := map[string][]string{}
+	for _, prefix := range prefixes {
+		ids[prefix] = []string{prefix + "0123456789abcdef", prefix + "89abcdef"}
+	}
+	for _, prefix := range prefixes {
+		config.IDPrefix = prefix
+		for owner, owned := range ids {
+			for _, id := range owned {
+				if got := isValidID(id); got != (owner == prefix) {
+					t.Errorf("prefix %q: isValidID(%q) = %v", prefix, id, got)
+				}
+			}
+		}
+	}
+}
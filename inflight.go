@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+)
+
+// inFlight counts the requests each client has in progress, by clientKey.
+var (
+	inFlightMu sync.Mutex
+	inFlight   = make(map[string]int)
+)
+
+// limitInFlight turns away a client that already has MAX_INFLIGHT_PER_IP
+// requests in progress with a 429, so one source holding many slow
+// requests open can't take every connection the server has. The header
+// timeouts deal with slow headers; this covers slow bodies and slow
+// readers.
+func limitInFlight(next http.Handler) http.Handler {
+	if config.MaxInFlightPerIP <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := clientKey(r)
+		inFlightMu.Lock()
+		if inFlight[key] >= config.MaxInFlightPerIP {
+			inFlightMu.Unlock()
+			metrics.Inc("inflight_rejected_total")
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many requests in progress, please try again shortly.", http.StatusTooManyRequests)
+			return
+		}
+		inFlight[key]++
+		inFlightMu.Unlock()
+
+		defer func() {
+			inFlightMu.Lock()
+			if inFlight[key]--; inFlight[key] == 0 {
+				delete(inFlight, key)
+			}
+			inFlightMu.Unlock()
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
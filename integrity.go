@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// integrityDir is where a repairing integrity scan moves bad files.
+const integrityDir = "pastes/quarantine"
+
+// orphanAge is how old a temporary file must be before the integrity scan
+// counts it as left behind by an interrupted save.
+const orphanAge = time.Hour
+
+// integrityReport counts what an integrity scan found.
+type integrityReport struct {
+	Files      int
+	BadNames   int
+	BadTTLs    int
+	Truncated  int
+	Orphans    int
+	Duplicates int
+	Moved      int
+}
+
+func (r integrityReport) problems() int {
+	return r.BadNames + r.BadTTLs + r.Truncated + r.Orphans + r.Duplicates
+}
+
+// integrityMu keeps integrity scans from overlapping.
+var integrityMu sync.Mutex
+
+// scanIntegrity walks every bucket and logs files that can't be served or
+// cleaned up: names that don't match <id>_<ttl>.txt, unknown TTLs, empty
+// files or files without a complete header line, and temporary files left
+// by interrupted saves. Where several files exist for one ID, all but the
+// one loadPaste serves are flagged too. With repair, those files are moved
+// to integrityDir rather than deleted. Files are only read or renamed, so
+// the scan is safe while serving. ok is false if another scan is already running.
+func scanIntegrity(repair bool) (report integrityReport, ok bool) {
+	if !integrityMu.TryLock() {
+		return report, false
+	}
+	defer integrityMu.Unlock()
+
+	now := time.Now()
+	for i := 0; i < 256; i++ {
+		dir := fmt.Sprintf("pastes/%02x", i)
+		entries, err := store.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		byID := make(map[string][]string)
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			report.Files++
+			path := filepath.Join(dir, entry.Name())
+			problem := checkFile(path, entry.Name(), now)
+			switch problem {
+			case "":
+				if id, _, ok := strings.Cut(entry.Name(), "_"); ok && strings.HasSuffix(entry.Name(), ".txt") && isValidID(id) {
+					byID[id] = append(byID[id], path)
+				}
+				continue
+			case "malformed name":
+				report.BadNames++
+			case "unknown ttl":
+				report.BadTTLs++
+			case "orphaned temp file":
+				report.Orphans++
+			default:
+				report.Truncated++
+			}
+			slog.Warn("integrity problem", "path", path, "problem", problem)
+			if repair && quarantineFile(path, fmt.Sprintf("%02x-%s", i, entry.Name())) {
+				report.Moved++
+			}
+		}
+		for id, files := range byID {
+			if len(files) < 2 {
+				continue
+			}
+			served := pickPasteFile(files)
+			for _, path := range files {
+				if path == served {
+					continue
+				}
+				report.Duplicates++
+				slog.Warn("integrity problem", "path", path, "problem", "duplicate", "id", id, "served", served)
+				if repair && quarantineFile(path, fmt.Sprintf("%02x-%s", i, filepath.Base(path))) {
+					report.Moved++
+				}
+			}
+		}
+	}
+	slog.Info("integrity scan done", "files", report.Files, "bad_names", report.BadNames,
+		"bad_ttls", report.BadTTLs, "truncated", report.Truncated, "orphans", report.Orphans,
+		"duplicates", report.Duplicates, "moved", report.Moved)
+	return report, true
+}
+
+// checkFile returns what is wrong with the bucket file at path, or "".
+// Pastes of instances with another ID prefix are left alone.
+func checkFile(path, name string, now time.Time) string {
+	switch {
+	case strings.HasSuffix(name, ".gone"), strings.HasSuffix(name, ".comments"):
+		return ""
+	case strings.HasSuffix(name, ".tmp"):
+		info, err := store.Stat(path)
+		if err == nil && now.Sub(info.ModTime()) > orphanAge {
+			return "orphaned temp file"
+		}
+		return ""
+	case !strings.HasSuffix(name, ".txt"):
+		return "malformed name"
+	}
+
+	id, ttl, ok := strings.Cut(strings.TrimSuffix(name, ".txt"), "_")
+	if !ok || id == "" || strings.Contains(ttl, "_") {
+		return "malformed name"
+	}
+	if _, exists := TTLHours[ttl]; !exists {
+		return "unknown ttl"
+	}
+	if !isValidID(id) {
+		return ""
+	}
+	info, err := store.Stat(path)
+	if err != nil {
+		return ""
+	}
+	if info.Size() == 0 {
+		return "empty file"
+	}
+	if _, err := readHeader(path); err != nil {
+		return "truncated header"
+	}
+	return ""
+}
+
+// quarantineFile moves a bad file into integrityDir under name, reporting
+// whether it did.
+func quarantineFile(path, name string) bool {
+	if err := store.Rename(path, filepath.Join(integrityDir, name)); err != nil {
+		slog.Error("move bad file", "path", path, "err", err)
+		return false
+	}
+	return true
+}
+
+// integrityHandler serves POST /admin/integrity, which runs an integrity
+// scan and answers with its summary. repair=1 moves bad files aside.
+func integrityHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	repair := r.FormValue("repair") == "1"
+	report, ok := scanIntegrity(repair)
+	if !ok {
+		http.Error(w, "An integrity scan is already running", http.StatusConflict)
+		return
+	}
+	if repair && report.Moved > 0 {
+		logAction(operatorAction{Action: "integrity-repair", Reason: fmt.Sprintf("moved %d files", report.Moved)})
+	}
+	writeJSON(w, http.StatusOK, map[string]int{
+		"files":      report.Files,
+		"problems":   report.problems(),
+		"bad_names":  report.BadNames,
+		"bad_ttls":   report.BadTTLs,
+		"truncated":  report.Truncated,
+		"orphans":    report.Orphans,
+		"duplicates": report.Duplicates,
+		"moved":      report.Moved,
+	})
+}
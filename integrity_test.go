@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// plantDuplicate stores another file for id, as a crashed migration or a
+// hand-copied backup would leave, titled after its TTL.
+func plantDuplicate(t *testing.T, id, ttl string, created time.Time) string {
+	t.Helper()
+	p := &Paste{ID: id, Title: ttl, Body: []byte("body " + ttl + "\n"), TTL: ttl, Normalized: true}
+	if err := p.save(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Chtimes(p.path(), created); err != nil {
+		t.Fatal(err)
+	}
+	return p.path()
+}
+
+func TestDuplicatePasteFiles(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	type file struct {
+		ttl string
+		age time.Duration
+	}
+	tests := []struct {
+		name    string
+		files   []file
+		want    string
+		expired bool
+	}{
+		// Expiring in 22h, 1h and 1h
+		{"latest expiry wins", []file{{"1h", 0}, {"24h", 2 * time.Hour}, {"7d", 167 * time.Hour}}, "24h", false},
+		{"shorter TTL, later expiry", []file{{"1h", 0}, {"3h", 150 * time.Minute}}, "1h", false},
+		// Both expire an hour from now: the first name wins
+		{"tie", []file{{"3h", 2 * time.Hour}, {"1h", 0}}, "1h", false},
+		{"all expired", []file{{"1h", 3 * time.Hour}, {"3h", 4 * time.Hour}}, "3h", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useDiskStore(t)
+			captureLog(t)
+			id := "00aaaaaaaaaaaaaa"
+			var paths []string
+			for _, f := range tt.files {
+				paths = append(paths, plantDuplicate(t, id, f.ttl, now.Add(-f.age)))
+			}
+
+			// Served the same whatever order the directory lists them in
+			for range 3 {
+				if got := filepath.Base(pickPasteFile(paths)); got != id+"_"+tt.want+".txt" {
+					t.Fatalf("picked %s, want the %s file", got, tt.want)
+				}
+				paths = append(paths[1:], paths[0])
+			}
+			p, err := loadPaste(id)
+			if tt.expired {
+				// Expired files are cleanup's to remove, however many
+				if err != ErrExpired {
+					t.Errorf("load: %v, want %v", err, ErrExpired)
+				}
+				cleanupExpired()
+				if left, _ := filepath.Glob(filepath.Join(bucket(id), id+"_*")); len(left) != 0 {
+					t.Errorf("after cleanup: %v", left)
+				}
+				return
+			}
+			if err != nil || p.Title != tt.want {
+				t.Fatalf("load: %v, %v; want the %s file", p, err, tt.want)
+			}
+
+			report, ok := scanIntegrity(false)
+			if !ok || report.Duplicates != len(tt.files)-1 || report.Moved != 0 {
+				t.Errorf("scan: %+v, want %d duplicates and none moved", report, len(tt.files)-1)
+			}
+
+			// Repair moves the others aside and keeps the one served
+			report, _ = scanIntegrity(true)
+			if report.Moved != len(tt.files)-1 {
+				t.Errorf("repair moved %d files, want %d", report.Moved, len(tt.files)-1)
+			}
+			for _, path := range paths {
+				_, err := os.Stat(path)
+				kept := filepath.Base(path) == id+"_"+tt.want+".txt"
+				if kept != (err == nil) {
+					t.Errorf("%s: kept %v after repair, want %v", path, err == nil, kept)
+				}
+			}
+			quarantined, _ := filepath.Glob(filepath.Join(integrityDir, "*"))
+			if len(quarantined) != len(tt.files)-1 {
+				t.Errorf("quarantine holds %v", quarantined)
+			}
+			if report, _ := scanIntegrity(false); report.Duplicates != 0 {
+				t.Errorf("after repair: %d duplicates", report.Duplicates)
+			}
+		})
+	}
+}
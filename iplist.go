@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	ipAllow atomic.Pointer[[]netip.Prefix]
+	ipDeny  atomic.Pointer[[]netip.Prefix]
+)
+
+// loadIPList reads one CIDR or address per line; # starts a comment.
+func loadIPList(path string) ([]netip.Prefix, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var prefixes []netip.Prefix
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		entry, _, _ := strings.Cut(scanner.Text(), "#")
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		p, err := parsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, scanner.Err()
+}
+
+// loadIPLists (re)loads the configured allow and deny files. Nothing is
+// replaced unless both load cleanly.
+func loadIPLists() error {
+	var allow, deny []netip.Prefix
+	var err error
+	if config.IPAllowFile != "" {
+		if allow, err = loadIPList(config.IPAllowFile); err != nil {
+			return err
+		}
+	}
+	if config.IPDenyFile != "" {
+		if deny, err = loadIPList(config.IPDenyFile); err != nil {
+			return err
+		}
+	}
+	ipAllow.Store(&allow)
+	ipDeny.Store(&deny)
+	slog.Info("loaded ip lists", "allow", len(allow), "deny", len(deny))
+	return nil
+}
+
+func prefixesContain(prefixes *[]netip.Prefix, addr netip.Addr) bool {
+	if prefixes == nil {
+		return false
+	}
+	for _, p := range *prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipVerdict decides whether a client may make the request and, if not,
+// which list refused it. Precedence:
+//   - the deny list wins over everything and applies to every route;
+//   - an empty allow list allows everyone;
+//   - otherwise only allowed addresses pass, on every route or, with
+//     write-only set, on writes (anything but GET and HEAD).
+//
+// An unparseable client address is treated as not allowed but not denied.
+func ipVerdict(ip string, write bool) (ok bool, list string) {
+	addr, err := netip.ParseAddr(ip)
+	if err == nil {
+		addr = addr.Unmap()
+	}
+	if err == nil && prefixesContain(ipDeny.Load(), addr) {
+		return false, "deny"
+	}
+	allow := ipAllow.Load()
+	if allow == nil || len(*allow) == 0 || (config.IPAllowWriteOnly && !write) {
+		return true, ""
+	}
+	if err == nil && prefixesContain(allow, addr) {
+		return true, ""
+	}
+	return false, "allow"
+}
+
+// checkIP refuses requests from blocked networks with a 403.
+func checkIP(next http.Handler) http.Handler {
+	if config.IPAllowFile == "" && config.IPDenyFile == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		write := r.Method != http.MethodGet && r.Method != http.MethodHead
+		if ok, list := ipVerdict(clientIP(r), write); !ok {
+			metrics.Inc(fmt.Sprintf(`ip_blocked_total{list="%s"}`, list))
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
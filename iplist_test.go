@@ -0,0 +1,177 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useIPLists sets the allow and deny lists for the rest of the test.
+func useIPLists(t *testing.T, allow, deny []string) {
+	t.Helper()
+	oldAllow, oldDeny := ipAllow.Load(), ipDeny.Load()
+	t.Cleanup(func() {
+		ipAllow.Store(oldAllow)
+		ipDeny.Store(oldDeny)
+	})
+	parse := func(entries []string) *[]netip.Prefix {
+		if entries == nil {
+			return nil
+		}
+		prefixes := []netip.Prefix{}
+		for _, e := range entries {
+			p, err := parsePrefix(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			prefixes = append(prefixes, p)
+		}
+		return &prefixes
+	}
+	ipAllow.Store(parse(allow))
+	ipDeny.Store(parse(deny))
+}
+
+func TestIPVerdict(t *testing.T) {
+	office := []string{"203.0.113.0/24", "2001:db8:1::/48"}
+	tests := []struct {
+		name      string
+		allow     []string
+		deny      []string
+		writeOnly bool
+		ip        string
+		write     bool
+		wantOK    bool
+		wantList  string
+	}{
+		{"no lists", nil, nil, false, "198.51.100.7", false, true, ""},
+		{"empty allow list allows all", []string{}, nil, false, "198.51.100.7", true, true, ""},
+		{"denied", nil, []string{"198.51.100.0/24"}, false, "198.51.100.7", false, false, "deny"},
+		{"not denied", nil, []string{"198.51.100.0/24"}, false, "198.51.101.7", true, true, ""},
+		{"deny wins over allow", []string{"198.51.100.0/24"}, []string{"198.51.100.7"}, false, "198.51.100.7", false, false, "deny"},
+		{"deny applies to reads with write-only allow", office, []string{"198.51.100.0/24"}, true, "198.51.100.7", false, false, "deny"},
+		{"allowed", office, nil, false, "203.0.113.9", true, true, ""},
+		{"allowed v6", office, nil, false, "2001:db8:1:2::5", true, true, ""},
+		{"not allowed", office, nil, false, "192.0.2.1", false, false, "allow"},
+		{"write-only lets reads through", office, nil, true, "192.0.2.1", false, true, ""},
+		{"write-only stops writes", office, nil, true, "192.0.2.1", true, false, "allow"},
+		{"v4-mapped v6 matches v4 list", office, nil, false, "::ffff:203.0.113.9", true, true, ""},
+		{"v4-mapped v6 denied", nil, []string{"198.51.100.0/24"}, false, "::ffff:198.51.100.7", false, false, "deny"},
+		{"unparseable not allowed", office, nil, false, "not-an-ip", false, false, "allow"},
+		{"unparseable not denied", nil, []string{"198.51.100.0/24"}, false, "not-an-ip", false, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t)
+			useIPLists(t, tt.allow, tt.deny)
+			config.IPAllowWriteOnly = tt.writeOnly
+
+			ok, list := ipVerdict(tt.ip, tt.write)
+			if ok != tt.wantOK || list != tt.wantList {
+				t.Errorf("ipVerdict(%q, %v) = %v, %q; want %v, %q", tt.ip, tt.write, ok, list, tt.wantOK, tt.wantList)
+			}
+		})
+	}
+}
+
+func TestLoadIPList(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		{"comments and blanks", "# office\n\n203.0.113.0/24  # main\n   \n2001:db8::/32\n", []string{"203.0.113.0/24", "2001:db8::/32"}, false},
+		{"bare addresses", "192.0.2.1\n2001:db8::1\n", []string{"192.0.2.1/32", "2001:db8::1/128"}, false},
+		{"bad line", "203.0.113.0/24\nnot a network\n", nil, true},
+		{"bad prefix length", "203.0.113.0/33\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := loadIPList(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i, p := range got {
+				if p.String() != tt.want[i] {
+					t.Errorf("entry %d = %s, want %s", i, p, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// A reload with a bad file keeps both lists as they were.
+func TestLoadIPListsKeepsListsOnError(t *testing.T) {
+	useConfig(t)
+	useIPLists(t, nil, nil)
+	dir := t.TempDir()
+	config.IPAllowFile = filepath.Join(dir, "allow")
+	config.IPDenyFile = filepath.Join(dir, "deny")
+	os.WriteFile(config.IPAllowFile, []byte("203.0.113.0/24\n"), 0600)
+	os.WriteFile(config.IPDenyFile, []byte("198.51.100.0/24\n"), 0600)
+	if err := loadIPLists(); err != nil {
+		t.Fatal(err)
+	}
+
+	os.WriteFile(config.IPAllowFile, []byte("192.0.2.0/24\n"), 0600)
+	os.WriteFile(config.IPDenyFile, []byte("garbage\n"), 0600)
+	if err := loadIPLists(); err == nil {
+		t.Fatal("reload with a bad deny file succeeded")
+	}
+	if ok, _ := ipVerdict("203.0.113.9", true); !ok {
+		t.Error("old allow list was replaced")
+	}
+	if ok, _ := ipVerdict("192.0.2.1", true); ok {
+		t.Error("new allow list took effect despite the error")
+	}
+	if ok, list := ipVerdict("198.51.100.7", false); ok || list != "deny" {
+		t.Error("old deny list was dropped")
+	}
+}
+
+func TestCheckIP(t *testing.T) {
+	useConfig(t)
+	useIPLists(t, nil, []string{"198.51.100.0/24"})
+	config.IPDenyFile = "deny"
+	h := checkIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tt := range []struct {
+		remote, forwarded string
+		trustProxy        bool
+		want              int
+	}{
+		{"198.51.100.7:1234", "", false, http.StatusForbidden},
+		{"192.0.2.1:1234", "", false, http.StatusOK},
+		// A forwarded address only counts from a trusted proxy
+		{"192.0.2.1:1234", "198.51.100.7", false, http.StatusOK},
+		{"192.0.2.1:1234", "198.51.100.7", true, http.StatusForbidden},
+	} {
+		config.TrustProxy = tt.trustProxy
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s forwarded for %q (trusted %v): status %d, want %d", tt.remote, tt.forwarded, tt.trustProxy, w.Code, tt.want)
+		}
+	}
+}
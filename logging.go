@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// setupLogger installs the slog default logger in the configured format.
+// The standard log package is routed through it as well.
+func setupLogger() {
+	var h slog.Handler
+	switch config.LogFormat {
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, nil)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, nil)
+	default:
+		log.Fatalf("Invalid log-format %q: want text or json", config.LogFormat)
+	}
+	slog.SetDefault(slog.New(h))
+}
+
+// statusRecorder captures the status code and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, to
+// flush a streamed response.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// timings collects how long named phases of a request took, such as disk
+// reads and writes, for the slow request log.
+type timings struct {
+	mu     sync.Mutex
+	phases []any
+}
+
+type timingsKey struct{}
+
+// timePhase starts timing a phase of the request ctx belongs to; calling
+// the returned function ends it. It does nothing unless slow requests are
+// being logged.
+func timePhase(ctx context.Context, name string) func() {
+	t, _ := ctx.Value(timingsKey{}).(*timings)
+	if t == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		t.mu.Lock()
+		t.phases = append(t.phases, name+"_ms", milliseconds(time.Since(start)))
+		t.mu.Unlock()
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// accessLog logs one line per request when access logging is enabled, and
+// requests slower than SLOW_REQUEST at WARN with their timed phases.
+func accessLog(next http.Handler) http.Handler {
+	if !config.AccessLog && config.SlowRequest <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		t := &timings{}
+		if config.SlowRequest > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), timingsKey{}, t))
+		}
+		next.ServeHTTP(rec, r)
+		elapsed := time.Since(start)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		attrs := []any{
+			"method", r.Method,
+			"path", redactPath(r.URL.Path),
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration_ms", milliseconds(elapsed),
+			"request_id", requestID(r.Context()),
+		}
+		if !config.PrivacyMode {
+			attrs = append(attrs, "remote", clientIP(r))
+		}
+		if config.AccessLog {
+			slog.Info("request", attrs...)
+		}
+		if config.SlowRequest > 0 && elapsed >= config.SlowRequest {
+			t.mu.Lock()
+			attrs = append(attrs, t.phases...)
+			t.mu.Unlock()
+			slog.Warn("slow request", attrs...)
+		}
+	})
+}
+
+// redactPath hides the secret segment of private paste URLs so capability
+// links don't end up in logs. Legacy /<id>/<secret> links are redacted too,
+// since they still arrive to be redirected.
+func redactPath(path string) string {
+	prefix := "/p/"
+	trimmed, ok := strings.CutPrefix(path, prefix)
+	if !ok {
+		prefix, trimmed = "/", strings.TrimPrefix(path, "/")
+	}
+	id, rest, ok := strings.Cut(trimmed, "/")
+	if !ok || !isValidID(id) {
+		return path
+	}
+	secret, suffix, _ := strings.Cut(rest, "/")
+	if len(secret) != 32 {
+		return path
+	}
+	if suffix != "" {
+		suffix = "/" + suffix
+	}
+	return prefix + id + "/<secret>" + suffix
+}
@@ -1,26 +1,47 @@
 package main
 
 import (
-	"crypto/rand"
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
 	"embed"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
-	"log"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unicode/utf8"
 )
 
 //go:embed templates/*
 var templateFiles embed.FS
 
-func generateID() string {
-	bytes := make([]byte, 8)
-	rand.Read(bytes)
-	return hex.EncodeToString(bytes)
+// bucket is the directory holding a paste's files. It is named after the
+// first two hex digits following the ID prefix, so prefixed IDs still
+// spread over all 256 buckets.
+func bucket(id string) string {
+	return "pastes/" + strings.TrimPrefix(id, config.IDPrefix)[:2]
+}
+
+// generateSecret returns the 32-character capability of a private paste.
+func generateSecret() (string, error) {
+	return randomHex(16)
 }
 
 type Paste struct {
@@ -28,6 +49,83 @@ type Paste struct {
 	Title string
 	Body  []byte
 	TTL   string
+
+	// Normalized records that BOM/line-ending cleanup was applied at save
+	Normalized bool
+
+	// Public pastes are listed on /recent; Language is an optional hint
+	Public   bool
+	Language string
+
+	// Quarantined pastes are held back until an admin approves them
+	Quarantined bool
+
+	// Created is the file mtime, set by loadPaste
+	Created time.Time
+
+	// SHA256 is the hex digest of Body as served by /raw
+	SHA256 string
+
+	// Secret is the extra path segment private pastes require
+	Secret string
+
+	// Filename is the name of the uploaded file, used by /download
+	Filename string
+
+	// Tags list the paste under /tag/<tag> when tags are enabled
+	Tags []string
+
+	// Tabs is the tab width pages render the paste with; 0 for the default
+	Tabs int
+
+	// NoComments is set when the creator turned comments off
+	NoComments bool
+
+	// Cipher is set for pastes encrypted in the browser, whose Body is
+	// base64 ciphertext the server never decodes
+	Cipher      string
+	Compression string
+
+	// blob is the hash of the shared body when dedup stored it
+	blob string
+
+	// deleteHash is the hash of the creator's delete token
+	deleteHash string
+}
+
+// metaPrefix starts the header line of files that carry JSON metadata.
+// Older files begin directly with the title line.
+const metaPrefix = "\x00tp "
+
+type pasteMeta struct {
+	Title       string   `json:"title"`
+	Normalized  bool     `json:"normalized,omitempty"`
+	Public      bool     `json:"public,omitempty"`
+	Language    string   `json:"language,omitempty"`
+	Quarantine  bool     `json:"quarantine,omitempty"`
+	Blob        string   `json:"blob,omitempty"`
+	SHA256      string   `json:"sha256,omitempty"`
+	Secret      string   `json:"secret,omitempty"`
+	Filename    string   `json:"filename,omitempty"`
+	Cipher      string   `json:"cipher,omitempty"`
+	Compression string   `json:"compression,omitempty"`
+	DeleteHash  string   `json:"delete_hash,omitempty"`
+	Tabs        int      `json:"tabs,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	NoComments  bool     `json:"no_comments,omitempty"`
+}
+
+// parseHeader decodes the first line of a paste file.
+func parseHeader(line string) (pasteMeta, error) {
+	var meta pasteMeta
+	if header, ok := strings.CutPrefix(line, metaPrefix); ok {
+		if err := json.Unmarshal([]byte(header), &meta); err != nil {
+			return meta, fmt.Errorf("invalid paste metadata")
+		}
+	} else {
+		meta.Title = line
+	}
+	return meta, nil
 }
 
 var TTLHours = map[string]int{
@@ -40,48 +138,182 @@ var TTLHours = map[string]int{
 	"7d":  168,
 }
 
-func (p *Paste) save() error {
-	// Create subdirectory using first 2 chars of ID (256 buckets)
-	subdir := fmt.Sprintf("pastes/%s", p.ID[:2])
-	os.MkdirAll(subdir, 0755)
+// ttlOptions are the form's expiry dropdown in display order. Their labels
+// are the ttl.<value> messages.
+var ttlOptions = []string{"1h", "3h", "6h", "12h", "24h", "3d", "7d"}
+
+// path is where the paste is stored: pastes/<bucket>/<id>_<ttl>.txt
+func (p *Paste) path() string {
+	return fmt.Sprintf("%s/%s_%s.txt", bucket(p.ID), p.ID, p.TTL)
+}
+
+// URLPath is where the paste is served, including the secret of private
+// pastes.
+func (p *Paste) URLPath() string {
+	return pastePath(p.ID, p.Secret)
+}
+
+// pastePath is the canonical URL path of a paste. Pages, API responses,
+// redirects and anything else that links to a paste build the link here.
+func pastePath(id, secret string) string {
+	if secret != "" {
+		return "/p/" + id + "/" + secret
+	}
+	return "/p/" + id
+}
+
+// unlocks reports whether secret grants access to p.
+func (p *Paste) unlocks(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(p.Secret), []byte(secret)) == 1
+}
+
+func (p *Paste) save(ctx context.Context) (err error) {
+	// With dedup the body goes to the shared blob store instead
+	body := p.Body
+	if config.Dedup && p.blob == "" {
+		sum, err := acquireBlob(p.Body)
+		if err != nil {
+			return err
+		}
+		p.blob = sum
+		defer func() {
+			if err != nil {
+				releaseBlob(sum)
+				p.blob = ""
+			}
+		}()
+	}
+	if p.blob != "" {
+		body = nil
+	}
 	
-	// Save content as plain text 
-	content := p.Title + "\n" + string(p.Body)
-	filename := fmt.Sprintf("%s/%s_%s.txt", subdir, p.ID, p.TTL)
+	p.SHA256 = bodyHash(p.Body)
 	
-	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	// Save metadata header line followed by the body as plain text
+	meta, err := json.Marshal(pasteMeta{
+		Title:       p.Title,
+		Normalized:  p.Normalized,
+		Public:      p.Public,
+		Language:    p.Language,
+		Quarantine:  p.Quarantined,
+		Blob:        p.blob,
+		SHA256:      p.SHA256,
+		Secret:      p.Secret,
+		Filename:    p.Filename,
+		Cipher:      p.Cipher,
+		Compression: p.Compression,
+		DeleteHash:  p.deleteHash,
+		Tabs:        p.Tabs,
+		Tags:        p.Tags,
+		NoComments:  p.NoComments,
+	})
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 	
-	_, err = file.Write([]byte(content))
+	// The header and body go out separately rather than being joined
+	// first, which would copy the whole body again
+	err = saveRetrying(ctx, p.path(), func(w io.Writer) error {
+		io.WriteString(w, metaPrefix)
+		w.Write(meta)
+		io.WriteString(w, "\n")
+		_, err := w.Write(body)
+		return err
+	})
 	if err != nil {
 		return err
 	}
-	
-	// Force sync to disk
-	err = file.Sync()
-	if err != nil {
+	indexTags(p)
+	return nil
+}
+
+// Expires is when the paste stops being served.
+func (p *Paste) Expires() time.Time {
+	return p.Created.Add(time.Duration(TTLHours[p.TTL]) * time.Hour)
+}
+
+// setPasteHeaders describes p in response headers so scripts fetching it
+// learn when it expires without another request. Times are whole seconds.
+func setPasteHeaders(w http.ResponseWriter, p *Paste) {
+	h := w.Header()
+	h.Set("X-Paste-Id", p.ID)
+	h.Set("X-Paste-Created-At", p.Created.UTC().Truncate(time.Second).Format(time.RFC3339))
+	h.Set("X-Paste-Expires-At", p.Expires().UTC().Truncate(time.Second).Format(time.RFC3339))
+	h.Set("X-Paste-TTL", p.TTL)
+	h.Set("X-Paste-Size", strconv.Itoa(len(p.Body)))
+}
+
+// bodyHash returns the hex SHA-256 of body.
+func bodyHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// removeFile deletes a file that isn't a paste, such as a rotated audit
+// log or an unreferenced blob, logging any failure; pastes go through
+// removePaste. A file that is already gone counts as success.
+func removeFile(path string) error {
+	err := os.Remove(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Error("remove file", "path", path, "err", err)
+		return err
+	}
+	return nil
+}
+
+// removePaste deletes a paste file and, if it referenced a shared blob,
+// drops that reference. Only the caller whose remove succeeds releases the
+// blob, so racing removals can't drop it twice.
+func removePaste(path string) error {
+	meta, _ := readHeader(path)
+	info, statErr := store.Stat(path)
+	err := store.Remove(path)
+	if err == nil && statErr == nil {
+		tallyFile(path, info.Size(), -1)
+	}
+	if err == nil && meta.Blob != "" {
+		releaseBlob(meta.Blob)
+	}
+	if err == nil {
+		id, _, _ := strings.Cut(filepath.Base(path), "_")
+		removeComments(id)
+		unindexTags(id)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Error("remove paste", "path", path, "err", err)
 		return err
 	}
-	
 	return nil
 }
 
-var cleanupOffset int
+var (
+	cleanupMu     sync.Mutex
+	cleanupOffset int
+)
+
+// pasteFile is one paste on disk as seen by walkBuckets.
+type pasteFile struct {
+	Path    string
+	ID      string
+	TTL     string
+	Created time.Time
+	Size    int64
+}
+
+func (f pasteFile) expires() time.Time {
+	return f.Created.Add(time.Duration(TTLHours[f.TTL]) * time.Hour)
+}
 
-func cleanupExpired() {
-	now := time.Now().Unix()
-	
-	// Process 16 subdirs per cycle (full scan in ~8 hours)
-	start := cleanupOffset
-	end := cleanupOffset + 16
-	
+func (f pasteFile) expired(now time.Time) bool {
+	return now.After(f.expires())
+}
+
+// walkBuckets calls fn for every paste file in buckets start to end-1.
+func walkBuckets(start, end int, fn func(pasteFile)) {
 	for i := start; i < end; i++ {
 		subdir := fmt.Sprintf("pastes/%02x", i)
 		
-		entries, err := os.ReadDir(subdir)
+		entries, err := store.ReadDir(subdir)
 		if err != nil {
 			continue
 		}
@@ -97,44 +329,131 @@ func cleanupExpired() {
 			if len(parts) != 2 {
 				continue
 			}
-			
-			// Get file modification time
-			filePath := filepath.Join(subdir, entry.Name())
-			info, err := os.Stat(filePath)
-			if err != nil {
+			if _, exists := TTLHours[parts[1]]; !exists {
+				continue
+			}
+			// Leave pastes of instances with another ID prefix alone
+			if !isValidID(parts[0]) {
 				continue
 			}
 			
-			createdAt := info.ModTime().Unix()
-			
-			// Calculate expiration using TTL
-			ttlHours, exists := TTLHours[parts[1]]
-			if !exists {
+			// Get file modification time; the file may have been removed
+			// by a concurrent loadPaste since the directory was read
+			info, err := entry.Info()
+			if err != nil {
 				continue
 			}
 			
-			expiresAt := createdAt + int64(ttlHours*3600)
-			if now > expiresAt {
-				os.Remove(filePath)
+			fn(pasteFile{
+				Path:    filepath.Join(subdir, entry.Name()),
+				ID:      parts[0],
+				TTL:     parts[1],
+				Created: info.ModTime(),
+				Size:    info.Size(),
+			})
+		}
+	}
+}
+
+func cleanupExpired() {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+
+	now := time.Now()
+	
+	// Process 16 subdirs per cycle (full scan in ~8 hours). Walking memory
+	// is cheap, so the memory store is scanned whole every cycle.
+	start, end := cleanupOffset, cleanupOffset+16
+	if config.Store == "memory" {
+		start, end = 0, 256
+	}
+	removed := 0
+	walkBuckets(start, end, func(f pasteFile) {
+		if f.expired(now) {
+			if removePaste(f.Path) == nil {
+				removed++
 			}
+			writeTombstone(f.ID, removedExpired, f.Created.Add(time.Duration(TTLHours[f.TTL])*time.Hour))
 		}
+	})
+	tallyBuckets(start, end)
+	recordCleanup(now, removed)
+	sweepTombstones(start, end, now)
+	sweepComments(start, end)
+	sweepTakedowns(now)
+	if config.PruneBuckets && config.Store == "disk" {
+		pruneBuckets(start, end)
 	}
 	
-	cleanupOffset = (cleanupOffset + 16) % 256
+	cleanupOffset = end % 256
+}
+
+// bucketMu keeps pruneBuckets from removing a bucket between a writer's
+// MkdirAll and the file it creates there. Writers take the read lock.
+var bucketMu sync.RWMutex
+
+// pruneBuckets removes buckets start to end-1 that are empty, so the
+// store doesn't keep 256 empty directories around.
+func pruneBuckets(start, end int) {
+	bucketMu.Lock()
+	defer bucketMu.Unlock()
+	for i := start; i < end; i++ {
+		dir := fmt.Sprintf("pastes/%02x", i)
+		err := os.Remove(dir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, syscall.ENOTEMPTY) && !errors.Is(err, syscall.EEXIST) {
+			slog.Error("prune bucket", "dir", dir, "err", err)
+		}
+	}
+}
+
+// Errors returned by loadPaste, which handlers map to 404, 410 and 500.
+// Other errors are I/O failures.
+var (
+	ErrNotFound = errors.New("paste not found")
+	ErrExpired  = errors.New("paste expired")
+	ErrCorrupt  = errors.New("corrupt paste file")
+)
+
+// pickPasteFile chooses among files stored for the same ID: the one that
+// expires last, ties going to the first by name, so the choice never
+// depends on directory order. Files that can't be read lose.
+func pickPasteFile(files []string) string {
+	files = slices.Sorted(slices.Values(files))
+	best, bestExpiry := files[0], time.Time{}
+	for _, file := range files {
+		info, err := store.Stat(file)
+		if err != nil {
+			continue
+		}
+		_, ttl, _ := strings.Cut(strings.TrimSuffix(filepath.Base(file), ".txt"), "_")
+		expiry := info.ModTime().Add(time.Duration(TTLHours[ttl]) * time.Hour)
+		if expiry.After(bestExpiry) {
+			best, bestExpiry = file, expiry
+		}
+	}
+	return best
 }
 
 func loadPaste(id string) (*Paste, error) {
 	// Find file by scanning subdirectory for matching ID
-	subdir := fmt.Sprintf("pastes/%s", id[:2])
-	files, err := filepath.Glob(subdir + "/" + id + "_*.txt")
+	files, err := store.Glob(bucket(id) + "/" + id + "_*.txt")
 	if err != nil || len(files) == 0 {
-		return nil, fmt.Errorf("paste not found")
+		return nil, ErrNotFound
 	}
 	
+	// Only one file should exist per ID; if more do, serve the same one
+	// every time and leave the rest to the integrity scan
 	filename := files[0]
+	if len(files) > 1 {
+		filename = pickPasteFile(files)
+		slog.Warn("duplicate paste files", "id", id, "serving", filename, "files", files)
+	}
 	
 	// Use file mtime as creation time
-	info, err := os.Stat(filename)
+	info, err := store.Stat(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -144,41 +463,88 @@ func loadPaste(id string) (*Paste, error) {
 	basename := filepath.Base(filename)
 	parts := strings.Split(strings.TrimSuffix(basename, ".txt"), "_")
 	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid paste file format")
+		return nil, fmt.Errorf("%w: bad file name %s", ErrCorrupt, basename)
 	}
 	
 	ttl := parts[1]
 	ttlHours, exists := TTLHours[ttl]
 	if !exists {
-		return nil, fmt.Errorf("invalid TTL")
+		return nil, fmt.Errorf("%w: unknown TTL %q", ErrCorrupt, ttl)
 	}
 	
 	expiresAt := createdAt + int64(ttlHours*3600)
 	
 	// Check if expired
 	if time.Now().Unix() > expiresAt {
-		os.Remove(filename) // Clean up expired paste
-		return nil, fmt.Errorf("paste expired")
+		// Clean up expired paste, remembering it expired rather than vanished
+		removePaste(filename)
+		writeTombstone(id, removedExpired, time.Unix(expiresAt, 0))
+		return nil, ErrExpired
 	}
 	
-	content, err := os.ReadFile(filename)
+	content, err := store.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 	
 	lines := strings.SplitN(string(content), "\n", 2)
 	if len(lines) < 2 {
-		return nil, fmt.Errorf("invalid paste content")
+		return nil, fmt.Errorf("%w: missing header", ErrCorrupt)
+	}
+	
+	meta, err := parseHeader(lines[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	
+	body := []byte(lines[1])
+	if meta.Blob != "" {
+		if body, err = readBlob(meta.Blob); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+	}
+	
+	// Pastes saved before digests were recorded get one computed
+	sum := meta.SHA256
+	if sum == "" {
+		sum = bodyHash(body)
 	}
 	
 	return &Paste{
-		ID:    id,
-		Title: lines[0],
-		Body:  []byte(lines[1]),
-		TTL:   ttl,
+		ID:          id,
+		Title:       meta.Title,
+		Body:        body,
+		TTL:         ttl,
+		Normalized:  meta.Normalized,
+		Public:      meta.Public,
+		Language:    meta.Language,
+		Quarantined: meta.Quarantine,
+		Created:     info.ModTime(),
+		SHA256:      sum,
+		Secret:      meta.Secret,
+		Filename:    meta.Filename,
+		Cipher:      meta.Cipher,
+		Compression: meta.Compression,
+		Tabs:        meta.Tabs,
+		Tags:        meta.Tags,
+		NoComments:  meta.NoComments,
+		deleteHash:  meta.DeleteHash,
+		blob:        meta.Blob,
 	}, nil
 }
 
+// normalizeBody strips a leading UTF-8 BOM, converts CRLF and lone CR to LF
+// and leaves exactly one trailing newline.
+func normalizeBody(body string) string {
+	body = strings.TrimPrefix(body, "\ufeff")
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	body = strings.ReplaceAll(body, "\r", "\n")
+	return strings.TrimRight(body, "\n") + "\n"
+}
+
 
 
 func saveHandler(w http.ResponseWriter, r *http.Request) {
@@ -187,28 +553,215 @@ func saveHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if config.Upstream != "" {
+		http.Error(w, errReadOnly.Error(), http.StatusForbidden)
+		return
+	}
+	if !creationOpen(w, r) {
+		return
+	}
+	
+	// URL encoding can triple the body, plus room for the other fields
+	limit := 3*int64(config.MaxBodySize) + 64*1024
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	if status, err := readForm(w, r, limit); err != nil {
+		if status == http.StatusRequestEntityTooLarge {
+			tooLarge(w, r, "", "")
+			return
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	
+	switch verdict, msg := checkFormSpam(r); verdict {
+	case spamDiscard:
+		id, err := generateID(r.FormValue("short_id") != "")
+		if err != nil {
+			requestLog(r.Context()).Error("generate paste id", "err", err)
+			internalError(w, r)
+			return
+		}
+		releaseID(id)
+		http.Redirect(w, r, sitePath(pastePath(id, "")), http.StatusFound)
+		return
+	case spamReject:
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	
+	// A retried create gets the response the first attempt got
+	idemKey, idemSum := idempotencyKey(r)
+	var idemRec *idemRecorder
+	if idemKey != "" {
+		e, ok := claimIdempotency(idemKey, idemSum)
+		switch {
+		case !ok && e.sum != idemSum:
+			http.Error(w, "This idempotency key was already used for a different paste", http.StatusUnprocessableEntity)
+			return
+		case !ok && e.resp == nil:
+			http.Error(w, "A request with this idempotency key is in progress", http.StatusConflict)
+			return
+		case !ok:
+			replayIdempotent(w, e.resp)
+			return
+		}
+		defer releaseIdempotency(idemKey)
+		idemRec = &idemRecorder{ResponseWriter: w}
+		w = idemRec
+	}
+	
+	if config.PowDifficulty > 0 && !apiClient(r) {
+		if err := verifyPow(r.FormValue("pow_challenge"), r.FormValue("pow_nonce")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 	
 	title := r.FormValue("title")
 	body := r.FormValue("body")
 	ttl := r.FormValue("ttl")
+	keepOriginal := r.FormValue("keep_original") != ""
+	public := (config.RecentEnabled || config.TagsEnabled) && r.FormValue("public") != ""
+	language := strings.TrimSpace(r.FormValue("language"))
+	private := r.FormValue("private") != ""
+	short := r.FormValue("short_id") != ""
+	cipher := r.FormValue("cipher")
+	compression := r.FormValue("compression")
+	tabs := r.FormValue("tabs")
+	
+	// Encrypted pastes are stored exactly as sent and kept off /recent
+	if cipher != "" {
+		if title == "" {
+			title = "Encrypted paste"
+		}
+		if compression == "" {
+			compression = "none"
+		}
+		keepOriginal = true
+		public = false
+		language = ""
+	}
+	
+	if sourceURL := r.FormValue("source_url"); sourceURL != "" && body == "" {
+		if !config.SourceURLEnabled {
+			http.Error(w, "Creating pastes from a URL is disabled", http.StatusBadRequest)
+			return
+		}
+		fetched, err := fetchSource(r.Context(), sourceURL, config.MaxBodySize)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = fetched
+	}
+	
+	filename := r.FormValue("filename")
+	if body == "" {
+		upload, name, err := readUpload(r, config.MaxBodySize)
+		if err != nil {
+			http.Error(w, "Invalid file upload", http.StatusBadRequest)
+			return
+		}
+		body = upload
+		if filename == "" {
+			filename = name
+		}
+	}
+	filename = sanitizeFilename(filename)
+	if cipher != "" {
+		filename = ""
+	}
+	if title == "" {
+		title = filename
+	}
+	
+	switch {
+	case body == "" && config.TitleOptional:
+		http.Error(w, "Content required", http.StatusBadRequest)
+		return
+	case title == "" && !config.TitleOptional, body == "":
+		http.Error(w, "Title and content required", http.StatusBadRequest)
+		return
+	}
+	
+	// Normalize before the size check so the limit applies to stored bytes
+	if !keepOriginal {
+		body = normalizeBody(body)
+	}
+	if title == "" {
+		title = untitled(body)
+	}
 	
 	// Basic size limits
-	if len(title) > 200 {
-		http.Error(w, "Title too long (max 200 chars)", http.StatusBadRequest)
+	if len(title) > config.MaxTitleLength {
+		http.Error(w, fmt.Sprintf("Title too long (max %d chars)", config.MaxTitleLength), http.StatusBadRequest)
 		return
 	}
-	if len(body) > 1024*1024 { // 1MB limit
-		http.Error(w, "Content too large (max 1MB)", http.StatusBadRequest)
+	if len(body) > config.MaxBodySize {
+		tooLarge(w, r, title, body)
 		return
 	}
-	if title == "" || body == "" {
-		http.Error(w, "Title and content required", http.StatusBadRequest)
+	if !utf8.ValidString(title) {
+		http.Error(w, "Title must be valid UTF-8", http.StatusBadRequest)
+		return
+	}
+	if err := validateTitle(title); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(language) > 32 || !utf8.ValidString(language) {
+		http.Error(w, "Invalid language (max 32 chars)", http.StatusBadRequest)
+		return
+	}
+	if tabs != "" && parseTabWidth(tabs) == 0 {
+		http.Error(w, "Invalid tabs: want 2, 4 or 8", http.StatusBadRequest)
+		return
+	}
+	var tags []string
+	if config.TagsEnabled {
+		var err error
+		// tags is a comma-separated list, or repeated for an array
+		if tags, err = normalizeTags(strings.Join(r.Form["tags"], ",")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if cipher != "" {
+		if err := validateEncrypted(cipher, compression, body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if err := validateBody(body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	scanned := body
+	if cipher != "" {
+		scanned = ""
+	}
+	if blocked(title, scanned) {
+		http.Error(w, "Paste rejected", http.StatusBadRequest)
 		return
 	}
+	if contentScanner != nil && cipher == "" {
+		verdict, err := scanContent(r.Context(), []byte(body))
+		if err != nil {
+			http.Error(w, "Content scanner unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if verdict != "" {
+			http.Error(w, "Paste rejected by content scan", http.StatusUnprocessableEntity)
+			return
+		}
+	}
 	
-	// Default to 6h if no TTL specified
+	// Use the default TTL if none specified, shortened if the size policy
+	// doesn't allow that long
 	if ttl == "" {
-		ttl = "6h"
+		ttl = config.DefaultTTL
+		if limit, ok := maxTTL(len(body)); ok && TTLHours[ttl] > TTLHours[limit] {
+			ttl = limit
+		}
 	}
 	
 	// Validate TTL
@@ -217,37 +770,267 @@ func saveHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid TTL", http.StatusBadRequest)
 		return
 	}
+	if limit, ok := maxTTL(len(body)); ok && TTLHours[ttl] > TTLHours[limit] {
+		http.Error(w, fmt.Sprintf("TTL %s not allowed for a paste of this size (max %s)", ttl, limit), http.StatusBadRequest)
+		return
+	}
 	
-	id := generateID()
+	id, err := generateID(short)
+	if err == nil {
+		defer releaseID(id)
+	}
+	var secret, token string
+	if err == nil && private {
+		secret, err = generateSecret()
+	}
+	if err == nil {
+		token, err = generateDeleteToken()
+	}
+	if err != nil {
+		requestLog(r.Context()).Error("generate paste id", "err", err)
+		internalError(w, r)
+		return
+	}
 	
 	p := &Paste{
-		ID:    id,
-		Title: title,
-		Body:  []byte(body),
-		TTL:   ttl,
+		ID:          id,
+		Title:       title,
+		Body:        []byte(body),
+		TTL:         ttl,
+		Normalized:  !keepOriginal,
+		Public:      public,
+		Language:    language,
+		Cipher:      cipher,
+		Compression: compression,
+		Filename:    filename,
+		Tabs:        parseTabWidth(tabs),
+		Tags:        tags,
+		NoComments:  r.FormValue("no_comments") != "",
 	}
+	if private {
+		p.Secret = secret
+		p.Public = false
+	}
+	p.deleteHash = bodyHash([]byte(token))
 	
-	err := p.save()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if !quotaFits(len(body)) {
+		http.Error(w, fmt.Sprintf("Paste is larger than the daily quota of %d bytes", config.QuotaBytes), http.StatusRequestEntityTooLarge)
 		return
 	}
-	http.Redirect(w, r, "/"+id, http.StatusFound)
+	wait, ok := takeQuota(r, len(body))
+	if !ok {
+		throttled(w, r, http.StatusTooManyRequests, wait, quotaMessage(wait))
+		return
+	}
+	if !storePaste(w, r, p) {
+		refundQuota(r, len(body))
+		return
+	}
+	rememberPaste(w, r, p)
+	if p.Quarantined {
+		w.Header().Set("X-Paste-Delete-Token", token)
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "Paste is awaiting moderation and will be available at %s once approved\n", absoluteURL(r, p.URLPath()))
+	} else {
+		// The capability URL of a private paste and the delete token are
+		// shown here and nowhere else, but for a retry of this request
+		respondCreated(w, r, p, token)
+	}
+	if idemKey != "" {
+		completeIdempotency(idemKey, idemRec)
+	}
 }
 
-var templates = template.Must(template.ParseFS(templateFiles, "templates/*.html"))
+// storePaste runs the instance-wide checks every new paste goes through,
+// whatever route created it, then writes p to disk. On failure it answers
+// the request and returns false, and the write no longer counts against
+// the global limits.
+func storePaste(w http.ResponseWriter, r *http.Request, p *Paste) bool {
+	if !globalFits(len(p.Body)) {
+		http.Error(w, fmt.Sprintf("Paste is larger than the write limit of %d bytes a minute", config.GlobalBytesPerMinute), http.StatusRequestEntityTooLarge)
+		return false
+	}
+	if wait, ok := takeGlobal(len(p.Body)); !ok {
+		throttled(w, r, http.StatusServiceUnavailable, wait, busyMessage(wait))
+		return false
+	}
+	stored := false
+	defer func() {
+		if !stored {
+			refundGlobal(len(p.Body))
+		}
+	}()
+	
+	if config.ModerationURL != "" {
+		switch moderate(r.Context(), r, p) {
+		case moderationDeny:
+			http.Error(w, "Paste rejected by moderation", http.StatusUnprocessableEntity)
+			return false
+		case moderationQuarantine:
+			p.Quarantined = true
+		}
+	}
+	
+	release, ok := acquireSave(r.Context())
+	if !ok {
+		throttled(w, r, http.StatusServiceUnavailable, time.Second, busyMessage(time.Second))
+		return false
+	}
+	err := p.save(r.Context())
+	release()
+	if errors.Is(err, errStoreFull) {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return false
+	}
+	if err != nil {
+		requestLog(r.Context()).Error("save paste", "id", p.ID, "err", err)
+		internalError(w, r)
+		return false
+	}
+	stored = true
+	tallyStored(p.path())
+	createdWindow.add(time.Now())
+	auditCreate(p, clientIP(r))
+	return true
+}
 
-func renderTemplate(w http.ResponseWriter, tmpl string, p *Paste) {
-	err := templates.ExecuteTemplate(w, tmpl+".html", p)
+// templateFuncs are the functions every template can call. theme, lang and
+// T are rebound per visitor by pageFuncs.
+var templateFuncs = template.FuncMap{
+	"asset":       assetPath,
+	"base":        func() string { return config.BasePath },
+	"hasLogo":     hasLogo,
+	"highlight":   highlight,
+	"pastePath":   pastePath,
+	"theme":       func() string { return "auto" },
+	"themes":      func() []string { return themes },
+	"lang":        func() string { return defaultLang },
+	"T":           func(key string, args ...any) string { return translate(defaultLang, key, args...) },
+	"nonce":       func() string { return nonceMarker },
+	"tabWidths":   func() []int { return tabWidths },
+	"tagsEnabled": func() bool { return config.TagsEnabled },
+}
+
+var templates = template.Must(template.New("").Funcs(templateFuncs).ParseFS(templateFiles, "templates/*.html"))
+
+// pageFuncs binds theme, lang and T for a visitor.
+func pageFuncs(theme, lang string) template.FuncMap {
+	return template.FuncMap{
+		"theme": func() string { return theme },
+		"lang":  func() string { return lang },
+		"T":     func(key string, args ...any) string { return translate(lang, key, args...) },
+	}
+}
+
+// pageKey selects a copy of the templates for a visitor.
+type pageKey struct {
+	theme, lang string
+}
+
+// pageTemplates holds a copy of templates for every theme and language,
+// with theme, lang and T bound to them, so pages render for the visitor
+// without cloning templates on every request.
+var pageTemplates = func() map[pageKey]*template.Template {
+	m := make(map[pageKey]*template.Template)
+	for _, theme := range themes {
+		for _, lang := range languages {
+			t := template.Must(templates.Clone())
+			m[pageKey{theme, lang}] = t.Funcs(pageFuncs(theme, lang))
+		}
+	}
+	return m
+}()
+
+// renderTemplate renders a page in the visitor's theme and language.
+func renderTemplate(w http.ResponseWriter, r *http.Request, tmpl string, data any) {
+	renderStatus(w, r, http.StatusOK, tmpl, data)
+}
+
+// renderStatus renders a page in the visitor's theme and language with the
+// given status. Pages vary by the theme and language cookies and by
+// Accept-Language, so shared caches must key on them. Inline blocks get
+// the request's CSP nonce. In dev mode templates are re-read for every
+// page. Errors go to the log, and the 500 page shows the request ID, or
+// in dev mode the error itself.
+func renderStatus(w http.ResponseWriter, r *http.Request, status int, tmpl string, data any) {
+	w.Header().Add("Vary", "Cookie")
+	w.Header().Add("Vary", "Accept-Language")
+	rememberLang(w, r)
+	key := pageKey{requestTheme(r), requestLang(r)}
+	t := pageTemplates[key]
+	if config.Dev {
+		var err error
+		if t, err = devTemplates(key); err != nil {
+			requestLog(r.Context()).Error("parse templates", "err", err)
+			templateError(w, r, err)
+			return
+		}
+	}
+	var buf bytes.Buffer
+	err := t.ExecuteTemplate(&buf, tmpl+".html", data)
 	if err != nil {
-		log.Printf("Template error: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		requestLog(r.Context()).Error("render template", "template", tmpl, "err", err)
+		templateError(w, r, err)
+		return
+	}
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+	}
+	w.Write(bytes.ReplaceAll(buf.Bytes(), []byte(nonceMarker), []byte(cspNonce(r))))
+}
+
+// cleanID strips trailing punctuation from a mistyped paste path and
+// lowercases it. It returns the ID and "/raw" if the path asked for it, or
+// an empty ID unless what is left is a valid ID, optionally followed by
+// /raw.
+func cleanID(path string) (id, suffix string) {
+	path = strings.ToLower(strings.TrimRight(path, ".,;:!?)]}>'\""))
+	id, raw := strings.CutSuffix(path, "/raw")
+	if !isValidID(id) {
+		return "", ""
+	}
+	if raw {
+		return id, "/raw"
+	}
+	return id, ""
+}
+
+// redirectToPaste sends a request for an old or mistyped paste URL to the
+// canonical one, keeping whatever followed the ID.
+func redirectToPaste(w http.ResponseWriter, r *http.Request, id, rest string) {
+	movedTo(w, r, pastePath(id, "")+strings.TrimSuffix(rest, "/"))
+}
+
+// movedTo redirects permanently to path, keeping the query string. GETs
+// get a 301; other methods get a 308 so clients repeat them as sent.
+func movedTo(w http.ResponseWriter, r *http.Request, path string) {
+	if r.URL.RawQuery != "" {
+		path += "?" + r.URL.RawQuery
+	}
+	status := http.StatusMovedPermanently
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		status = http.StatusPermanentRedirect
 	}
+	http.Redirect(w, r, sitePath(path), status)
+}
+
+// trailingSlash redirects paths ending in a slash, as chat apps like to
+// add, to the path without it. The mux has already collapsed duplicate
+// slashes, and the query string plays no part in routing.
+func trailingSlash(w http.ResponseWriter, r *http.Request) bool {
+	path, ok := strings.CutSuffix(r.URL.Path, "/")
+	if !ok || path == "" {
+		return false
+	}
+	movedTo(w, r, path)
+	return true
 }
 
 func isValidID(id string) bool {
-	// Only allow hex characters, 16 chars long (8 bytes * 2)
-	if len(id) != 16 {
+	// Only allow the configured prefix followed by hex characters, 16
+	// chars long (8 bytes * 2), or 8 for short IDs
+	id, ok := strings.CutPrefix(id, config.IDPrefix)
+	if !ok || (len(id) != 2*longIDBytes && len(id) != 2*shortIDBytes) {
 		return false
 	}
 	for _, c := range id {
@@ -263,33 +1046,530 @@ func mainHandler(w http.ResponseWriter, r *http.Request) {
 	
 	switch path {
 	case "/":
-		renderTemplate(w, "index", nil)
+		renderTemplate(w, r, "index", newIndexData(r))
 		return
 	case "/about":
-		renderTemplate(w, "about", nil)
+		renderTemplate(w, r, "about", nil)
 		return
 	case "/legal":
-		renderTemplate(w, "legal", nil)
+		renderTemplate(w, r, "legal", nil)
+		return
+	case "/robots.txt":
+		serveRobots(w, r)
+		return
+	case "/sitemap.xml":
+		serveSitemap(w, r)
+		return
+	case "/recent":
+		if !config.RecentEnabled {
+			http.NotFound(w, r)
+			return
+		}
+		renderTemplate(w, r, "recent", recentPastes(requestLang(r)))
 		return
 	}
 	
-	id := strings.TrimPrefix(path, "/")
+	// Pastes were served at /<id> before /p/<id>; old links keep working
+	rest := strings.TrimPrefix(path, "/")
+	if id, _, _ := strings.Cut(rest, "/"); isValidID(id) {
+		redirectToPaste(w, r, id, strings.TrimPrefix(rest, id))
+		return
+	}
+	if id, suffix := cleanID(rest); id != "" {
+		redirectToPaste(w, r, id, suffix)
+		return
+	}
+	if trailingSlash(w, r) {
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// pasteHandler serves /p/<id>[/<secret>][/raw|/hash|/download|/print],
+// and takes comments at /p/<id>[/<secret>]/comments.
+func pasteHandler(w http.ResponseWriter, r *http.Request) {
+	// The mux would send /p straight back to /p/
+	if r.URL.Path == "/p/" {
+		http.NotFound(w, r)
+		return
+	}
+	if trailingSlash(w, r) {
+		return
+	}
+	robotsTag(w, r.URL.Path, nil)
+	path := strings.TrimPrefix(r.URL.Path, "/p/")
+	id, raw := strings.CutSuffix(path, "/raw")
+	id, hash := strings.CutSuffix(id, "/hash")
+	id, download := strings.CutSuffix(id, "/download")
+	id, printable := strings.CutSuffix(id, "/print")
+	id, comments := strings.CutSuffix(id, "/comments")
+	id, secret, _ := strings.Cut(id, "/")
 	
 	// Validate ID format
 	if !isValidID(id) {
+		// Links copied out of prose often pick up punctuation or get
+		// uppercased; send those to the canonical URL
+		if id, suffix := cleanID(path); id != "" {
+			redirectToPaste(w, r, id, suffix)
+			return
+		}
 		http.NotFound(w, r)
 		return
 	}
 	
+	done := timePhase(r.Context(), "load")
 	p, err := loadPaste(id)
-	if err != nil {
+	done()
+	if errors.Is(err, ErrNotFound) && config.Upstream != "" && r.Header.Get(mirrorHeader) == "" {
+		done := timePhase(r.Context(), "upstream")
+		p, err = fetchUpstream(r.Context(), id, secret)
+		done()
+		if err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrExpired) {
+			slog.Warn("fetch from upstream", "id", id, "err", err)
+			err = ErrNotFound
+		}
+	}
+	if err == nil && !p.unlocks(secret) {
 		http.NotFound(w, r)
 		return
 	}
-	renderTemplate(w, "view", p)
+	api := wantsJSON(r) && !raw && !hash && !download && !printable
+	if err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrExpired) {
+		requestLog(r.Context()).Error("load paste", "id", id, "err", err)
+		if api {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error", "request_id": requestID(r.Context())})
+			return
+		}
+		internalError(w, r)
+		return
+	}
+	if err != nil || p.Quarantined {
+		if api {
+			pasteErrorJSON(w, id, err)
+			return
+		}
+		if t := loadTakedown(id); t != nil && err != nil {
+			if raw {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				w.WriteHeader(http.StatusUnavailableForLegalReasons)
+				fmt.Fprintln(w, "Paste removed for legal reasons:", t.Reason)
+				return
+			}
+			renderStatus(w, r, http.StatusUnavailableForLegalReasons, "takedown", t)
+			return
+		}
+		t := loadTombstone(id)
+		if t == nil && errors.Is(err, ErrExpired) {
+			t = &tombstone{ID: id, Reason: removedExpired, Removed: time.Now()}
+		}
+		if t != nil && err != nil {
+			if raw {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				w.WriteHeader(http.StatusGone)
+				if t.Reason == removedExpired {
+					fmt.Fprintln(w, "Paste expired")
+					return
+				}
+				fmt.Fprintln(w, "Paste removed")
+				return
+			}
+			renderStatus(w, r, http.StatusGone, "gone", t)
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+	
+	if p.Secret != "" {
+		w.Header().Set("Cache-Control", "private, no-store")
+	}
+	robotsTag(w, r.URL.Path, p)
+	
+	if r.Method == http.MethodDelete {
+		ownerDelete(w, r, p)
+		return
+	}
+	if comments {
+		postComment(w, r, p)
+		return
+	}
+	
+	setPasteHeaders(w, p)
+	viewWindow.add(time.Now())
+	
+	if download {
+		serveDownload(w, r, p)
+		return
+	}
+	
+	if hash {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Hash-Algorithm", "sha256")
+		fmt.Fprintln(w, p.SHA256)
+		return
+	}
+	
+	if raw {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Paste-Normalized", strconv.FormatBool(p.Normalized))
+		w.Header().Set("X-Content-SHA256", p.SHA256)
+		if p.Cipher != "" {
+			w.Header().Set("X-Paste-Cipher", p.Cipher)
+			w.Header().Set("X-Paste-Compression", p.Compression)
+		}
+		if p.Opaque() {
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+		serveBody(w, r, p)
+		return
+	}
+	
+	w.Header().Add("Vary", "Accept")
+	if api {
+		servePasteJSON(w, r, p)
+		return
+	}
+	
+	w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="canonical"`, absoluteURL(r, p.URLPath())))
+	w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="alternate"; type="text/plain"`, absoluteURL(r, p.URLPath()+"/raw")))
+	if p.Cipher != "" {
+		renderTemplate(w, r, "encrypted", viewData{Paste: p, NoIndex: !indexable(r.URL.Path, p)})
+		return
+	}
+	data := viewData{
+		Paste:   p,
+		Inline:  longestLine(p.Body) <= config.RenderMaxLineLength,
+		NoIndex: !indexable(r.URL.Path, p),
+	}
+	// Large pastes are cut short on the view page unless asked for whole
+	body := p.Body
+	if !printable && r.URL.Query().Get("full") != "1" {
+		if head, pv := previewBody(body); pv != nil {
+			body, data.Preview = head, pv
+		}
+	}
+	if data.Inline {
+		data.TabWidth = parseTabWidth(r.URL.Query().Get("tabs"))
+		if data.TabWidth == 0 {
+			data.TabWidth = cmp.Or(p.Tabs, defaultTabWidth)
+		}
+		data.Text = expandTabs(body, data.TabWidth)
+	}
+	if printable {
+		// The print view is a copy of the paste page
+		data.NoIndex = true
+		renderTemplate(w, r, "print", data)
+		return
+	}
+	data.Wrap = requestWrap(w, r)
+	data.Footer = config.ViewFooter
+	if data.CommentsOpen = p.commentsOpen(); data.CommentsOpen {
+		data.Comments = loadComments(p.ID)
+	}
+	if r.URL.Query().Get("debug") == "1" && data.Inline {
+		data.Visible = showInvisible(body)
+	}
+	renderTemplate(w, r, "view", data)
+}
+
+func newIndexData(r *http.Request) indexData {
+	data := indexData{
+		FormToken:      formToken(),
+		Recent:         config.RecentEnabled,
+		History:        config.HistoryEnabled,
+		Tags:           config.TagsEnabled,
+		Comments:       config.CommentsEnabled,
+		TitleOptional:  config.TitleOptional,
+		TTLOptions:     ttlOptions,
+		DefaultTTL:     config.DefaultTTL,
+		MaxBodySize:    config.MaxBodySize,
+		MaxTitleLength: config.MaxTitleLength,
+	}
+	if config.PowDifficulty > 0 {
+		// Without a challenge the form can't be submitted, but the page
+		// still shows
+		challenge, _, err := newChallenge(r)
+		if err != nil {
+			requestLog(r.Context()).Error("issue challenge", "err", err)
+		}
+		data.PowChallenge = challenge
+		data.PowExpiry = int(config.PowExpiry.Seconds())
+	}
+	return data
+}
+
+// keptBodyBytes is how much of an oversized body is echoed back into the
+// form, so the author doesn't lose all of it.
+const keptBodyBytes = 4 * 1024
+
+// tooLarge answers a create whose content is over MAX_BODY_SIZE with a
+// 413. Browsers get the form back with their title and the start of the
+// content; JSON clients get the limit in the body. Title and body are
+// empty when the request was too large to parse.
+func tooLarge(w http.ResponseWriter, r *http.Request, title, body string) {
+	msg := fmt.Sprintf("Content too large (max %s)", formatSize(config.MaxBodySize))
+	w.Header().Set("X-Max-Paste-Size", strconv.Itoa(config.MaxBodySize))
+
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(map[string]any{"error": msg, "max_size": config.MaxBodySize})
+		return
+	}
+	if _, browser := formAge(r.FormValue("form_token")); browser {
+		data := newIndexData(r)
+		data.Error = msg + ". Only the beginning of your content was kept."
+		data.Title = title
+		data.Body = strings.ToValidUTF8(body[:min(len(body), keptBodyBytes)], "")
+		renderStatus(w, r, http.StatusRequestEntityTooLarge, "index", data)
+		return
+	}
+	http.Error(w, msg, http.StatusRequestEntityTooLarge)
+}
+
+// indexData is what the index template renders.
+type indexData struct {
+	FormToken string
+
+	// Error, Title and Body refill the form after a rejected submission
+	Error string
+	Title string
+	Body  string
+
+	// Recent shows the link to /recent; it or Tags shows the public checkbox
+	Recent bool
+
+	// History shows the link to /mine
+	History bool
+
+	// Tags shows the tags field
+	Tags bool
+
+	// Comments shows the checkbox turning comments off
+	Comments bool
+
+	// TitleOptional drops the required attribute from the title field
+	TitleOptional bool
+
+	TTLOptions []string
+	DefaultTTL string
+
+	// MaxBodySize is the effective limit, for the size counter
+	MaxBodySize int
+
+	// MaxTitleLength caps the title field
+	MaxTitleLength int
+
+	// PowChallenge is empty when proof of work is off
+	PowChallenge string
+	PowExpiry    int
+}
+
+// viewData is what the view template renders.
+type viewData struct {
+	*Paste
+
+	// Inline is false when the body has lines too long to render safely,
+	// in which case the page only links to the raw version.
+	Inline bool
+
+	// Visible is the body with invisible characters shown, set for ?debug=1
+	Visible string
+
+	// Wrap soft-wraps long lines instead of scrolling them
+	Wrap bool
+
+	// Text is the body as rendered, with tabs expanded to TabWidth
+	Text     []byte
+	TabWidth int
+
+	// Preview is set when Text is only the start of a large paste
+	Preview *preview
+
+	// NoIndex asks search engines not to index the page
+	NoIndex bool
+
+	// Footer is the operator's VIEW_FOOTER, shown on the view page only
+	Footer string
+
+	// Comments is the thread shown under the paste when CommentsOpen
+	Comments     []comment
+	CommentsOpen bool
+}
+
+// absoluteURL turns a site-relative path into a full URL. Every absolute
+// URL tinypaste emits is built here, from BASE_URL when it is set and
+// otherwise from the request, as requestOrigin reads it.
+func absoluteURL(r *http.Request, path string) string {
+	path = sitePath(path)
+	if config.BaseURL != "" {
+		return config.BaseURL + path
+	}
+	return requestOrigin(r) + path
+}
+
+// clientIP returns the address of the client, taken from the last
+// X-Forwarded-For hop when running behind a trusted proxy.
+func clientIP(r *http.Request) string {
+	if config.TrustProxy {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			hops := strings.Split(xff, ",")
+			return strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// reloadOnHUP rereads the blocklist, auth and IP list files whenever SIGHUP arrives.
+// A file that fails to load leaves its previous contents in effect.
+func reloadOnHUP() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if config.BlocklistFile != "" {
+			if err := loadBlocklist(config.BlocklistFile); err != nil {
+				slog.Error("blocklist reload failed, keeping previous rules", "err", err)
+			}
+		}
+		if config.AuthFile != "" {
+			if err := loadAuthFile(config.AuthFile); err != nil {
+				slog.Error("auth file reload failed, keeping previous users", "err", err)
+			}
+		}
+		if config.IPAllowFile != "" || config.IPDenyFile != "" {
+			if err := loadIPLists(); err != nil {
+				slog.Error("ip list reload failed, keeping previous lists", "err", err)
+			}
+		}
+	}
+}
+
+// registerRoutes adds the site's handlers to mux.
+func registerRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/", mainHandler)
+	mux.HandleFunc("/p/", pasteHandler)
+	mux.HandleFunc("/save", withRateLimitHeaders(saveHandler))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/challenge", challengeHandler)
+	mux.Handle("/theme", http.NewCrossOriginProtection().Handler(http.HandlerFunc(themeHandler)))
+	mux.HandleFunc("/api/upload-urls", withRateLimitHeaders(uploadURLsHandler))
+	mux.HandleFunc("/api/config", withRateLimitHeaders(configHandler))
+	mux.HandleFunc("/api/stats", withRateLimitHeaders(statsHandler))
+	if config.PublicStats {
+		mux.HandleFunc("/stats.json", publicStatsHandler)
+	}
+	mux.HandleFunc("/tag/", tagHandler)
+	mux.HandleFunc("/tags/", tagHandler)
+	mux.HandleFunc("/tags", tagsHandler)
+	mux.HandleFunc("/mine", mineHandler)
+	mux.HandleFunc("/u/", uploadHandler)
+	mux.Handle("/static/", staticHandler())
+	mux.Handle("/favicon.ico", staticHandler())
+	mux.HandleFunc("/admin", requireAdmin(adminHandler))
+	mux.HandleFunc("/admin/quarantine", requireAdmin(quarantineHandler))
+	mux.Handle("/admin/approve", http.NewCrossOriginProtection().Handler(requireAdmin(approveHandler)))
+	adminDelete := http.NewCrossOriginProtection().Handler(requireAdmin(adminDeleteHandler))
+	mux.Handle("/admin/pastes", adminDelete)
+	mux.Handle("/admin/pastes/", adminDelete)
+	mux.Handle("/admin/takedown", http.NewCrossOriginProtection().Handler(requireAdmin(takedownHandler)))
+	mux.Handle("/admin/integrity", http.NewCrossOriginProtection().Handler(requireAdmin(integrityHandler)))
+	if config.MetricsEnabled {
+		mux.HandleFunc("/metrics", metricsHandler)
+	}
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		runAudit(os.Args[2:])
+		return
+	}
+
+	loadConfig()
+	if config.Store == "memory" {
+		store = newMemStore(int64(config.MemoryLimit))
+		metrics.Gauge("memory_store_bytes", func() float64 { return float64(store.(*memStore).Size()) })
+	}
+	if err := initFormKey(); err != nil {
+		slog.Error("generate form key", "err", err)
+		os.Exit(1)
+	}
+	initPrivacySalt()
+	initAssets()
+	if config.BlocklistFile != "" {
+		if err := loadBlocklist(config.BlocklistFile); err != nil {
+			slog.Error("load blocklist", "err", err)
+			os.Exit(1)
+		}
+	}
+	if config.AuthFile != "" {
+		if err := loadAuthFile(config.AuthFile); err != nil {
+			slog.Error("load auth file", "err", err)
+			os.Exit(1)
+		}
+	}
+	if config.IPAllowFile != "" || config.IPDenyFile != "" {
+		if err := loadIPLists(); err != nil {
+			slog.Error("load ip lists", "err", err)
+			os.Exit(1)
+		}
+	}
+	go reloadOnHUP()
+	if config.ScannerURL != "" {
+		s, err := newScanner(config.ScannerURL)
+		if err != nil {
+			slog.Error("content scanner", "err", err)
+			os.Exit(1)
+		}
+		contentScanner = s
+	}
+	if !validModerationAction(config.ModerationDefault) {
+		slog.Error("invalid moderation-default", "action", config.ModerationDefault)
+		os.Exit(1)
+	}
+
+	registerGlobalGauges()
+	initSaveSlots()
+
+	// Tag listings are served from an index kept in memory
+	if config.TagsEnabled {
+		loadTagIndex()
+	}
+
+	// Persist per-client quotas so a restart doesn't reset them
+	if config.QuotaCount > 0 || config.QuotaBytes > 0 {
+		loadQuotas()
+		go func() {
+			for {
+				time.Sleep(time.Minute)
+				if err := saveQuotas(); err != nil {
+					slog.Error("save quotas", "err", err)
+				}
+			}
+		}()
+	}
+
+	// Count what is stored; cleanup keeps the counts up to date after this
+	go tallyBuckets(0, 256)
+	
+	// The integrity scan only reads and renames, so it can run while serving
+	if config.IntegrityScan {
+		go scanIntegrity(config.IntegrityRepair)
+	}
+	
+	// Evict stale per-client state every minute
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			sweepState(time.Now())
+		}
+	}()
+	
 	// Cleanup job runs every 30min
 	go func() {
 		for {
@@ -298,14 +1578,101 @@ func main() {
 		}
 	}()
 
-	http.HandleFunc("/", mainHandler)
-	http.HandleFunc("/save", saveHandler)
+	registerRoutes(http.DefaultServeMux)
+
+	if config.SyncMode == syncBatch {
+		go func() {
+			for {
+				time.Sleep(batchInterval)
+				flushBatch()
+			}
+		}()
+	}
+	metrics.Gauge(`sync_mode{mode="`+config.SyncMode+`"}`, func() float64 { return 1 })
+	registerStatsGauges()
+
+	slog.Info("starting server", "port", config.Port, "max_body_size", formatSize(config.MaxBodySize), "sync_mode", config.SyncMode, "store", config.Store)
+	if config.Store == "memory" {
+		slog.Warn("memory store: pastes are lost when the server restarts", "memory_limit", formatSize(config.MemoryLimit))
+	}
+	if config.Dev {
+		slog.Warn("dev mode: templates are re-read for every page")
+	}
+
+	// Under systemd socket activation the socket is already bound, which
+	// lets an unprivileged tinypaste serve on port 80
+	ln, err := systemdListener()
+	if err != nil {
+		slog.Error("socket activation", "err", err)
+		os.Exit(1)
+	}
+	if ln != nil {
+		slog.Info("using socket from systemd", "addr", ln.Addr())
+	} else if ln, err = net.Listen("tcp", ":"+config.Port); err != nil {
+		slog.Error("listen", "err", err)
+		os.Exit(1)
+	}
+	srv := newServer(withRequestID(stripBasePath(requestMetrics(accessLog(securityHeaders(limitInFlight(checkIP(checkHost(requireLogin(methodOverride(http.DefaultServeMux)))))))))))
+	stopped := make(chan struct{})
+	go func() {
+		shutdownOnSignal(srv)
+		close(stopped)
+	}()
+	if err := sdNotify("READY=1"); err != nil {
+		slog.Warn("notify systemd", "err", err)
+	}
+	err = srv.Serve(ln)
+	if errors.Is(err, http.ErrServerClosed) {
+		<-stopped
+		return
+	}
+	slog.Error("server stopped", "err", err)
+	os.Exit(1)
+}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+// newServer returns the server for handler. Its timeouts bound slow
+// clients without cutting off long downloads: over HTTP/2 each applies per
+// connection, which carries many requests. With H2C_ENABLED the plaintext
+// listener also speaks HTTP/2 to clients that know it does, such as load
+// balancers; browsers only use HTTP/2 over TLS.
+func newServer(handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+	if config.H2C {
+		srv.Protocols = new(http.Protocols)
+		srv.Protocols.SetHTTP1(true)
+		srv.Protocols.SetHTTP2(true)
+		srv.Protocols.SetUnencryptedHTTP2(true)
 	}
+	return srv
+}
 
-	log.Printf("Starting server on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+// shutdownOnSignal stops srv gracefully on SIGTERM or SIGINT: the listener
+// is closed, inherited or not, and requests in flight get shutdownTimeout
+// to finish. Pending syncs and quota counters are written out after.
+func shutdownOnSignal(srv *http.Server) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	<-stop
+	slog.Info("shutting down")
+	sdNotify("STOPPING=1")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("shutdown", "err", err)
+	}
+	if config.SyncMode == syncBatch {
+		flushBatch()
+	}
+	if config.QuotaCount > 0 || config.QuotaBytes > 0 {
+		if err := saveQuotas(); err != nil {
+			slog.Error("save quotas", "err", err)
+		}
+	}
 }
+
+// shutdownTimeout bounds how long shutdown waits for requests in flight.
+const shutdownTimeout = 10 * time.Second
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMain starts every test from the default config, as the server would
+// run with no flags or environment set.
+func TestMain(m *testing.M) {
+	loadConfig()
+	if err := initFormKey(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	initAssets()
+	os.Exit(m.Run())
+}
+
+// useConfig restores the global config when the test ends, so a test can
+// set what it needs.
+func useConfig(t *testing.T) {
+	t.Helper()
+	saved := config
+	t.Cleanup(func() { config = saved })
+}
+
+// useDiskStore runs the test in an empty directory with the disk store.
+func useDiskStore(t *testing.T) {
+	t.Helper()
+	useConfig(t)
+	t.Chdir(t.TempDir())
+	old := store
+	store = diskStore{}
+	config.Store = "disk"
+	t.Cleanup(func() { store = old })
+}
+
+// useMemStore gives the test an empty memory store.
+func useMemStore(t *testing.T) {
+	t.Helper()
+	useConfig(t)
+	old := store
+	store = newMemStore(64 << 20)
+	config.Store = "memory"
+	t.Cleanup(func() { store = old })
+}
+
+// syncBuffer is a bytes.Buffer safe to log to from several goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLog sends the default logger to a buffer for the rest of the test.
+func captureLog(t *testing.T) *syncBuffer {
+	t.Helper()
+	buf := &syncBuffer{}
+	old := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(old) })
+	return buf
+}
+
+// savePaste writes a paste with the given ID and TTL, created at created.
+func savePaste(t *testing.T, id, ttl string, created time.Time) *Paste {
+	t.Helper()
+	p := &Paste{ID: id, Title: "test " + id, Body: []byte("hello\n"), TTL: ttl, Normalized: true}
+	if err := p.save(context.Background()); err != nil {
+		t.Fatalf("save %s: %v", id, err)
+	}
+	if err := store.Chtimes(p.path(), created); err != nil {
+		t.Fatalf("chtimes %s: %v", id, err)
+	}
+	return p
+}
+
+// postSave sends form to saveHandler as an API client asking for JSON.
+func postSave(t *testing.T, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/save", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	saveHandler(w, r)
+	return w
+}
+
+func TestCleanupRacesLoad(t *testing.T) {
+	for _, name := range []string{"disk", "memory"} {
+		t.Run(name, func(t *testing.T) {
+			if name == "disk" {
+				useDiskStore(t)
+			} else {
+				useMemStore(t)
+			}
+			config.TombstoneTTL = 24 * time.Hour
+			logs := captureLog(t)
+
+			for round := range 5 {
+				// The disk store is cleaned 16 buckets at a time, so keep
+				// the pastes in the first 16
+				cleanupOffset = 0
+				var ids []string
+				for i := range 64 {
+					id := fmt.Sprintf("%02x%06x%08x", i%16, round, i)
+					savePaste(t, id, "1h", time.Now().Add(-2*time.Hour))
+					ids = append(ids, id)
+				}
+
+				var wg sync.WaitGroup
+				wg.Go(cleanupExpired)
+				for _, id := range ids {
+					wg.Go(func() {
+						if _, err := loadPaste(id); !errors.Is(err, ErrExpired) && !errors.Is(err, ErrNotFound) {
+							t.Errorf("loadPaste(%s) = %v, want expired or not found", id, err)
+						}
+					})
+				}
+				wg.Wait()
+
+				for _, id := range ids {
+					if files, _ := store.Glob(bucket(id) + "/" + id + "_*.txt"); len(files) > 0 {
+						t.Errorf("%s still stored as %v", id, files)
+					}
+					if ts := loadTombstone(id); ts == nil || ts.Reason != removedExpired {
+						t.Errorf("%s tombstone = %+v, want one for expiry", id, ts)
+					}
+				}
+			}
+			if strings.Contains(logs.String(), "level=ERROR") {
+				t.Errorf("errors logged:\n%s", logs)
+			}
+		})
+	}
+}
+
+func TestCleanupKeepsLivePastes(t *testing.T) {
+	useDiskStore(t)
+	cleanupOffset = 0
+	live := savePaste(t, "00aaaaaaaaaaaaaa", "1h", time.Now())
+	expired := savePaste(t, "01bbbbbbbbbbbbbb", "1h", time.Now().Add(-2*time.Hour))
+
+	cleanupExpired()
+
+	if _, err := os.Stat(live.path()); err != nil {
+		t.Errorf("live paste removed: %v", err)
+	}
+	if _, err := os.Stat(expired.path()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expired paste still there: %v", err)
+	}
+}
+
+// getPaste fetches path from pasteHandler, as JSON if asked.
+func getPaste(path string, json bool) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	if json {
+		r.Header.Set("Accept", "application/json")
+	}
+	w := httptest.NewRecorder()
+	pasteHandler(w, r)
+	return w
+}
+
+// The headers of a paste about to expire must match its JSON exactly, and
+// a paste past its expiry must not be described at all.
+func TestPasteHeadersNearExpiry(t *testing.T) {
+	useMemStore(t)
+	// soon expires within two seconds, from an mtime with a fraction of a
+	// second that the headers and the JSON must drop alike; gone expired a
+	// second ago
+	now := time.Now()
+	soon := savePaste(t, "00aaaaaaaaaaaaaa", "1h", now.Add(-time.Hour+2*time.Second).Truncate(time.Second).Add(999*time.Millisecond))
+	gone := savePaste(t, "00bbbbbbbbbbbbbb", "1h", now.Add(-time.Hour-time.Second))
+
+	w := getPaste("/p/"+soon.ID, true)
+	if w.Code != http.StatusOK {
+		t.Fatalf("JSON: status %d: %s", w.Code, w.Body)
+	}
+	var api pasteJSON
+	if err := json.Unmarshal(w.Body.Bytes(), &api); err != nil {
+		t.Fatal(err)
+	}
+	expires, err := time.Parse(time.RFC3339, api.ExpiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !expires.After(now) || expires.Sub(now) > 3*time.Second {
+		t.Errorf("expires_at = %s, want within seconds after %s", api.ExpiresAt, now.UTC().Format(time.RFC3339))
+	}
+
+	for _, path := range []string{"/p/" + soon.ID, "/p/" + soon.ID + "/raw", "/p/" + soon.ID + "/download"} {
+		for _, asJSON := range []bool{false, true} {
+			if asJSON && path != "/p/"+soon.ID {
+				continue
+			}
+			w := getPaste(path, asJSON)
+			if w.Code != http.StatusOK {
+				t.Errorf("%s: status %d", path, w.Code)
+				continue
+			}
+			h := w.Header()
+			want := map[string]string{
+				"X-Paste-Id":         api.ID,
+				"X-Paste-Created-At": api.CreatedAt,
+				"X-Paste-Expires-At": api.ExpiresAt,
+				"X-Paste-Ttl":        api.TTL,
+				"X-Paste-Size":       strconv.Itoa(api.Size),
+			}
+			for name, v := range want {
+				if got := h.Get(name); got != v {
+					t.Errorf("%s (json %v): %s = %q, want %q", path, asJSON, name, got, v)
+				}
+			}
+			if path == "/p/"+soon.ID+"/raw" && w.Body.Len() != api.Size {
+				t.Errorf("raw body is %d bytes, X-Paste-Size says %d", w.Body.Len(), api.Size)
+			}
+		}
+	}
+
+	for _, path := range []string{"/p/" + gone.ID, "/p/" + gone.ID + "/raw"} {
+		w := getPaste(path, false)
+		if w.Code != http.StatusGone && w.Code != http.StatusNotFound {
+			t.Errorf("%s past expiry: status %d, want 410 or 404", path, w.Code)
+		}
+		if id := w.Header().Get("X-Paste-Id"); id != "" {
+			t.Errorf("%s past expiry still described as %s", path, id)
+		}
+	}
+}
+
+func TestSaveSizeLimit(t *testing.T) {
+	useMemStore(t)
+	config.MaxBodySize = 1024
+	// Bodies are stored with one trailing newline, which counts
+	atLimit := strings.Repeat("a", 1023)
+	overLimit := strings.Repeat("a", 1024)
+
+	if w := postSave(t, url.Values{"title": {"t"}, "body": {atLimit}, "ttl": {"1h"}}); w.Code != http.StatusCreated {
+		t.Errorf("at limit: status %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+
+	w := postSave(t, url.Values{"title": {"t"}, "body": {overLimit}, "ttl": {"1h"}})
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("limit+1: status %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	if got := w.Header().Get("X-Max-Paste-Size"); got != "1024" {
+		t.Errorf("limit+1: X-Max-Paste-Size = %q, want 1024", got)
+	}
+	var resp struct {
+		Error   string
+		MaxSize int `json:"max_size"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.MaxSize != 1024 || resp.Error == "" {
+		t.Errorf("limit+1: body %q, want the error and max_size 1024", w.Body)
+	}
+}
+
+// The web form comes back with the title and the start of the content.
+func TestSaveSizeLimitForm(t *testing.T) {
+	useMemStore(t)
+	config.MaxBodySize = 64 * 1024
+	body := strings.Repeat("0123456789abcdef", 8*1024)
+	form := url.Values{"title": {"my notes"}, "body": {body}, "ttl": {"1h"}, "form_token": {formToken()}}
+	r := httptest.NewRequest(http.MethodPost, "/save", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	saveHandler(w, r)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	page := w.Body.String()
+	if !strings.Contains(page, `value="my notes"`) {
+		t.Error("title not kept in the form")
+	}
+	if !strings.Contains(page, body[:keptBodyBytes]) || strings.Contains(page, body[:keptBodyBytes+1]) {
+		t.Errorf("form should keep exactly the first %d bytes of the content", keptBodyBytes)
+	}
+}
+
+// A chunked body has no Content-Length to check up front; it is cut off
+// once it passes the limit.
+func TestSaveSizeLimitChunked(t *testing.T) {
+	useMemStore(t)
+	config.MaxBodySize = 1024
+	var chunked sync.Map
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chunked.Store(r.URL.Query().Get("case"), slices.Contains(r.TransferEncoding, "chunked"))
+		saveHandler(w, r)
+	}))
+	defer srv.Close()
+
+	for name, size := range map[string]int{"over limit": 2048, "over read limit": 16 << 20} {
+		t.Run(name, func(t *testing.T) {
+			pr, pw := io.Pipe()
+			go func() {
+				io.WriteString(pw, "title=t&ttl=1h&body=")
+				chunk := strings.Repeat("a", 4096)
+				for n := 0; n < size; n += len(chunk) {
+					if _, err := io.WriteString(pw, chunk[:min(len(chunk), size-n)]); err != nil {
+						return
+					}
+				}
+				pw.Close()
+			}()
+			req, err := http.NewRequest(http.MethodPost, srv.URL+"?case="+url.QueryEscape(name), pr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Accept", "application/json")
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			pr.Close()
+			if ok, _ := chunked.Load(name); ok != true {
+				t.Fatal("request body wasn't chunked")
+			}
+			if resp.StatusCode != http.StatusRequestEntityTooLarge {
+				t.Errorf("status %d, want %d", resp.StatusCode, http.StatusRequestEntityTooLarge)
+			}
+			if got := resp.Header.Get("X-Max-Paste-Size"); got != "1024" {
+				t.Errorf("X-Max-Paste-Size = %q, want 1024", got)
+			}
+		})
+	}
+}
+
+// follow serves target through a mux routing like the server's, following
+// redirects, and returns where it ended up, the status there and how many
+// redirects it took.
+func follow(t *testing.T, target string) (final string, status, hops int) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", mainHandler)
+	mux.HandleFunc("/p/", pasteHandler)
+	for hops = 0; hops < 5; hops++ {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		loc := w.Header().Get("Location")
+		if w.Code < 300 || w.Code >= 400 || loc == "" {
+			return target, w.Code, hops
+		}
+		u, err := r.URL.Parse(loc)
+		if err != nil {
+			t.Fatalf("%s: bad Location %q", target, loc)
+		}
+		target = u.RequestURI()
+	}
+	t.Fatalf("%s: too many redirects", target)
+	return
+}
+
+func TestPasteURLNormalization(t *testing.T) {
+	useMemStore(t)
+	id := "0123456789abcdef"
+	savePaste(t, id, "1h", time.Now())
+	canonical := "/p/" + id
+
+	tests := []struct {
+		url    string
+		want   string // where it should end up, empty for a 404
+		status int
+	}{
+		{canonical, canonical, http.StatusOK},
+		{canonical + "/", canonical, http.StatusOK},
+		{canonical + "//", canonical, http.StatusOK},
+		{"//p/" + id, canonical, http.StatusOK},
+		{"/p//" + id, canonical, http.StatusOK},
+		{"/p/x/../" + id, canonical, http.StatusOK},
+		{canonical + "?utm_source=twitter", canonical + "?utm_source=twitter", http.StatusOK},
+		{canonical + "/?utm_source=twitter", canonical + "?utm_source=twitter", http.StatusOK},
+		{canonical + ".", canonical, http.StatusOK},
+		{canonical + "),", canonical, http.StatusOK},
+		{canonical + "%29", canonical, http.StatusOK},
+		{"/p/0123456789ABCDEF", canonical, http.StatusOK},
+		{"/p/%30123456789abcdef", "/p/%30123456789abcdef", http.StatusOK},
+		{canonical + "/raw/", canonical + "/raw", http.StatusOK},
+		{"/" + id, canonical, http.StatusOK},
+		{"/" + id + "/", canonical, http.StatusOK},
+		{"/" + id + "/raw", canonical + "/raw", http.StatusOK},
+		{"/" + id + ".", canonical, http.StatusOK},
+		{"/", "/", http.StatusOK},
+		{"/about/", "/about", http.StatusOK},
+		{"/p/0123456789abcde", "", http.StatusNotFound},
+		{"/p/0123456789abcdeg", "", http.StatusNotFound},
+		{"/p/%2e%2e%2fetc%2fpasswd", "", http.StatusNotFound},
+		{"/p/", "", http.StatusNotFound},
+		{"/p", "", http.StatusNotFound},
+		{"/nonsense/", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		final, status, hops := follow(t, tt.url)
+		if status != tt.status {
+			t.Errorf("%s: ended at %s with %d, want %d", tt.url, final, status, tt.status)
+			continue
+		}
+		if tt.want != "" && final != tt.want {
+			t.Errorf("%s: ended at %s, want %s", tt.url, final, tt.want)
+		}
+		if hops > 2 {
+			t.Errorf("%s: took %d redirects", tt.url, hops)
+		}
+	}
+}
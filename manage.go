@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Every new paste gets a delete token that lets its creator remove it.
+// Only a hash is stored, and the token itself is returned once, in the
+// create response.
+
+func generateDeleteToken() (string, error) {
+	return randomHex(16)
+}
+
+// deleteToken returns the token sent with an owner delete.
+func deleteToken(r *http.Request) string {
+	if token := r.Header.Get("X-Paste-Delete-Token"); token != "" {
+		return token
+	}
+	return r.FormValue("token")
+}
+
+// ownerDelete removes p for a creator presenting its delete token and
+// leaves a tombstone saying so.
+func ownerDelete(w http.ResponseWriter, r *http.Request, p *Paste) {
+	token := deleteToken(r)
+	if p.deleteHash == "" || token == "" ||
+		subtle.ConstantTimeCompare([]byte(bodyHash([]byte(token))), []byte(p.deleteHash)) != 1 {
+		http.Error(w, "Invalid delete token", http.StatusForbidden)
+		return
+	}
+	if _, err := deletePaste(p.ID); err != nil {
+		requestLog(r.Context()).Error("delete paste", "id", p.ID, "err", err)
+		internalError(w, r)
+		return
+	}
+	if err := writeTombstone(p.ID, removedByCreator, time.Now()); err != nil {
+		requestLog(r.Context()).Error("write tombstone", "id", p.ID, "err", err)
+	}
+	slog.Info("creator deleted paste", "id", p.ID)
+
+	// HTML forms arrive through methodOverride and expect a page back
+	if r.FormValue("_method") != "" {
+		http.Redirect(w, r, sitePath(pastePath(p.ID, "")), http.StatusSeeOther)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// createdData is what the created template renders and the JSON create
+// response contains.
+type createdData struct {
+	ID          string `json:"id"`
+	URL         string `json:"url"`
+	RawURL      string `json:"raw_url"`
+	DeleteToken string `json:"delete_token"`
+	Private     bool   `json:"private"`
+}
+
+// wantsJSON reports whether the client asked for a JSON create response.
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// respondCreated answers a successful create. The delete token is only
+// ever in this response, never in a URL: browsers get a page showing it,
+// JSON clients get it in the body and everyone gets it as a header. Plain
+// clients such as curl keep getting a redirect to the paste.
+func respondCreated(w http.ResponseWriter, r *http.Request, p *Paste, token string) {
+	data := createdData{
+		ID:          p.ID,
+		URL:         absoluteURL(r, p.URLPath()),
+		RawURL:      absoluteURL(r, p.URLPath()+"/raw"),
+		DeleteToken: token,
+		Private:     p.Secret != "",
+	}
+	w.Header().Set("X-Paste-Delete-Token", token)
+	w.Header().Set("Cache-Control", "private, no-store")
+
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", data.URL)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(data)
+		return
+	}
+	if _, browser := formAge(r.FormValue("form_token")); browser || data.Private {
+		w.Header().Set("Location", data.URL)
+		renderStatus(w, r, http.StatusCreated, "created", data)
+		return
+	}
+	http.Redirect(w, r, sitePath(p.URLPath()), http.StatusFound)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// counters is a minimal set of named, monotonically increasing counters.
+// Names may carry a Prometheus style label set, e.g. `foo_total{x="y"}`.
+type counters struct {
+	mu     sync.Mutex
+	m      map[string]int64
+	gauges map[string]func() float64
+	hists  map[histKey]*histogram
+}
+
+var metrics = &counters{
+	m:      make(map[string]int64),
+	gauges: make(map[string]func() float64),
+	hists:  make(map[histKey]*histogram),
+}
+
+// latencyBuckets are the upper bounds, in seconds, of request duration
+// histograms.
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histKey names one histogram series: a metric name and its label set
+// without braces, e.g. `route="/save",status="200"`.
+type histKey struct {
+	name, labels string
+}
+
+// histogram counts observations per bucket of latencyBuckets; the last
+// count is for values above all of them.
+type histogram struct {
+	counts []int64
+	sum    float64
+}
+
+func (c *counters) Inc(name string) {
+	c.mu.Lock()
+	c.m[name]++
+	c.mu.Unlock()
+}
+
+// Gauge registers a value computed on every scrape.
+func (c *counters) Gauge(name string, fn func() float64) {
+	c.mu.Lock()
+	c.gauges[name] = fn
+	c.mu.Unlock()
+}
+
+// Observe adds v to the histogram series name{labels}.
+func (c *counters) Observe(name, labels string, v float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := histKey{name, labels}
+	h := c.hists[key]
+	if h == nil {
+		h = &histogram{counts: make([]int64, len(latencyBuckets)+1)}
+		c.hists[key] = h
+	}
+	h.counts[sort.SearchFloat64s(latencyBuckets, v)]++
+	h.sum += v
+}
+
+// metricsHandler writes every counter, gauge and histogram in the
+// Prometheus text format.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	metrics.mu.Lock()
+	names := make([]string, 0, len(metrics.m))
+	for name := range metrics.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	values := make([]int64, len(names))
+	for i, name := range names {
+		values[i] = metrics.m[name]
+	}
+	gauges := make(map[string]func() float64, len(metrics.gauges))
+	for name, fn := range metrics.gauges {
+		gauges[name] = fn
+	}
+	keys := make([]histKey, 0, len(metrics.hists))
+	hists := make(map[histKey]histogram, len(metrics.hists))
+	for key, h := range metrics.hists {
+		keys = append(keys, key)
+		hists[key] = histogram{counts: slices.Clone(h.counts), sum: h.sum}
+	}
+	metrics.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for i, name := range names {
+		fmt.Fprintf(w, "%s %d\n", name, values[i])
+	}
+	gaugeNames := make([]string, 0, len(gauges))
+	for name := range gauges {
+		gaugeNames = append(gaugeNames, name)
+	}
+	sort.Strings(gaugeNames)
+	for _, name := range gaugeNames {
+		fmt.Fprintf(w, "%s %g\n", name, gauges[name]())
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].name < keys[j].name || keys[i].name == keys[j].name && keys[i].labels < keys[j].labels
+	})
+	for _, key := range keys {
+		h := hists[key]
+		var total int64
+		for i, n := range h.counts {
+			total += n
+			le := "+Inf"
+			if i < len(latencyBuckets) {
+				le = strconv.FormatFloat(latencyBuckets[i], 'g', -1, 64)
+			}
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", key.name, key.labels, le, total)
+		}
+		fmt.Fprintf(w, "%s_sum{%s} %g\n", key.name, key.labels, h.sum)
+		fmt.Fprintf(w, "%s_count{%s} %d\n", key.name, key.labels, total)
+	}
+}
+
+// requestMetrics records how long each request took in the
+// http_request_duration_seconds histogram, by route and status.
+func requestMetrics(next http.Handler) http.Handler {
+	if !config.MetricsEnabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		route := routeLabel(r)
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		labels := fmt.Sprintf(`route="%s",status="%d"`, route, rec.status)
+		metrics.Observe("http_request_duration_seconds", labels, time.Since(start).Seconds())
+	})
+}
+
+// subtreeRoutes label requests under a subtree pattern by the part that
+// varies, so paths full of IDs and tokens don't each get a series.
+var subtreeRoutes = map[string]string{
+	"/tag/":          "/tag/{tag}",
+	"/tags/":         "/tags/{tag}",
+	"/u/":            "/u/{grant}",
+	"/static/":       "/static/{file}",
+	"/admin/pastes/": "/admin/pastes/{id}",
+}
+
+// pageRoutes are the pages mainHandler serves from the catch-all pattern.
+var pageRoutes = []string{"/", "/about", "/legal", "/robots.txt", "/sitemap.xml", "/recent"}
+
+// routeLabel names the route r is served by, with a bounded set of
+// values: registered patterns, placeholders for IDs and tokens, and
+// "other" for anything unknown.
+func routeLabel(r *http.Request) string {
+	_, pattern := http.DefaultServeMux.Handler(r)
+	switch {
+	case pattern == "":
+		return "other"
+	case pattern == "/p/":
+		return pasteRoute(strings.TrimPrefix(r.URL.Path, "/p/"))
+	case subtreeRoutes[pattern] != "":
+		return subtreeRoutes[pattern]
+	case pattern != "/":
+		return pattern
+	case slices.Contains(pageRoutes, r.URL.Path):
+		return r.URL.Path
+	}
+	// Legacy /<id> links, redirected to /p/<id>
+	if id, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/"); isValidID(id) {
+		return "/{id}"
+	}
+	return "other"
+}
+
+// pasteRoute labels a path below /p/ as /p/{id} plus the view it asks
+// for; the secret of private pastes is left out.
+func pasteRoute(rest string) string {
+	for _, suffix := range []string{"/raw", "/hash", "/download", "/print"} {
+		if strings.HasSuffix(rest, suffix) {
+			return "/p/{id}" + suffix
+		}
+	}
+	return "/p/{id}"
+}
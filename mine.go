@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The history cookie lists the pastes created from a browser, for /mine.
+// Its value is "<id>[:<secret>]/.../<sig>", oldest first, signed with
+// formKey so a visitor can't add IDs of pastes they didn't create and use
+// the page to probe which exist.
+const (
+	historyCookie = "mine"
+	maxHistory    = 50
+)
+
+// historyEntry is a paste in the history cookie. Secret is set for
+// private pastes, which can't be found without it.
+type historyEntry struct {
+	ID     string
+	Secret string
+}
+
+func signHistory(payload string) string {
+	return signChallenge("mine:" + payload)
+}
+
+// readHistory returns the pastes in the request's history cookie, oldest
+// first, or nil if there is none or it isn't signed by us.
+func readHistory(r *http.Request) []historyEntry {
+	c, err := r.Cookie(historyCookie)
+	if err != nil {
+		return nil
+	}
+	payload, sig, ok := cutLast(c.Value, "/")
+	if !ok || !hmac.Equal([]byte(sig), []byte(signHistory(payload))) {
+		return nil
+	}
+	var history []historyEntry
+	for _, s := range strings.Split(payload, "/") {
+		id, secret, _ := strings.Cut(s, ":")
+		if isValidID(id) {
+			history = append(history, historyEntry{ID: id, Secret: secret})
+		}
+	}
+	return history
+}
+
+// setHistory stores history in the cookie, keeping the newest maxHistory
+// entries. An empty history removes the cookie.
+func setHistory(w http.ResponseWriter, r *http.Request, history []historyEntry) {
+	c := &http.Cookie{
+		Name:     historyCookie,
+		Path:     sitePath("/"),
+		MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if len(history) > maxHistory {
+		history = history[len(history)-maxHistory:]
+	}
+	if len(history) == 0 {
+		c.MaxAge = -1
+	} else {
+		parts := make([]string, len(history))
+		for i, e := range history {
+			parts[i] = e.ID
+			if e.Secret != "" {
+				parts[i] += ":" + e.Secret
+			}
+		}
+		payload := strings.Join(parts, "/")
+		c.Value = payload + "/" + signHistory(payload)
+	}
+	http.SetCookie(w, c)
+}
+
+// rememberPaste adds p to the browser's history after it is created.
+func rememberPaste(w http.ResponseWriter, r *http.Request, p *Paste) {
+	if !config.HistoryEnabled {
+		return
+	}
+	history := slices.DeleteFunc(readHistory(r), func(e historyEntry) bool { return e.ID == p.ID })
+	setHistory(w, r, append(history, historyEntry{ID: p.ID, Secret: p.Secret}))
+}
+
+// statPaste finds the file stored for id, as loadPaste would, without
+// reading it.
+func statPaste(id string) (pasteFile, error) {
+	files, err := store.Glob(bucket(id) + "/" + id + "_*.txt")
+	if err != nil || len(files) == 0 {
+		return pasteFile{}, ErrNotFound
+	}
+	path := files[0]
+	if len(files) > 1 {
+		path = pickPasteFile(files)
+	}
+	_, ttl, _ := strings.Cut(strings.TrimSuffix(filepath.Base(path), ".txt"), "_")
+	if _, ok := TTLHours[ttl]; !ok {
+		return pasteFile{}, fmt.Errorf("%w: unknown TTL %q", ErrCorrupt, ttl)
+	}
+	info, err := store.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return pasteFile{}, ErrNotFound
+	}
+	if err != nil {
+		return pasteFile{}, err
+	}
+	return pasteFile{Path: path, ID: id, TTL: ttl, Created: info.ModTime(), Size: info.Size()}, nil
+}
+
+// mineEntry is one row of the /mine page.
+type mineEntry struct {
+	Path    string
+	Title   string
+	Created time.Time
+	Left    string
+}
+
+// mineHandler serves /mine, the pastes created from this browser that are
+// still there, newest first. Only the metadata line of each is read. Gone
+// pastes are dropped from the cookie; quarantined ones stay there but
+// aren't shown until approved. POST forgets the history.
+func mineHandler(w http.ResponseWriter, r *http.Request) {
+	if !config.HistoryEnabled {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost:
+		if err := http.NewCrossOriginProtection().Check(r); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		setHistory(w, r, nil)
+		http.Redirect(w, r, sitePath("/mine"), http.StatusSeeOther)
+		return
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	history := readHistory(r)
+	var kept []historyEntry
+	var entries []mineEntry
+	now := time.Now()
+	for _, e := range history {
+		f, err := statPaste(e.ID)
+		if errors.Is(err, ErrNotFound) || err == nil && f.expired(now) {
+			continue
+		}
+		var meta pasteMeta
+		if err == nil {
+			meta, err = readHeader(f.Path)
+		}
+		if errors.Is(err, fs.ErrNotExist) || err == nil && meta.Secret != e.Secret {
+			continue
+		}
+		kept = append(kept, e)
+		if err != nil || meta.Quarantine {
+			// Keep it for when the error clears or the paste is approved,
+			// but there's nothing to show
+			continue
+		}
+		entries = append(entries, mineEntry{
+			Path:    pastePath(e.ID, meta.Secret),
+			Title:   meta.Title,
+			Created: f.Created,
+			Left:    formatLeft(f.expires().Sub(now)),
+		})
+	}
+	if len(kept) < len(history) {
+		setHistory(w, r, kept)
+	}
+	slices.Reverse(entries)
+	renderTemplate(w, r, "mine", entries)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// mirrorHeader marks requests a mirror makes to its upstream. A mirror
+// never fetches on behalf of such a request, so mirrors pointed at each
+// other can't loop.
+const mirrorHeader = "X-Tinypaste-Mirror"
+
+var upstreamClient = &http.Client{Timeout: 10 * time.Second}
+
+// errReadOnly rejects writes on a mirror.
+var errReadOnly = errors.New("This instance is a read-only mirror")
+
+// fetchUpstream copies a paste this mirror doesn't have from the upstream
+// instance and stores it with its original creation time and TTL, so it
+// expires here when it does there. It returns ErrNotFound or ErrExpired
+// when the upstream has nothing to serve.
+func fetchUpstream(ctx context.Context, id, secret string) (*Paste, error) {
+	path := pastePath(id, secret)
+	var meta pasteJSON
+	if err := getUpstream(ctx, path, "application/json", func(body io.Reader) error {
+		return json.NewDecoder(body).Decode(&meta)
+	}); err != nil {
+		return nil, err
+	}
+	created, err := time.Parse(time.RFC3339, meta.CreatedAt)
+	if err != nil || meta.ID != id || TTLHours[meta.TTL] == 0 {
+		return nil, fmt.Errorf("upstream sent invalid metadata for %s", id)
+	}
+
+	// JSON can't carry bytes that aren't UTF-8; take those from /raw
+	body := []byte(meta.Body)
+	if meta.BodyEncoding == "base64" {
+		body, _ = base64.StdEncoding.DecodeString(meta.Body)
+	}
+	if bodyHash(body) != meta.SHA256 {
+		if err := getUpstream(ctx, path+"/raw", "", func(r io.Reader) error {
+			body, err = io.ReadAll(io.LimitReader(r, int64(config.MaxBodySize)+1))
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		if bodyHash(body) != meta.SHA256 {
+			return nil, fmt.Errorf("upstream body of %s doesn't match its digest", id)
+		}
+	}
+
+	p := &Paste{
+		ID:          id,
+		Title:       meta.Title,
+		Body:        body,
+		TTL:         meta.TTL,
+		Language:    meta.Language,
+		Secret:      secret,
+		Filename:    meta.Filename,
+		Cipher:      meta.Cipher,
+		Compression: meta.Compression,
+		Tags:        meta.Tags,
+		Created:     created,
+	}
+	if time.Now().After(p.Expires()) {
+		return nil, ErrExpired
+	}
+	if err := p.save(ctx); err != nil {
+		return nil, err
+	}
+	if err := store.Chtimes(p.path(), created); err != nil {
+		return nil, err
+	}
+	tallyStored(p.path())
+	return p, nil
+}
+
+// getUpstream fetches path from the upstream and hands the body to read.
+// A 404 is ErrNotFound and a 410 ErrExpired.
+func getUpstream(ctx context.Context, path, accept string, read func(io.Reader) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(config.Upstream, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(mirrorHeader, "1")
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := upstreamClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return read(io.LimitReader(resp.Body, 4*int64(config.MaxBodySize)+64*1024))
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusGone:
+		return ErrExpired
+	}
+	return fmt.Errorf("upstream answered %s", resp.Status)
+}
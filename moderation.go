@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// Moderation actions returned by the webhook.
+const (
+	moderationAllow      = "allow"
+	moderationDeny       = "deny"
+	moderationQuarantine = "quarantine"
+)
+
+// moderationRequest is POSTed to the moderation URL before a paste is
+// stored. Body is only included when configured.
+type moderationRequest struct {
+	ID         string `json:"id"`
+	Title      string `json:"title"`
+	BodySHA256 string `json:"body_sha256"`
+	Size       int    `json:"size"`
+	ClientIP   string `json:"client_ip"`
+	Body       string `json:"body,omitempty"`
+}
+
+func validModerationAction(action string) bool {
+	switch action {
+	case moderationAllow, moderationDeny, moderationQuarantine:
+		return true
+	}
+	return false
+}
+
+// moderate asks the moderation webhook what to do with a paste. Any
+// failure, including a timeout, yields the configured default action.
+func moderate(ctx context.Context, r *http.Request, p *Paste) string {
+	action, err := callModeration(ctx, r, p)
+	if err != nil {
+		slog.Warn("moderation webhook failed, using default action", "id", p.ID, "action", config.ModerationDefault, "err", err)
+		action = config.ModerationDefault
+	}
+	metrics.Inc(fmt.Sprintf(`moderation_total{action="%s"}`, action))
+	return action
+}
+
+func callModeration(ctx context.Context, r *http.Request, p *Paste) (string, error) {
+	payload := moderationRequest{
+		ID:         p.ID,
+		Title:      p.Title,
+		BodySHA256: bodyHash(p.Body),
+		Size:       len(p.Body),
+		ClientIP:   anonIP(clientIP(r)),
+	}
+	if config.ModerationSendBody {
+		payload.Body = string(p.Body)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.ModerationTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.ModerationURL, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("moderation returned %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Action string `json:"action"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("decode moderation response: %v", err)
+	}
+	if !validModerationAction(result.Action) {
+		return "", fmt.Errorf("unknown moderation action %q", result.Action)
+	}
+	return result.Action, nil
+}
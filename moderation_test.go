@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeModeration is a moderation webhook that records what it was sent
+// and whether the paste was already stored when it was asked.
+type fakeModeration struct {
+	mu      sync.Mutex
+	calls   []moderationRequest
+	stored  []bool
+	respond func(w http.ResponseWriter)
+}
+
+func (m *fakeModeration) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req moderationRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	files, _ := store.Glob(bucket(req.ID) + "/" + req.ID + "_*.txt")
+	m.mu.Lock()
+	m.calls = append(m.calls, req)
+	m.stored = append(m.stored, len(files) > 0)
+	m.mu.Unlock()
+	m.respond(w)
+}
+
+func answer(action string) func(http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		io.WriteString(w, `{"action":"`+action+`"}`)
+	}
+}
+
+// useModeration points MODERATION_URL at m for the rest of the test.
+func useModeration(t *testing.T, m *fakeModeration) {
+	t.Helper()
+	srv := httptest.NewServer(m)
+	t.Cleanup(srv.Close)
+	config.ModerationURL = srv.URL
+	config.ModerationTimeout = 100 * time.Millisecond
+}
+
+func useBlocklist(t *testing.T, patterns ...string) {
+	t.Helper()
+	old := blockRules.Load()
+	var rules []blockRule
+	for _, p := range patterns {
+		rules = append(rules, blockRule{re: regexp.MustCompile(p), metric: "blocklist_matches_total"})
+	}
+	blockRules.Store(&rules)
+	t.Cleanup(func() { blockRules.Store(old) })
+}
+
+// createdID returns the ID from a JSON create response.
+func createdID(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var created struct{ ID string }
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || created.ID == "" {
+		t.Fatalf("no paste ID in %d response %q", w.Code, w.Body)
+	}
+	return created.ID
+}
+
+func TestModerationActions(t *testing.T) {
+	tests := []struct {
+		action     string
+		want       int
+		stored     bool
+		quarantine bool
+	}{
+		{moderationAllow, http.StatusCreated, true, false},
+		{moderationDeny, http.StatusUnprocessableEntity, false, false},
+		{moderationQuarantine, http.StatusAccepted, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			useMemStore(t)
+			m := &fakeModeration{respond: answer(tt.action)}
+			useModeration(t, m)
+
+			w := postSave(t, url.Values{"title": {"moderated"}, "body": {"hello"}, "ttl": {"1h"}})
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if len(m.calls) != 1 {
+				t.Fatalf("webhook called %d times, want once", len(m.calls))
+			}
+			if m.stored[0] {
+				t.Error("paste was stored before the webhook answered")
+			}
+
+			id := m.calls[0].ID
+			p, err := loadPaste(id)
+			if stored := err == nil; stored != tt.stored {
+				t.Fatalf("stored = %v (%v), want %v", stored, err, tt.stored)
+			}
+			if !tt.stored {
+				return
+			}
+			if p.Quarantined != tt.quarantine {
+				t.Errorf("quarantined = %v, want %v", p.Quarantined, tt.quarantine)
+			}
+			r := httptest.NewRequest(http.MethodGet, "/p/"+id+"/raw", nil)
+			rw := httptest.NewRecorder()
+			pasteHandler(rw, r)
+			if served := rw.Code == http.StatusOK; served == tt.quarantine {
+				t.Errorf("raw status = %d, quarantined %v", rw.Code, tt.quarantine)
+			}
+		})
+	}
+}
+
+func TestModerationRequest(t *testing.T) {
+	for _, sendBody := range []bool{false, true} {
+		useMemStore(t)
+		m := &fakeModeration{respond: answer(moderationAllow)}
+		useModeration(t, m)
+		config.ModerationSendBody = sendBody
+
+		postSave(t, url.Values{"title": {"what is sent"}, "body": {"secret stuff"}, "ttl": {"1h"}})
+		if len(m.calls) != 1 {
+			t.Fatalf("webhook called %d times, want once", len(m.calls))
+		}
+		req := m.calls[0]
+		body := "secret stuff\n"
+		if req.Title != "what is sent" || req.BodySHA256 != bodyHash([]byte(body)) || req.Size != len(body) || req.ClientIP == "" {
+			t.Errorf("request = %+v", req)
+		}
+		if got := req.Body != ""; got != sendBody {
+			t.Errorf("body sent = %v with MODERATION_SEND_BODY=%v", got, sendBody)
+		}
+	}
+}
+
+func TestModerationFailureUsesDefault(t *testing.T) {
+	failures := map[string]func(http.ResponseWriter){
+		"timeout": func(w http.ResponseWriter) {
+			time.Sleep(300 * time.Millisecond)
+			io.WriteString(w, `{"action":"allow"}`)
+		},
+		"error status": func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusInternalServerError)
+		},
+		"bad json": func(w http.ResponseWriter) {
+			io.WriteString(w, `allow`)
+		},
+		"unknown action": answer("maybe"),
+	}
+	defaults := map[string]int{
+		moderationAllow:      http.StatusCreated,
+		moderationDeny:       http.StatusUnprocessableEntity,
+		moderationQuarantine: http.StatusAccepted,
+	}
+	for name, respond := range failures {
+		for def, want := range defaults {
+			t.Run(name+" "+def, func(t *testing.T) {
+				useMemStore(t)
+				useModeration(t, &fakeModeration{respond: respond})
+				config.ModerationDefault = def
+
+				w := postSave(t, url.Values{"title": {"t"}, "body": {"hello"}, "ttl": {"1h"}})
+				if w.Code != want {
+					t.Errorf("status = %d, want %d: %s", w.Code, want, w.Body)
+				}
+			})
+		}
+	}
+}
+
+// Cheaper local checks run first, so content they reject is never sent
+// to the webhook.
+func TestModerationRunsAfterLocalChecks(t *testing.T) {
+	t.Run("blocklist", func(t *testing.T) {
+		useMemStore(t)
+		useBlocklist(t, `(?i)buy cheap`)
+		m := &fakeModeration{respond: answer(moderationAllow)}
+		useModeration(t, m)
+
+		w := postSave(t, url.Values{"title": {"offer"}, "body": {"BUY CHEAP pills"}, "ttl": {"1h"}})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+		}
+		if len(m.calls) != 0 {
+			t.Error("blocked paste was sent to the webhook")
+		}
+
+		w = postSave(t, url.Values{"title": {"fine"}, "body": {"nothing to see"}, "ttl": {"1h"}})
+		if w.Code != http.StatusCreated || len(m.calls) != 1 {
+			t.Errorf("status = %d with %d webhook calls, want %d with 1", w.Code, len(m.calls), http.StatusCreated)
+		}
+	})
+	t.Run("scanner", func(t *testing.T) {
+		useMemStore(t)
+		useScanner(t, &stubScanner{verdict: "Eicar-Signature"})
+		m := &fakeModeration{respond: answer(moderationAllow)}
+		useModeration(t, m)
+
+		w := postSave(t, url.Values{"title": {"t"}, "body": {"X5O!P%@AP"}, "ttl": {"1h"}})
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusUnprocessableEntity)
+		}
+		if len(m.calls) != 0 {
+			t.Error("rejected paste was sent to the webhook")
+		}
+	})
+}
+
+func TestModerationDenyRefundsQuota(t *testing.T) {
+	useMemStore(t)
+	useModeration(t, &fakeModeration{respond: answer(moderationDeny)})
+	config.QuotaCount = 1
+	quotaMu.Lock()
+	quotas = make(map[string]*quotaState)
+	quotaMu.Unlock()
+
+	for range 3 {
+		w := postSave(t, url.Values{"title": {"t"}, "body": {"hello"}, "ttl": {"1h"}})
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusUnprocessableEntity, w.Body)
+		}
+	}
+}
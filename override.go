@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// overrideMethods lists the methods a POST may ask to be treated as.
+var overrideMethods = map[string]bool{
+	http.MethodDelete: true,
+	http.MethodPatch:  true,
+}
+
+// maxOverrideForm bounds how much of a form is read looking for _method.
+const maxOverrideForm = 64 * 1024
+
+// methodOverride lets HTML forms, which can only POST, reach DELETE and
+// PATCH handlers through a _method field or an X-HTTP-Method-Override
+// header. Only POSTs are rewritten, and only to overrideMethods; the
+// rewritten method is still unsafe, so cross-origin checks still apply.
+// Paste creation is skipped because its body can be large and is parsed by
+// the create handlers themselves.
+func methodOverride(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		method := r.Header.Get("X-HTTP-Method-Override")
+		if method == "" && r.URL.Path != "/save" && !strings.HasPrefix(r.URL.Path, "/u/") {
+			r.Body = http.MaxBytesReader(w, r.Body, maxOverrideForm)
+			method = r.PostFormValue("_method")
+		}
+		method = strings.ToUpper(method)
+		if !overrideMethods[method] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r = r.WithContext(r.Context())
+		r.Method = method
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// seenMethod runs req through methodOverride and returns the method the
+// handler saw and the body it could still read.
+func seenMethod(req *http.Request) (method, body string) {
+	h := methodOverride(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	return method, body
+}
+
+func TestMethodOverride(t *testing.T) {
+	form := "application/x-www-form-urlencoded"
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		header      string
+		contentType string
+		body        string
+		want        string
+	}{
+		{"GET with header untouched", http.MethodGet, "/p/x", "DELETE", "", "", http.MethodGet},
+		{"GET with query untouched", http.MethodGet, "/p/x?_method=DELETE", "", "", "", http.MethodGet},
+		{"HEAD with header untouched", http.MethodHead, "/p/x", "DELETE", "", "", http.MethodHead},
+		{"PUT with header untouched", http.MethodPut, "/p/x", "DELETE", "", "", http.MethodPut},
+		{"POST header", http.MethodPost, "/p/x", "DELETE", "", "", http.MethodDelete},
+		{"POST header lowercase", http.MethodPost, "/p/x", "patch", "", "", http.MethodPatch},
+		{"POST field", http.MethodPost, "/p/x", "", form, "_method=DELETE&token=t", http.MethodDelete},
+		{"POST field not allowed", http.MethodPost, "/p/x", "", form, "_method=PUT", http.MethodPost},
+		{"POST to GET not allowed", http.MethodPost, "/p/x", "GET", "", "", http.MethodPost},
+		{"POST without override", http.MethodPost, "/p/x", "", form, "token=t", http.MethodPost},
+		{"save form not parsed", http.MethodPost, "/save", "", form, "_method=DELETE", http.MethodPost},
+		{"upload not parsed", http.MethodPost, "/u/grant", "", form, "_method=DELETE", http.MethodPost},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.header != "" {
+				r.Header.Set("X-HTTP-Method-Override", tt.header)
+			}
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			got, body := seenMethod(r)
+			if got != tt.want {
+				t.Errorf("method = %s, want %s", got, tt.want)
+			}
+			if (tt.path == "/save" || strings.HasPrefix(tt.path, "/u/")) && body != tt.body {
+				t.Errorf("create handler got body %q, want it unread, %q", body, tt.body)
+			}
+		})
+	}
+}
+
+// An overridden DELETE is still unsafe, so cross-origin protection applies.
+func TestMethodOverrideKeepsCrossOriginCheck(t *testing.T) {
+	reached := false
+	h := methodOverride(http.NewCrossOriginProtection().Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	})))
+	r := httptest.NewRequest(http.MethodPost, "/p/x", strings.NewReader("_method=DELETE"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Sec-Fetch-Site", "cross-site")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden || reached {
+		t.Errorf("cross-site override: status %d, handler reached %v", w.Code, reached)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Proof of work: a challenge is "<unix>.<bits>.<salt>.<sig>", signed with
+// formKey. The client finds any nonce (at most 64 bytes) such that
+// SHA-256("<challenge>:<nonce>") starts with at least <bits> zero bits.
+
+// powLoadWindow is how far back challenge requests count towards an IP's
+// escalated difficulty.
+const powLoadWindow = 10 * time.Minute
+
+type powWindow struct {
+	start time.Time
+	count int
+}
+
+var (
+	powMu   sync.Mutex
+	powLoad = make(map[string]*powWindow)
+	powUsed = make(map[string]time.Time) // solved challenge -> expiry
+)
+
+func signChallenge(payload string) string {
+	mac := hmac.New(sha256.New, formKey)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// sweepPow drops stale load windows and expired replay entries.
+func sweepPow(now time.Time) {
+	powMu.Lock()
+	defer powMu.Unlock()
+	for ip, win := range powLoad {
+		if now.Sub(win.start) > powLoadWindow {
+			delete(powLoad, ip)
+		}
+	}
+	for c, exp := range powUsed {
+		if now.After(exp) {
+			delete(powUsed, c)
+		}
+	}
+}
+
+// powDifficulty returns the difficulty for the next challenge issued to ip.
+// Every doubling of requests past ten per window adds a bit.
+func powDifficulty(ip string) int {
+	now := time.Now()
+	powMu.Lock()
+	defer powMu.Unlock()
+
+	win := powLoad[ip]
+	if win == nil || now.Sub(win.start) > powLoadWindow {
+		win = &powWindow{start: now}
+		powLoad[ip] = win
+	}
+	win.count++
+
+	return min(config.PowDifficulty+bits.Len(uint(win.count/10)), config.PowMaxDifficulty)
+}
+
+// newChallenge issues a signed challenge for the requesting client.
+func newChallenge(r *http.Request) (string, int, error) {
+	salt, err := randomHex(8)
+	if err != nil {
+		return "", 0, err
+	}
+	difficulty := powDifficulty(anonIP(clientIP(r)))
+	payload := fmt.Sprintf("%d.%d.%s", time.Now().Unix(), difficulty, salt)
+	return payload + "." + signChallenge(payload), difficulty, nil
+}
+
+// leadingZeroBits counts the zero bits at the start of sum.
+func leadingZeroBits(sum []byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// verifyPow checks a solved challenge and marks it used. The returned error
+// message is safe to show to the client.
+func verifyPow(challenge, nonce string) error {
+	payload, sig, ok := cutLast(challenge, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(signChallenge(payload))) {
+		metrics.Inc(`pow_rejected_total{reason="invalid"}`)
+		return fmt.Errorf("Invalid proof-of-work challenge")
+	}
+	parts := strings.Split(payload, ".")
+	if len(parts) != 3 {
+		metrics.Inc(`pow_rejected_total{reason="invalid"}`)
+		return fmt.Errorf("Invalid proof-of-work challenge")
+	}
+	issued, err1 := strconv.ParseInt(parts[0], 10, 64)
+	difficulty, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		metrics.Inc(`pow_rejected_total{reason="invalid"}`)
+		return fmt.Errorf("Invalid proof-of-work challenge")
+	}
+	expires := time.Unix(issued, 0).Add(config.PowExpiry)
+	if time.Now().After(expires) {
+		metrics.Inc(`pow_rejected_total{reason="expired"}`)
+		return fmt.Errorf("Proof-of-work challenge expired, please try again")
+	}
+	sum := sha256.Sum256([]byte(challenge + ":" + nonce))
+	if len(nonce) > 64 || leadingZeroBits(sum[:]) < difficulty {
+		metrics.Inc(`pow_rejected_total{reason="unsolved"}`)
+		return fmt.Errorf("Proof-of-work not solved")
+	}
+
+	powMu.Lock()
+	defer powMu.Unlock()
+	if _, used := powUsed[challenge]; used {
+		metrics.Inc(`pow_rejected_total{reason="replay"}`)
+		return fmt.Errorf("Proof-of-work challenge already used")
+	}
+	powUsed[challenge] = expires
+	return nil
+}
+
+// cutLast slices s around the last instance of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// challengeHandler hands out a fresh challenge as JSON for scripts and for
+// the form when its embedded challenge has gone stale.
+func challengeHandler(w http.ResponseWriter, r *http.Request) {
+	if config.PowDifficulty <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+	challenge, difficulty, err := newChallenge(r)
+	if err != nil {
+		requestLog(r.Context()).Error("issue challenge", "err", err)
+		internalError(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]any{
+		"challenge":  challenge,
+		"difficulty": difficulty,
+		"expires_in": int(config.PowExpiry.Seconds()),
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import "bytes"
+
+// preview describes the part of a large paste the view page shows, and the
+// limits that made it cut the paste short.
+type preview struct {
+	Lines      int
+	TotalLines int
+	MaxLines   int
+	MaxBytes   string
+}
+
+// previewBody cuts body after the last whole line within PREVIEW_LINES and
+// PREVIEW_BYTES, keeping at least one line. It returns nil when body fits
+// whole.
+func previewBody(body []byte) ([]byte, *preview) {
+	total := bytes.Count(body, []byte("\n"))
+	if len(body) > 0 && body[len(body)-1] != '\n' {
+		total++
+	}
+	tooLong := config.PreviewLines > 0 && total > config.PreviewLines
+	tooBig := config.PreviewBytes > 0 && len(body) > config.PreviewBytes
+	if !tooLong && !tooBig {
+		return nil, nil
+	}
+
+	end, lines := 0, 0
+	for end < len(body) && (config.PreviewLines <= 0 || lines < config.PreviewLines) {
+		next := len(body)
+		if i := bytes.IndexByte(body[end:], '\n'); i >= 0 {
+			next = end + i + 1
+		}
+		if config.PreviewBytes > 0 && next > config.PreviewBytes && lines > 0 {
+			break
+		}
+		end, lines = next, lines+1
+	}
+	p := &preview{Lines: lines, TotalLines: total, MaxLines: config.PreviewLines}
+	if config.PreviewBytes > 0 {
+		p.MaxBytes = formatSize(config.PreviewBytes)
+	}
+	return body[:end], p
+}
@@ -0,0 +1,24 @@
+package main
+
+// privacySalt keys the hashes that stand in for client addresses in
+// privacy mode. Without PRIVACY_SALT it is random per process and never
+// written anywhere, so the hashes can't be reversed by trying every
+// address, but quota counters keyed on them start over on a restart.
+var privacySalt = string(mustRandomBytes(32))
+
+// initPrivacySalt takes the salt from PRIVACY_SALT when it is set.
+func initPrivacySalt() {
+	if config.PrivacySalt != "" {
+		privacySalt = config.PrivacySalt
+	}
+}
+
+// anonIP returns what tinypaste keeps in place of a client address: the
+// address itself, or with PRIVACY_MODE a salted hash of it. Rate limits
+// keyed on the hash still work.
+func anonIP(ip string) string {
+	if !config.PrivacyMode {
+		return ip
+	}
+	return "anon-" + hashIP(privacySalt, ip)[:16]
+}
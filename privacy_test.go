@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// restart gives anonIP the salt a freshly started process would have.
+func restart(t *testing.T) {
+	t.Helper()
+	privacySalt = string(mustRandomBytes(32))
+	initPrivacySalt()
+}
+
+func TestPrivacySaltSurvivesRestart(t *testing.T) {
+	useConfig(t)
+	old := privacySalt
+	t.Cleanup(func() { privacySalt = old })
+	config.PrivacyMode = true
+	ip := "203.0.113.7"
+
+	restart(t)
+	before := anonIP(ip)
+	if strings.Contains(before, ip) {
+		t.Fatalf("anonIP(%q) = %q keeps the address", ip, before)
+	}
+	restart(t)
+	if after := anonIP(ip); after == before {
+		t.Errorf("without PRIVACY_SALT the hash %q outlived a restart", after)
+	}
+
+	config.PrivacySalt = "a long random string"
+	restart(t)
+	before = anonIP(ip)
+	restart(t)
+	if after := anonIP(ip); after != before {
+		t.Errorf("with PRIVACY_SALT the hash changed on restart: %q, then %q", before, after)
+	}
+}
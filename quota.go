@@ -0,0 +1,263 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// quotaState is the per-client pair of daily buckets.
+type quotaState struct {
+	Count tokenBucket `json:"count"`
+	Bytes tokenBucket `json:"bytes"`
+}
+
+var (
+	quotaMu    sync.Mutex
+	quotas     = make(map[string]*quotaState)
+	quotaDirty bool
+)
+
+// quotaLimits turns the configured daily allowances into buckets that
+// refill evenly over 24 hours, approximating a rolling window.
+func quotaLimits() (count, bytes rateLimit) {
+	day := (24 * time.Hour).Seconds()
+	count = rateLimit{burst: float64(config.QuotaCount), perSecond: float64(config.QuotaCount) / day}
+	bytes = rateLimit{burst: float64(config.QuotaBytes), perSecond: float64(config.QuotaBytes) / day}
+	return count, bytes
+}
+
+// quotaKey identifies who a creation counts against: the API token if one
+// was presented, otherwise the client address as clientKey has it.
+func quotaKey(r *http.Request) string {
+	if token := apiToken(r); token != "" {
+		sum := sha256.Sum256([]byte(token))
+		return "token:" + hex.EncodeToString(sum[:8])
+	}
+	return clientKey(r)
+}
+
+// clientKey identifies the client address, with IPv6 bucketed by /64 since
+// one host usually holds a whole /64.
+func clientKey(r *http.Request) string {
+	ip := clientIP(r)
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "ip:" + anonIP(ip)
+	}
+	addr = addr.Unmap()
+	if addr.Is6() {
+		return "ip:" + anonIP(netip.PrefixFrom(addr, 64).Masked().String())
+	}
+	return "ip:" + anonIP(addr.String())
+}
+
+// takeQuota charges one paste of size bytes to the client. When the quota
+// is used up it returns how long until the paste would fit.
+func takeQuota(r *http.Request, size int) (time.Duration, bool) {
+	if config.QuotaCount <= 0 && config.QuotaBytes <= 0 {
+		return 0, true
+	}
+	countLimit, bytesLimit := quotaLimits()
+	key := quotaKey(r)
+	now := time.Now()
+
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	q := quotas[key]
+	if q == nil {
+		q = &quotaState{}
+	}
+
+	var wait time.Duration
+	if config.QuotaCount > 0 {
+		wait = max(wait, countLimit.wait(&q.Count, now, 1))
+	}
+	if config.QuotaBytes > 0 {
+		wait = max(wait, bytesLimit.wait(&q.Bytes, now, float64(size)))
+	}
+	if wait > 0 {
+		metrics.Inc("quota_rejected_total")
+		return wait, false
+	}
+	if config.QuotaCount > 0 {
+		countLimit.take(&q.Count, 1)
+	}
+	if config.QuotaBytes > 0 {
+		bytesLimit.take(&q.Bytes, float64(size))
+	}
+	quotas[key] = q
+	quotaDirty = true
+	return 0, true
+}
+
+// refundQuota gives back what takeQuota charged for a paste that was then
+// not created, so rejected or failed saves don't use up the quota.
+func refundQuota(r *http.Request, size int) {
+	if config.QuotaCount <= 0 && config.QuotaBytes <= 0 {
+		return
+	}
+	countLimit, bytesLimit := quotaLimits()
+	key := quotaKey(r)
+
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	q := quotas[key]
+	if q == nil {
+		return
+	}
+	if config.QuotaCount > 0 {
+		countLimit.give(&q.Count, 1)
+	}
+	if config.QuotaBytes > 0 {
+		bytesLimit.give(&q.Bytes, float64(size))
+	}
+	quotaDirty = true
+}
+
+// quotaFits reports whether a paste of size bytes can ever fit in the
+// daily byte quota, however long the client waits.
+func quotaFits(size int) bool {
+	return config.QuotaBytes <= 0 || size <= config.QuotaBytes
+}
+
+// loadQuotas restores quota state saved by a previous run.
+func loadQuotas() {
+	data, err := os.ReadFile(config.QuotaFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Error("read quota file", "err", err)
+		}
+		return
+	}
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	if err := json.Unmarshal(data, &quotas); err != nil {
+		slog.Error("parse quota file", "err", err)
+		quotas = make(map[string]*quotaState)
+	}
+}
+
+// sweepQuotas drops clients that haven't created a paste for STATE_MAX_AGE,
+// even if their buckets haven't refilled yet.
+func sweepQuotas(now time.Time) {
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	for key, q := range quotas {
+		if now.Sub(q.Count.Last) > config.StateMaxAge && now.Sub(q.Bytes.Last) > config.StateMaxAge {
+			delete(quotas, key)
+			quotaDirty = true
+		}
+	}
+}
+
+// saveQuotas drops clients whose buckets have refilled and writes the rest
+// to disk, replacing the old file atomically.
+func saveQuotas() error {
+	countLimit, bytesLimit := quotaLimits()
+	now := time.Now()
+
+	quotaMu.Lock()
+	for key, q := range quotas {
+		if countLimit.full(&q.Count, now) && bytesLimit.full(&q.Bytes, now) {
+			delete(quotas, key)
+			quotaDirty = true
+		}
+	}
+	if !quotaDirty {
+		quotaMu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(quotas)
+	quotaDirty = false
+	quotaMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	os.MkdirAll(filepath.Dir(config.QuotaFile), 0755)
+	tmp := config.QuotaFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, config.QuotaFile)
+}
+
+// quotaMessage explains when a client can create pastes again.
+func quotaMessage(wait time.Duration) string {
+	at := time.Now().Add(wait).UTC()
+	return fmt.Sprintf("Daily paste quota reached. You can create another paste in %s (at %s).",
+		max(wait, time.Minute).Round(time.Minute), at.Format("15:04 MST"))
+}
+
+// withRateLimitHeaders adds the rate limit headers to every response of an
+// API route, errors and throttled requests included. They are set as the
+// response starts, so they count a paste the handler has just charged.
+func withRateLimitHeaders(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(&rateLimitWriter{ResponseWriter: w, r: r}, r)
+	}
+}
+
+// rateLimitWriter calls setRateLimitHeaders before the response's headers
+// are sent.
+type rateLimitWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	wroteHeader bool
+}
+
+func (w *rateLimitWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		setRateLimitHeaders(w.ResponseWriter, w.r)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *rateLimitWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *rateLimitWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// setRateLimitHeaders tells the client how many pastes its quota has left,
+// in X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset
+// (seconds until the quota is whole again). Only the paste count is
+// reported; it does nothing without QUOTA_COUNT.
+func setRateLimitHeaders(w http.ResponseWriter, r *http.Request) {
+	if config.QuotaCount <= 0 {
+		return
+	}
+	countLimit, _ := quotaLimits()
+	key := quotaKey(r)
+
+	quotaMu.Lock()
+	var bucket tokenBucket
+	if q := quotas[key]; q != nil {
+		bucket = q.Count
+	}
+	quotaMu.Unlock()
+
+	reset := countLimit.untilFull(&bucket, time.Now())
+	h := w.Header()
+	h.Set("X-RateLimit-Limit", strconv.Itoa(config.QuotaCount))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(int(bucket.Tokens)))
+	h.Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+}
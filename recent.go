@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recentCacheTTL bounds how often /recent walks every bucket.
+const recentCacheTTL = 30 * time.Second
+
+// recentEntry is one row of the /recent page.
+type recentEntry struct {
+	ID       string
+	Title    string
+	Language string
+	Age      string
+
+	// Comments counts the paste's comments when comments are enabled
+	Comments int
+
+	created time.Time
+	expires time.Time
+}
+
+var (
+	recentMu    sync.Mutex
+	recentCache []recentEntry
+	recentAt    time.Time
+)
+
+// recentPastes returns the newest unexpired public pastes, newest first,
+// with ages in lang.
+func recentPastes(lang string) []recentEntry {
+	recentMu.Lock()
+	defer recentMu.Unlock()
+
+	if time.Since(recentAt) > recentCacheTTL {
+		recentCache = scanRecent()
+		recentAt = time.Now()
+	}
+
+	entries := make([]recentEntry, len(recentCache))
+	for i, e := range recentCache {
+		e.Age = formatAge(lang, time.Since(e.created))
+		if config.CommentsEnabled {
+			e.Comments = commentCount(e.ID)
+		}
+		entries[i] = e
+	}
+	return entries
+}
+
+// scanRecent walks all buckets reading only the header line of each paste.
+func scanRecent() []recentEntry {
+	now := time.Now()
+	var entries []recentEntry
+	walkBuckets(0, 256, func(f pasteFile) {
+		if f.expired(now) {
+			return
+		}
+		meta, err := readHeader(f.Path)
+		if err != nil || !meta.Public || meta.Quarantine {
+			return
+		}
+		entries = append(entries, recentEntry{
+			ID:       f.ID,
+			Title:    meta.Title,
+			Language: meta.Language,
+			created:  f.Created,
+		})
+	})
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].created.After(entries[j].created) })
+	if len(entries) > config.RecentLimit {
+		entries = entries[:config.RecentLimit]
+	}
+	return entries
+}
+
+// readHeader reads and decodes just the metadata line of a paste file.
+func readHeader(path string) (pasteMeta, error) {
+	f, err := store.Open(path)
+	if err != nil {
+		return pasteMeta{}, err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil {
+		return pasteMeta{}, err
+	}
+	return parseHeader(strings.TrimSuffix(line, "\n"))
+}
+
+// formatAge renders a duration as a short "5m ago" style string in lang.
+func formatAge(lang string, d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return translate(lang, "age.now")
+	case d < time.Hour:
+		return translate(lang, "age.minutes", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return translate(lang, "age.hours", int(d.Hours()))
+	}
+	return translate(lang, "age.days", int(d.Hours()/24))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"log/slog"
+	"net/http"
+)
+
+// requestIDHeader carries the request ID, both ways.
+const requestIDHeader = "X-Request-Id"
+
+type requestIDKey struct{}
+
+// withRequestID gives each request an ID, tying a user's error report to
+// the log lines it caused. Behind a trusted proxy the proxy's ID is kept,
+// so its logs and ours line up. The ID is echoed in the response.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !config.TrustProxy || !validRequestID(id) {
+			id = rand.Text()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// validRequestID accepts up to 64 letters, digits, dots, dashes and
+// underscores, so an incoming ID can't forge log fields or headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the ID of the request ctx belongs to, or "".
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestLog returns the logger for the request ctx belongs to, which
+// tags every line with the request ID.
+func requestLog(ctx context.Context) *slog.Logger {
+	if id := requestID(ctx); id != "" {
+		return slog.Default().With("request_id", id)
+	}
+	return slog.Default()
+}
+
+// internalError answers 500 with the request ID, for the user to quote
+// when reporting the error. The cause goes to the log, not the response.
+func internalError(w http.ResponseWriter, r *http.Request) {
+	msg := "Internal server error"
+	if id := requestID(r.Context()); id != "" {
+		msg += "\nRequest ID: " + id + " (quote this ID when reporting)"
+	}
+	http.Error(w, msg, http.StatusInternalServerError)
+}
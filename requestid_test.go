@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDPropagation(t *testing.T) {
+	tests := []struct {
+		name  string
+		trust bool
+		id    string
+		kept  bool
+	}{
+		{"trusted proxy", true, "proxy-1234.abc_DEF", true},
+		{"untrusted client", false, "proxy-1234", false},
+		{"no header", true, "", false},
+		{"trusted but forged fields", true, "abc request_id=x", false},
+		{"trusted but newline", true, "abc\nX-Evil: 1", false},
+		{"trusted but too long", true, strings.Repeat("a", 65), false},
+	}
+	seen := make(map[string]bool)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t)
+			config.TrustProxy = tt.trust
+			var inCtx string
+			h := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				inCtx = requestID(r.Context())
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.id != "" {
+				r.Header[requestIDHeader] = []string{tt.id}
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			echoed := w.Header().Get(requestIDHeader)
+			if echoed != inCtx {
+				t.Errorf("response has %q, context %q", echoed, inCtx)
+			}
+			if tt.kept {
+				if inCtx != tt.id {
+					t.Errorf("ID = %q, want the proxy's %q", inCtx, tt.id)
+				}
+				return
+			}
+			if inCtx == tt.id || !validRequestID(inCtx) || seen[inCtx] {
+				t.Errorf("ID = %q, want a fresh one", inCtx)
+			}
+			seen[inCtx] = true
+		})
+	}
+}
+
+// A failing page logs the error with the request ID, and the 500 page
+// shows the same ID for the user to quote.
+func TestRequestIDInErrorLog(t *testing.T) {
+	useConfig(t)
+	logs := captureLog(t)
+	config.TrustProxy = true
+	h := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		renderTemplate(w, r, "nonexistent", nil)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(requestIDHeader, "report-me-42")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if !strings.Contains(w.Body.String(), "Request ID: report-me-42") {
+		t.Errorf("500 page %q doesn't show the request ID", w.Body)
+	}
+	var logged bool
+	for line := range strings.Lines(logs.String()) {
+		if strings.Contains(line, "level=ERROR") && strings.Contains(line, "request_id=report-me-42") {
+			logged = true
+		}
+	}
+	if !logged {
+		t.Errorf("no error logged with the request ID:\n%s", logs)
+	}
+}
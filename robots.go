@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// sitemapPages are the static pages, which are always worth indexing.
+var sitemapPages = []string{"/", "/about", "/legal"}
+
+// routeClass says what a path serves. Crawler policy is decided per class
+// so robots.txt, X-Robots-Tag and the pages themselves agree.
+type routeClass int
+
+const (
+	routeOther routeClass = iota
+	routePage
+	routePaste
+)
+
+func classifyRoute(path string) routeClass {
+	switch {
+	case slices.Contains(sitemapPages, path):
+		return routePage
+	case strings.HasPrefix(path, pastePath("", "")):
+		return routePaste
+	}
+	return routeOther
+}
+
+// indexable reports whether search engines may index path. Pastes are
+// only indexed with ALLOW_INDEXING or when p, the paste served, is public.
+func indexable(path string, p *Paste) bool {
+	switch classifyRoute(path) {
+	case routePage:
+		return true
+	case routePaste:
+		return config.AllowIndexing || (p != nil && p.Public)
+	}
+	return false
+}
+
+// robotsTag marks the response for path noindex unless it's indexable.
+func robotsTag(w http.ResponseWriter, path string, p *Paste) {
+	if indexable(path, p) {
+		w.Header().Del("X-Robots-Tag")
+		return
+	}
+	w.Header().Set("X-Robots-Tag", "noindex")
+}
+
+// defaultRobots lets crawlers see the static pages, and pastes if
+// ALLOW_INDEXING is set, and nothing else.
+func defaultRobots() string {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	for _, page := range sitemapPages {
+		fmt.Fprintf(&b, "Allow: %s$\n", sitePath(page))
+	}
+	if config.AllowIndexing {
+		fmt.Fprintf(&b, "Allow: %s\n", sitePath(pastePath("", "")))
+	}
+	b.WriteString("Disallow: " + sitePath("/") + "\n")
+	return b.String()
+}
+
+// serveRobots answers /robots.txt from ROBOTS_FILE, or with defaultRobots.
+func serveRobots(w http.ResponseWriter, r *http.Request) {
+	robots := defaultRobots()
+	if config.RobotsFile != "" {
+		data, err := os.ReadFile(config.RobotsFile)
+		if err != nil {
+			requestLog(r.Context()).Error("read robots file", "err", err)
+			internalError(w, r)
+			return
+		}
+		robots = string(data)
+	} else if config.SitemapEnabled {
+		robots += "\nSitemap: " + absoluteURL(r, "/sitemap.xml") + "\n"
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, robots)
+}
+
+// serveSitemap lists sitemapPages when SITEMAP_ENABLED is set.
+func serveSitemap(w http.ResponseWriter, r *http.Request) {
+	if !config.SitemapEnabled {
+		http.NotFound(w, r)
+		return
+	}
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n")
+	for _, page := range sitemapPages {
+		b.WriteString("  <url><loc>")
+		xml.EscapeText(&b, []byte(absoluteURL(r, page)))
+		b.WriteString("</loc></url>\n")
+	}
+	b.WriteString("</urlset>\n")
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	fmt.Fprint(w, b.String())
+}
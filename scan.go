@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Scanner inspects paste content before it is stored. Scan returns a
+// non-empty verdict when the content must be rejected, and an error when
+// the scanner could not decide. Operators can compile in their own scanner
+// by assigning contentScanner from an init function.
+type Scanner interface {
+	Scan(ctx context.Context, content []byte) (verdict string, err error)
+}
+
+// contentScanner is nil when no scanning is configured.
+var contentScanner Scanner
+
+// newScanner builds the built-in scanner for a SCANNER_URL: http(s) URLs
+// get an HTTP POST, clamd://host:port and clamd:///path/to/socket speak
+// clamd's INSTREAM protocol.
+func newScanner(rawURL string) (Scanner, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return httpScanner{url: rawURL}, nil
+	case "clamd":
+		if u.Host != "" {
+			return clamdScanner{network: "tcp", addr: u.Host}, nil
+		}
+		return clamdScanner{network: "unix", addr: u.Path}, nil
+	}
+	return nil, fmt.Errorf("unsupported scanner URL %q", rawURL)
+}
+
+// httpScanner POSTs the content to an endpoint. A 2xx response means clean,
+// 409 or 422 means rejected with the response body as the verdict.
+type httpScanner struct {
+	url string
+}
+
+func (s httpScanner) Scan(ctx context.Context, content []byte) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(content))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return "", nil
+	case resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusUnprocessableEntity:
+		reason, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if verdict := strings.TrimSpace(string(reason)); verdict != "" {
+			return verdict, nil
+		}
+		return "rejected", nil
+	}
+	return "", fmt.Errorf("scanner returned %d", resp.StatusCode)
+}
+
+// clamdScanner streams the content to clamd with INSTREAM.
+type clamdScanner struct {
+	network, addr string
+}
+
+func (s clamdScanner) Scan(ctx context.Context, content []byte) (string, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, s.network, s.addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", err
+	}
+	for rest := content; len(rest) > 0; {
+		chunk := rest[:min(len(rest), 64*1024)]
+		rest = rest[len(chunk):]
+		if err := binary.Write(conn, binary.BigEndian, uint32(len(chunk))); err != nil {
+			return "", err
+		}
+		if _, err := conn.Write(chunk); err != nil {
+			return "", err
+		}
+	}
+	if err := binary.Write(conn, binary.BigEndian, uint32(0)); err != nil {
+		return "", err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	reply = strings.TrimSuffix(reply, "\x00")
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	}
+	return "", fmt.Errorf("clamd: %s", reply)
+}
+
+// scanContent runs the configured scanner with the configured timeout. A
+// scanner failure only passes the content when the scanner fails open.
+func scanContent(ctx context.Context, content []byte) (verdict string, err error) {
+	ctx, cancel := context.WithTimeout(ctx, config.ScannerTimeout)
+	defer cancel()
+
+	verdict, err = contentScanner.Scan(ctx, content)
+	if err != nil {
+		if config.ScannerFailOpen {
+			requestLog(ctx).Warn("content scanner failed, accepting paste", "err", err)
+			return "", nil
+		}
+		requestLog(ctx).Error("content scanner failed", "err", err)
+		return "", err
+	}
+	if verdict != "" {
+		metrics.Inc("scanner_rejected_total")
+	}
+	return verdict, nil
+}
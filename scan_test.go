@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubScanner records what it was asked to scan and answers as told.
+type stubScanner struct {
+	mu      sync.Mutex
+	got     [][]byte
+	verdict string
+	err     error
+	block   bool // wait for the context to end, as a hung scanner would
+}
+
+func (s *stubScanner) Scan(ctx context.Context, content []byte) (string, error) {
+	s.mu.Lock()
+	s.got = append(s.got, append([]byte(nil), content...))
+	s.mu.Unlock()
+	if s.block {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	return s.verdict, s.err
+}
+
+func useScanner(t *testing.T, s Scanner) {
+	t.Helper()
+	old := contentScanner
+	contentScanner = s
+	t.Cleanup(func() { contentScanner = old })
+}
+
+func TestScannerSeesStoredBytes(t *testing.T) {
+	useMemStore(t)
+	s := &stubScanner{}
+	useScanner(t, s)
+
+	w := postSave(t, url.Values{"title": {"scanned"}, "body": {"\ufeffline one\r\nline two\r\n\r\n"}, "ttl": {"1h"}})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	want := "line one\nline two\n"
+	if len(s.got) != 1 || string(s.got[0]) != want {
+		t.Fatalf("scanner got %q, want exactly [%q]", s.got, want)
+	}
+
+	var created struct{ ID string }
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	p, err := loadPaste(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p.Body) != want {
+		t.Errorf("stored %q, want what was scanned, %q", p.Body, want)
+	}
+}
+
+func TestScannerVerdicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		scanner  *stubScanner
+		failOpen bool
+		want     int
+	}{
+		{"clean", &stubScanner{}, false, http.StatusCreated},
+		{"infected", &stubScanner{verdict: "Eicar-Signature"}, false, http.StatusUnprocessableEntity},
+		{"infected fail open", &stubScanner{verdict: "Eicar-Signature"}, true, http.StatusUnprocessableEntity},
+		{"down fail closed", &stubScanner{err: errors.New("connection refused")}, false, http.StatusServiceUnavailable},
+		{"down fail open", &stubScanner{err: errors.New("connection refused")}, true, http.StatusCreated},
+		{"timeout fail closed", &stubScanner{block: true}, false, http.StatusServiceUnavailable},
+		{"timeout fail open", &stubScanner{block: true}, true, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemStore(t)
+			useScanner(t, tt.scanner)
+			config.ScannerFailOpen = tt.failOpen
+			config.ScannerTimeout = 50 * time.Millisecond
+
+			start := time.Now()
+			w := postSave(t, url.Values{"title": {"t"}, "body": {"X5O!P%@AP"}, "ttl": {"1h"}})
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("save took %v, want it cut off by the scanner timeout", elapsed)
+			}
+			if len(tt.scanner.got) != 1 {
+				t.Errorf("scanner called %d times, want once", len(tt.scanner.got))
+			}
+		})
+	}
+}
+
+func TestScannerSkipsEncrypted(t *testing.T) {
+	useMemStore(t)
+	s := &stubScanner{verdict: "should not be asked"}
+	useScanner(t, s)
+
+	w := postSave(t, url.Values{"body": {encryptedTestBody}, "cipher": {"age"}, "ttl": {"1h"}})
+	if w.Code != http.StatusCreated {
+		t.Errorf("status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	if len(s.got) != 0 {
+		t.Error("scanner asked about an encrypted paste")
+	}
+}
+
+// encryptedTestBody is enough of an ASCII-armoured age file to pass
+// validateEncrypted.
+const encryptedTestBody = "-----BEGIN AGE ENCRYPTED FILE-----\nYWdlLWVuY3J5cHRpb24ub3JnL3YxCi0+IFgyNTUxOSBhYmMKZGVmCi0tLSBnaGkK\n-----END AGE ENCRYPTED FILE-----\n"
+
+func TestHTTPScanner(t *testing.T) {
+	var got []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = io.ReadAll(r.Body)
+		switch string(got) {
+		case "clean":
+		case "bad":
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			io.WriteString(w, "Malware.Test\n")
+		case "bare":
+			w.WriteHeader(http.StatusConflict)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	s, err := newScanner(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		content, verdict string
+		wantErr          bool
+	}{
+		{"clean", "", false},
+		{"bad", "Malware.Test", false},
+		{"bare", "rejected", false},
+		{"broken", "", true},
+	} {
+		verdict, err := s.Scan(context.Background(), []byte(tt.content))
+		if string(got) != tt.content {
+			t.Errorf("scanner got %q, want %q", got, tt.content)
+		}
+		if verdict != tt.verdict || (err != nil) != tt.wantErr {
+			t.Errorf("Scan(%q) = %q, %v; want %q, error %v", tt.content, verdict, err, tt.verdict, tt.wantErr)
+		}
+	}
+}
+
+// fakeClamd answers one INSTREAM session on ln with reply, after checking
+// the chunks add up to want.
+func fakeClamd(t *testing.T, ln net.Listener, want []byte, reply string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	in := bufio.NewReader(conn)
+	cmd, err := in.ReadString(0)
+	if err != nil || cmd != "zINSTREAM\x00" {
+		t.Errorf("command = %q, %v", cmd, err)
+		return
+	}
+	var got []byte
+	for {
+		var n uint32
+		if err := binary.Read(in, binary.BigEndian, &n); err != nil {
+			t.Error(err)
+			return
+		}
+		if n == 0 {
+			break
+		}
+		chunk := make([]byte, n)
+		if _, err := io.ReadFull(in, chunk); err != nil {
+			t.Error(err)
+			return
+		}
+		got = append(got, chunk...)
+	}
+	if string(got) != string(want) {
+		t.Errorf("clamd got %d bytes, want %d", len(got), len(want))
+	}
+	io.WriteString(conn, reply+"\x00")
+}
+
+func TestClamdScanner(t *testing.T) {
+	// Bigger than one INSTREAM chunk
+	content := make([]byte, 150*1024)
+	for i := range content {
+		content[i] = byte('a' + i%26)
+	}
+	for _, tt := range []struct {
+		reply, verdict string
+		wantErr        bool
+	}{
+		{"stream: OK", "", false},
+		{"stream: Eicar-Signature FOUND", "Eicar-Signature", false},
+		{"INSTREAM size limit exceeded. ERROR", "", true},
+	} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			fakeClamd(t, ln, content, tt.reply)
+		}()
+
+		s, err := newScanner("clamd://" + ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		verdict, err := s.Scan(context.Background(), content)
+		<-done
+		ln.Close()
+		if verdict != tt.verdict || (err != nil) != tt.wantErr {
+			t.Errorf("reply %q: Scan = %q, %v; want %q, error %v", tt.reply, verdict, err, tt.verdict, tt.wantErr)
+		}
+	}
+}
+
+func TestClamdScannerTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		// Accept and never answer
+		if conn, err := ln.Accept(); err == nil {
+			defer conn.Close()
+			io.Copy(io.Discard, conn)
+		}
+	}()
+
+	s, err := newScanner("clamd://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := s.Scan(ctx, []byte("hello")); err == nil {
+		t.Error("Scan succeeded against a clamd that never answers")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Scan took %v, want it cut off by the deadline", elapsed)
+	}
+}
+
+func TestNewScannerRejectsUnknownScheme(t *testing.T) {
+	if _, err := newScanner("ftp://scanner.example.com"); err == nil {
+		t.Error("newScanner accepted an ftp URL")
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// closedWindow is a daily span of time, on some weekdays, in which paste
+// creation is turned off. Spans past midnight run into the next day.
+type closedWindow struct {
+	days       [7]bool // indexed by time.Weekday
+	start, end int     // minutes after midnight; end may be 24*60
+}
+
+// contains reports whether the local time t falls in w.
+func (w closedWindow) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if w.start < w.end {
+		return w.days[day] && m >= w.start && m < w.end
+	}
+	return (w.days[day] && m >= w.start) || (w.days[(day+6)%7] && m < w.end)
+}
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// parseSchedule parses a comma-separated list of windows such as
+// "22:00-07:00" or "sat-sun 00:00-24:00". Each is an optional list of
+// weekdays or weekday ranges, joined by +, then a time range.
+func parseSchedule(name, list string) []closedWindow {
+	var windows []closedWindow
+	for _, s := range parseList(strings.ToLower(list)) {
+		days, span, ok := strings.Cut(s, " ")
+		if !ok {
+			days, span = "mon-sun", s
+		}
+		var w closedWindow
+		if err := parseDays(&w, days); err != nil {
+			log.Fatalf("Invalid %s entry %q: %v", name, s, err)
+		}
+		from, to, ok := strings.Cut(strings.TrimSpace(span), "-")
+		var err1, err2 error
+		w.start, err1 = parseClock(from)
+		w.end, err2 = parseClock(to)
+		if !ok || err1 != nil || err2 != nil || w.start == w.end || w.start == 24*60 {
+			log.Fatalf("Invalid %s entry %q: want a time range such as 22:00-07:00", name, s)
+		}
+		windows = append(windows, w)
+	}
+	return windows
+}
+
+// parseDays sets the weekdays named by days, e.g. "mon-fri" or "sat+sun".
+func parseDays(w *closedWindow, days string) error {
+	for _, part := range strings.Split(days, "+") {
+		from, to, isRange := strings.Cut(part, "-")
+		if !isRange {
+			to = from
+		}
+		i, j := slices.Index(weekdays, from), slices.Index(weekdays, to)
+		if i < 0 || j < 0 {
+			return fmt.Errorf("unknown weekday in %q, want mon, tue, ... sun", part)
+		}
+		for d := i; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == j {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseClock parses HH:MM, allowing 24:00 for the end of the day.
+func parseClock(s string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(strings.TrimSpace(s), "%d:%d", &h, &m); err != nil {
+		return 0, err
+	}
+	if h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return h*60 + m, nil
+}
+
+// creationClosed reports whether now falls in a CREATION_CLOSED window,
+// and if so when creation opens again.
+func creationClosed(now time.Time) (closed bool, opens time.Time) {
+	t := now.In(config.CreationTimezone).Truncate(time.Minute)
+	for range 7 * 24 * 60 {
+		inside := false
+		for _, w := range config.CreationClosed {
+			if w.contains(t) {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			return closed, t
+		}
+		closed = true
+		t = t.Add(time.Minute)
+	}
+	// Closed around the clock; look again in a day
+	return true, now.Add(24 * time.Hour)
+}
+
+// creationOpen turns away paste creation in a CREATION_CLOSED window with
+// a 503 saying when to come back. Reads are unaffected.
+func creationOpen(w http.ResponseWriter, r *http.Request) bool {
+	if len(config.CreationClosed) == 0 {
+		return true
+	}
+	now := time.Now()
+	closed, opens := creationClosed(now)
+	if !closed {
+		return true
+	}
+	metrics.Inc("creation_closed_total")
+	throttled(w, r, http.StatusServiceUnavailable, opens.Sub(now),
+		fmt.Sprintf("Creating pastes is currently disabled. It opens again at %s.", opens.Format("15:04 MST")))
+	return false
+}
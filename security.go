@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// defaultCSP allows only same-origin resources, plus inline styles and
+// scripts that carry the request's nonce. {nonce} is replaced per request.
+// The proof-of-work solver runs in a worker built from a blob.
+const defaultCSP = "default-src 'self'; script-src 'self' 'nonce-{nonce}'; style-src 'self' 'nonce-{nonce}'; " +
+	"worker-src blob:; img-src 'self' data:; object-src 'none'; base-uri 'none'; form-action 'self'; frame-ancestors 'none'"
+
+type nonceKey struct{}
+
+// cspNonce returns the nonce securityHeaders generated for r, or "" if it
+// didn't.
+func cspNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(nonceKey{}).(string)
+	return nonce
+}
+
+// nonceMarker stands in for the nonce in rendered templates, which are
+// shared between requests; renderStatus swaps in the request's nonce. It is
+// random so that paste content can't guess it.
+var nonceMarker = "nonce-" + hex.EncodeToString(mustRandomBytes(16))
+
+// securityHeaders sets the configured security headers on every response.
+// A header configured as "off" is left out; X-Content-Type-Options is
+// always sent. Each request gets a fresh nonce for the CSP.
+func securityHeaders(next http.Handler) http.Handler {
+	headers := map[string]string{
+		"Content-Security-Policy": config.CSP,
+		"X-Frame-Options":         config.FrameOptions,
+		"Referrer-Policy":         config.ReferrerPolicy,
+	}
+	for name, value := range headers {
+		if value == "off" {
+			delete(headers, name)
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := randomBytes(16)
+		if err != nil {
+			requestLog(r.Context()).Error("generate csp nonce", "err", err)
+			internalError(w, r)
+			return
+		}
+		nonce := base64.StdEncoding.EncodeToString(b)
+
+		h := w.Header()
+		for name, value := range headers {
+			h.Set(name, strings.ReplaceAll(value, "{nonce}", nonce))
+		}
+		h.Set("X-Content-Type-Options", "nosniff")
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), nonceKey{}, nonce)))
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// formTokenMaxAge bounds how long a served form stays submittable.
+const formTokenMaxAge = 24 * time.Hour
+
+// formKey signs form timestamps. Without FORM_SECRET it is random per
+// process, so forms served before a restart stop validating.
+var formKey []byte
+
+func initFormKey() error {
+	if config.FormSecret != "" {
+		formKey = []byte(config.FormSecret)
+		return nil
+	}
+	var err error
+	formKey, err = randomBytes(32)
+	return err
+}
+
+func signFormTime(ts string) string {
+	mac := hmac.New(sha256.New, formKey)
+	mac.Write([]byte(ts))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// formToken returns the signed "served at" timestamp embedded in the form.
+func formToken() string {
+	ts := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	return ts + "." + signFormTime(ts)
+}
+
+// formAge verifies a token from formToken and reports how long ago the
+// form was served.
+func formAge(token string) (time.Duration, bool) {
+	ts, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(signFormTime(ts))) {
+		return 0, false
+	}
+	ms, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Since(time.UnixMilli(ms)), true
+}
+
+// apiToken returns the configured API token the request carries as a
+// bearer token, or "" if none matches.
+func apiToken(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return ""
+	}
+	for _, t := range config.APITokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return t
+		}
+	}
+	return ""
+}
+
+// apiClient reports whether the request carries one of the configured API
+// tokens. Such clients skip the form spam checks.
+func apiClient(r *http.Request) bool {
+	return apiToken(r) != ""
+}
+
+// spamVerdict is the outcome of the form spam checks.
+type spamVerdict int
+
+const (
+	spamOK spamVerdict = iota
+	// spamDiscard pretends to succeed so the bot learns nothing
+	spamDiscard
+	spamReject
+)
+
+// checkFormSpam runs the honeypot and minimum fill time checks. The message
+// is only meaningful for spamReject.
+func checkFormSpam(r *http.Request) (spamVerdict, string) {
+	if apiClient(r) {
+		return spamOK, ""
+	}
+	if r.FormValue("website") != "" {
+		metrics.Inc(`spam_rejected_total{reason="honeypot"}`)
+		return spamDiscard, ""
+	}
+	if config.FormMinTime <= 0 {
+		return spamOK, ""
+	}
+	age, ok := formAge(r.FormValue("form_token"))
+	if !ok || age > formTokenMaxAge {
+		metrics.Inc(`spam_rejected_total{reason="token"}`)
+		return spamReject, "Form expired, please reload the page and try again"
+	}
+	if age < config.FormMinTime {
+		metrics.Inc(`spam_rejected_total{reason="too_fast"}`)
+		return spamReject, "Submitted too quickly, please try again"
+	}
+	return spamOK, ""
+}
@@ -0,0 +1,15 @@
+package main
+
+import "time"
+
+// sweepState drops in-memory state that has expired or, for per-client
+// state, gone unused for STATE_MAX_AGE, so maps keyed by IP or key don't
+// grow for the life of the process. Used nonces and challenges are kept
+// until they expire whatever their age, since they guard against replay.
+func sweepState(now time.Time) {
+	sweepIdempotency(now)
+	sweepQuotas(now)
+	sweepPow(now)
+	sweepGrants(now)
+	sweepCommentLimits(now)
+}
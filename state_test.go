@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// useState empties the in-memory per-client maps for the test and puts
+// the old ones back after.
+func useState(t *testing.T) {
+	t.Helper()
+	useConfig(t)
+	idem, quota, load, used, grants, comments := idemKeys, quotas, powLoad, powUsed, grantUsed, commentLimits
+	dirty := quotaDirty
+	idemKeys = make(map[string]idemEntry)
+	quotas = make(map[string]*quotaState)
+	powLoad = make(map[string]*powWindow)
+	powUsed = make(map[string]time.Time)
+	grantUsed = make(map[string]time.Time)
+	commentLimits = make(map[string]*tokenBucket)
+	t.Cleanup(func() {
+		idemKeys, quotas, powLoad, powUsed, grantUsed, commentLimits = idem, quota, load, used, grants, comments
+		quotaDirty = dirty
+	})
+}
+
+func TestSweepStateEvictsStale(t *testing.T) {
+	useState(t)
+	config.StateMaxAge = time.Hour
+	config.CommentsPerHour = 5
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+
+	// An idempotency key used too long ago goes even if it hasn't expired
+	idemKeys["stale"] = idemEntry{used: old, expires: now.Add(time.Hour)}
+	idemKeys["expired"] = idemEntry{used: now, expires: now.Add(-time.Second)}
+	idemKeys["fresh"] = idemEntry{used: now, expires: now.Add(time.Hour)}
+
+	// A quota goes once both buckets are idle, refilled or not
+	quotas["stale"] = &quotaState{Count: tokenBucket{Last: old}, Bytes: tokenBucket{Last: old}}
+	quotas["half"] = &quotaState{Count: tokenBucket{Last: old}, Bytes: tokenBucket{Last: now}}
+	quotas["fresh"] = &quotaState{Count: tokenBucket{Last: now}, Bytes: tokenBucket{Last: now}}
+
+	powLoad["stale"] = &powWindow{start: now.Add(-powLoadWindow - time.Second), count: 50}
+	powLoad["fresh"] = &powWindow{start: now, count: 50}
+	// Replay guards are kept until they expire, however old
+	powUsed["expired"] = now.Add(-time.Second)
+	powUsed["live"] = now.Add(time.Minute)
+	grantUsed["expired"] = now.Add(-time.Second)
+	grantUsed["live"] = now.Add(48 * time.Hour)
+
+	commentLimits["refilled"] = &tokenBucket{Last: old}
+	commentLimits["fresh"] = &tokenBucket{Last: now}
+
+	sweepState(now)
+
+	check := func(name string, has func(string) bool, kept, gone []string) {
+		t.Helper()
+		for _, k := range kept {
+			if !has(k) {
+				t.Errorf("%s: %q was evicted", name, k)
+			}
+		}
+		for _, k := range gone {
+			if has(k) {
+				t.Errorf("%s: %q was kept", name, k)
+			}
+		}
+	}
+	check("idempotency", func(k string) bool { _, ok := idemKeys[k]; return ok },
+		[]string{"fresh"}, []string{"stale", "expired"})
+	check("quotas", func(k string) bool { _, ok := quotas[k]; return ok },
+		[]string{"half", "fresh"}, []string{"stale"})
+	check("pow load", func(k string) bool { _, ok := powLoad[k]; return ok },
+		[]string{"fresh"}, []string{"stale"})
+	check("pow used", func(k string) bool { _, ok := powUsed[k]; return ok },
+		[]string{"live"}, []string{"expired"})
+	check("grants", func(k string) bool { _, ok := grantUsed[k]; return ok },
+		[]string{"live"}, []string{"expired"})
+	check("comment limits", func(k string) bool { _, ok := commentLimits[k]; return ok },
+		[]string{"fresh"}, []string{"refilled"})
+	if !quotaDirty {
+		t.Error("evicting a quota didn't mark the quotas for saving")
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"embed"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+//go:embed static
+var embeddedStatic embed.FS
+
+// assets serves files from the operator's STATIC_DIR first, then the
+// embedded defaults. The override dir is opened as an os.Root, so neither
+// ".." nor symlinks can reach outside it.
+var assets fs.FS
+
+// layeredFS tries each layer in order and never exposes directories.
+type layeredFS []fs.FS
+
+func (l layeredFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, fs.ErrNotExist
+	}
+	for _, layer := range l {
+		f, err := layer.Open(name)
+		if err != nil {
+			continue
+		}
+		if info, err := f.Stat(); err != nil || info.IsDir() {
+			f.Close()
+			continue
+		}
+		return f, nil
+	}
+	return nil, fs.ErrNotExist
+}
+
+func initAssets() {
+	defaults, _ := fs.Sub(embeddedStatic, "static")
+	layers := layeredFS{defaults}
+	if config.StaticDir != "" {
+		root, err := os.OpenRoot(config.StaticDir)
+		if err != nil {
+			slog.Error("open static dir", "err", err)
+			os.Exit(1)
+		}
+		layers = layeredFS{root.FS(), defaults}
+	}
+	assets = layers
+}
+
+// hasLogo reports whether a logo.png is available to show in page headers.
+func hasLogo() bool {
+	f, err := assets.Open("logo.png")
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+// assetHashes caches the content hash of each asset. Assets are read once,
+// so changes to STATIC_DIR need a restart to reach hashed URLs.
+var (
+	assetMu     sync.Mutex
+	assetHashes = map[string]string{}
+)
+
+// assetHash returns the first 8 hex digits of the SHA-256 of the named
+// asset, or "" if there is no such asset.
+func assetHash(name string) string {
+	assetMu.Lock()
+	defer assetMu.Unlock()
+	if sum, ok := assetHashes[name]; ok {
+		return sum
+	}
+	f, err := assets.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return ""
+	}
+	sum := bodyHash(data)[:8]
+	assetHashes[name] = sum
+	return sum
+}
+
+// assetPath is the URL of a static asset with its content hash in the
+// file name, e.g. /static/encrypted.1a2b3c4d.js, so it can be cached
+// forever and still change on upgrade.
+func assetPath(name string) string {
+	sum := assetHash(name)
+	if sum == "" {
+		return sitePath("/static/" + name)
+	}
+	ext := path.Ext(name)
+	return sitePath("/static/" + strings.TrimSuffix(name, ext) + "." + sum + ext)
+}
+
+// splitHash splits the content hash assetPath puts in a file name off
+// name. ok is false for names without one.
+func splitHash(name string) (plain, sum string, ok bool) {
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	i := strings.LastIndexByte(stem, '.')
+	if i < 0 || len(stem)-i-1 != 8 {
+		return name, "", false
+	}
+	return stem[:i] + ext, stem[i+1:], true
+}
+
+// staticHandler serves /static/ and /favicon.ico from assets. Names with
+// the current content hash are cached for a year; plain names, and hashed
+// names left over from an older version, only for an hour.
+func staticHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/favicon.ico" {
+			w.Header().Set("Cache-Control", "public, max-age=86400")
+			http.ServeFileFS(w, r, assets, "favicon.ico")
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/static/")
+		cache := "public, max-age=3600"
+		if plain, sum, ok := splitHash(name); ok && assetHash(name) == "" {
+			name = plain
+			if sum == assetHash(plain) {
+				cache = "public, max-age=31536000, immutable"
+			}
+		}
+		w.Header().Set("Cache-Control", cache)
+		http.ServeFileFS(w, r, assets, name)
+	})
+}
@@ -0,0 +1,20 @@
+// Copy buttons and select-on-focus fields, wired up here rather than in
+// inline handlers so the Content-Security-Policy can forbid those.
+// data-copy copies its value, data-copy-from the value of the element with
+// that id, and data-copy-location the page URL.
+(function () {
+    'use strict';
+
+    document.querySelectorAll('[data-copy], [data-copy-from], [data-copy-location]').forEach(function (button) {
+        button.addEventListener('click', function () {
+            var text = button.dataset.copy;
+            if (button.dataset.copyFrom) text = document.getElementById(button.dataset.copyFrom).value;
+            if (button.hasAttribute('data-copy-location')) text = window.location.href;
+            navigator.clipboard.writeText(text);
+        });
+    });
+
+    document.querySelectorAll('[data-select]').forEach(function (field) {
+        field.addEventListener('focus', function () { field.select(); });
+    });
+})();
@@ -0,0 +1,119 @@
+// Client-side encryption for tinypaste. The key is generated here and only
+// ever travels in the URL fragment, which browsers never send to the
+// server. Ciphertext is base64(iv || AES-256-GCM ciphertext and tag); the
+// plaintext is deflated first when the browser supports it.
+(function () {
+    'use strict';
+
+    function toBase64(bytes) {
+        var s = '';
+        for (var i = 0; i < bytes.length; i++) s += String.fromCharCode(bytes[i]);
+        return btoa(s);
+    }
+
+    function fromBase64(s) {
+        var bin = atob(s), bytes = new Uint8Array(bin.length);
+        for (var i = 0; i < bin.length; i++) bytes[i] = bin.charCodeAt(i);
+        return bytes;
+    }
+
+    function toBase64URL(bytes) {
+        return toBase64(bytes).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+    }
+
+    function fromBase64URL(s) {
+        s = s.replace(/-/g, '+').replace(/_/g, '/');
+        while (s.length % 4) s += '=';
+        return fromBase64(s);
+    }
+
+    function pipe(bytes, stream) {
+        return new Response(new Blob([bytes]).stream().pipeThrough(stream)).arrayBuffer()
+            .then(function (buf) { return new Uint8Array(buf); });
+    }
+
+    function encrypt(text) {
+        var compression = typeof CompressionStream === 'function' ? 'deflate' : 'none';
+        var plain = new TextEncoder().encode(text);
+        var iv = crypto.getRandomValues(new Uint8Array(12));
+        var packed = compression === 'deflate' ? pipe(plain, new CompressionStream('deflate-raw')) : Promise.resolve(plain);
+        return Promise.all([
+            packed,
+            crypto.subtle.generateKey({name: 'AES-GCM', length: 256}, true, ['encrypt'])
+        ]).then(function (r) {
+            var key = r[1];
+            return Promise.all([
+                crypto.subtle.encrypt({name: 'AES-GCM', iv: iv}, key, r[0]),
+                crypto.subtle.exportKey('raw', key)
+            ]);
+        }).then(function (r) {
+            var ct = new Uint8Array(r[0]), out = new Uint8Array(iv.length + ct.length);
+            out.set(iv);
+            out.set(ct, iv.length);
+            return {ciphertext: toBase64(out), compression: compression, key: toBase64URL(new Uint8Array(r[1]))};
+        });
+    }
+
+    function decrypt(ciphertext, compression, keyText) {
+        var raw = fromBase64(ciphertext);
+        return crypto.subtle.importKey('raw', fromBase64URL(keyText), 'AES-GCM', false, ['decrypt'])
+            .then(function (key) {
+                return crypto.subtle.decrypt({name: 'AES-GCM', iv: raw.slice(0, 12)}, key, raw.slice(12));
+            })
+            .then(function (buf) {
+                var bytes = new Uint8Array(buf);
+                return compression === 'deflate' ? pipe(bytes, new DecompressionStream('deflate-raw')) : bytes;
+            })
+            .then(function (bytes) { return new TextDecoder().decode(bytes); });
+    }
+
+    // View page: decrypt with the key from the fragment
+    var source = document.getElementById('ciphertext');
+    if (source) {
+        var status = document.getElementById('decrypt-status');
+        var key = location.hash.slice(1);
+        if (!key) {
+            status.textContent = 'This paste is encrypted and the link has no key. Ask the sender for the full link.';
+            return;
+        }
+        decrypt(source.textContent.trim(), source.dataset.compression, key).then(function (text) {
+            document.getElementById('plaintext').textContent = text;
+            status.textContent = '';
+        }, function () {
+            status.textContent = 'Could not decrypt this paste. The key in the link is wrong or incomplete.';
+        });
+        return;
+    }
+
+    // Create form: encrypt the body and post it ourselves so the key can be
+    // appended to the paste link. Proof of work, if enabled, runs first and
+    // resubmits the form.
+    var form = document.querySelector('form[action$="/save"]');
+    var toggle = document.getElementById('encrypt');
+    if (!form || !toggle || !window.crypto || !crypto.subtle) return;
+    toggle.parentElement.hidden = false;
+    form.addEventListener('submit', function (ev) {
+        if (ev.defaultPrevented || !toggle.checked) return;
+        ev.preventDefault();
+        var button = document.getElementById('save');
+        button.disabled = true;
+        encrypt(form.elements.body.value).then(function (enc) {
+            var data = new URLSearchParams(new FormData(form));
+            data.set('body', enc.ciphertext);
+            data.set('cipher', 'aes-256-gcm');
+            data.set('compression', enc.compression);
+            ['encrypt', 'language', 'public', 'keep_original', 'file', 'filename'].forEach(function (name) { data.delete(name); });
+            return fetch(form.action, {method: 'POST', body: data, headers: {Accept: 'application/json'}}).then(function (res) {
+                if (res.status !== 201) {
+                    return res.text().then(function (msg) { throw new Error(msg); });
+                }
+                return res.json().then(function (created) {
+                    location.href = created.url + '#' + enc.key;
+                });
+            });
+        }).catch(function (err) {
+            button.disabled = false;
+            alert(err.message || 'Encryption failed');
+        });
+    });
+})();
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// startTime is when the process started, for uptime.
+var startTime = time.Now()
+
+// bucketTally counts the paste files in one bucket.
+type bucketTally struct {
+	Pastes int
+	Bytes  int64
+	ByTTL  map[string]int
+}
+
+func (t *bucketTally) add(ttl string, size int64, n int) {
+	if t.ByTTL == nil {
+		t.ByTTL = make(map[string]int)
+	}
+	t.Pastes += n
+	t.Bytes += size * int64(n)
+	t.ByTTL[ttl] += n
+}
+
+// The store tallies are kept per bucket. Creating and removing pastes
+// adjusts them, and every walk of a bucket by cleanup replaces its tally,
+// which corrects anything the adjustments missed, such as takedowns. A walk
+// of all buckets at startup fills them in.
+var (
+	tallyMu sync.Mutex
+	tallies [256]bucketTally
+)
+
+// bucketIndex returns the bucket number of a paste file path, or -1 for
+// files outside the buckets.
+func bucketIndex(path string) int {
+	dir := filepath.Dir(path)
+	if filepath.Dir(dir) != "pastes" {
+		return -1
+	}
+	n, err := strconv.ParseUint(filepath.Base(dir), 16, 8)
+	if err != nil || len(filepath.Base(dir)) != 2 {
+		return -1
+	}
+	return int(n)
+}
+
+// tallyFile adds n (1 or -1) paste files of size bytes at path.
+func tallyFile(path string, size int64, n int) {
+	i := bucketIndex(path)
+	if i < 0 {
+		return
+	}
+	_, ttl, _ := strings.Cut(strings.TrimSuffix(filepath.Base(path), ".txt"), "_")
+	tallyMu.Lock()
+	tallies[i].add(ttl, size, n)
+	tallyMu.Unlock()
+}
+
+// tallyStored counts a paste file just written to path.
+func tallyStored(path string) {
+	if info, err := store.Stat(path); err == nil {
+		tallyFile(path, info.Size(), 1)
+	}
+}
+
+// tallyBuckets walks buckets start to end-1 and replaces their tallies.
+func tallyBuckets(start, end int) {
+	fresh := make([]bucketTally, end-start)
+	walkBuckets(start, end, func(f pasteFile) {
+		if i := bucketIndex(f.Path); i >= start && i < end {
+			fresh[i-start].add(f.TTL, f.Size, 1)
+		}
+	})
+	tallyMu.Lock()
+	copy(tallies[start:end], fresh)
+	tallyMu.Unlock()
+}
+
+// storeTotals sums the bucket tallies.
+func storeTotals() (pastes int, bytes int64, byTTL map[string]int) {
+	byTTL = make(map[string]int)
+	tallyMu.Lock()
+	defer tallyMu.Unlock()
+	for _, t := range tallies {
+		pastes += t.Pastes
+		bytes += t.Bytes
+		for ttl, n := range t.ByTTL {
+			byTTL[ttl] += n
+		}
+	}
+	return pastes, bytes, byTTL
+}
+
+// window counts events per minute over the last day.
+type window struct {
+	mu      sync.Mutex
+	counts  [24 * 60]int64
+	minutes [24 * 60]int64
+}
+
+func (w *window) add(now time.Time) {
+	m := now.Unix() / 60
+	i := m % int64(len(w.counts))
+	w.mu.Lock()
+	if w.minutes[i] != m {
+		w.minutes[i], w.counts[i] = m, 0
+	}
+	w.counts[i]++
+	w.mu.Unlock()
+}
+
+// since returns the events in the last d, to the minute.
+func (w *window) since(now time.Time, d time.Duration) int64 {
+	m := now.Unix() / 60
+	oldest := m - int64(d/time.Minute)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var total int64
+	for i, minute := range w.minutes {
+		if minute > oldest && minute <= m {
+			total += w.counts[i]
+		}
+	}
+	return total
+}
+
+// Pastes created and served, for /api/stats and /metrics.
+var createdWindow, viewWindow window
+
+// Cleanup results, for /api/stats and /metrics.
+var (
+	cleanupStatsMu    sync.Mutex
+	lastCleanup       time.Time
+	lastCleanupCount  int
+	cleanupTotalCount int
+)
+
+// recordCleanup notes a cleanup pass that removed n expired pastes.
+func recordCleanup(at time.Time, n int) {
+	cleanupStatsMu.Lock()
+	lastCleanup, lastCleanupCount = at, n
+	cleanupTotalCount += n
+	cleanupStatsMu.Unlock()
+}
+
+// instanceStats is what /api/stats answers with. /metrics exports the
+// same numbers.
+type instanceStats struct {
+	GeneratedAt   string         `json:"generated_at"`
+	UptimeSeconds int64          `json:"uptime_seconds"`
+	Pastes        int            `json:"pastes"`
+	Bytes         int64          `json:"bytes"`
+	ByTTL         map[string]int `json:"by_ttl"`
+	Created       windowCounts   `json:"created"`
+	Views         windowCounts   `json:"views"`
+	Cleanup       cleanupStats   `json:"cleanup"`
+}
+
+type windowCounts struct {
+	LastHour int64 `json:"last_hour"`
+	Last24h  int64 `json:"last_24h"`
+}
+
+type cleanupStats struct {
+	LastRunAt      string `json:"last_run_at,omitempty"`
+	RemovedLastRun int    `json:"removed_last_run"`
+	RemovedTotal   int    `json:"removed_total"`
+
+	lastRun time.Time
+}
+
+// statsTTL is how long a /api/stats snapshot is reused.
+const statsTTL = 5 * time.Second
+
+var (
+	statsSnapMu sync.Mutex
+	statsSnap   *instanceStats
+	statsSnapAt time.Time
+)
+
+// currentStats returns a snapshot of the counters at most statsTTL old.
+func currentStats() *instanceStats {
+	statsSnapMu.Lock()
+	defer statsSnapMu.Unlock()
+	now := time.Now()
+	if statsSnap != nil && now.Sub(statsSnapAt) < statsTTL {
+		return statsSnap
+	}
+
+	s := &instanceStats{
+		GeneratedAt:   now.UTC().Format(time.RFC3339),
+		UptimeSeconds: int64(now.Sub(startTime).Seconds()),
+		Created:       windowCounts{createdWindow.since(now, time.Hour), createdWindow.since(now, 24*time.Hour)},
+		Views:         windowCounts{viewWindow.since(now, time.Hour), viewWindow.since(now, 24*time.Hour)},
+	}
+	s.Pastes, s.Bytes, s.ByTTL = storeTotals()
+	cleanupStatsMu.Lock()
+	if !lastCleanup.IsZero() {
+		s.Cleanup.LastRunAt = lastCleanup.UTC().Format(time.RFC3339)
+		s.Cleanup.lastRun = lastCleanup
+	}
+	s.Cleanup.RemovedLastRun = lastCleanupCount
+	s.Cleanup.RemovedTotal = cleanupTotalCount
+	cleanupStatsMu.Unlock()
+
+	statsSnap, statsSnapAt = s, now
+	return s
+}
+
+// statsHandler serves GET /api/stats. With STATS_TOKEN set it wants that
+// token as a bearer token.
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if config.StatsToken != "" {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.StatsToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tinypaste"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	w.Header().Set("Cache-Control", "private, max-age=5")
+	writeJSON(w, http.StatusOK, currentStats())
+}
+
+// publicStats is what /stats.json answers with: totals rounded so they
+// describe the instance without tracking individual pastes.
+type publicStats struct {
+	Pastes        int64 `json:"pastes"`
+	ApproxBytes   int64 `json:"approx_bytes"`
+	UptimeSeconds int64 `json:"uptime_seconds"`
+}
+
+// publicStatsHandler serves GET /stats.json from the same cached snapshot
+// as /api/stats.
+func publicStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s := currentStats()
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	writeJSON(w, http.StatusOK, publicStats{
+		Pastes:        roughly(int64(s.Pastes)),
+		ApproxBytes:   roughly(s.Bytes),
+		UptimeSeconds: s.UptimeSeconds,
+	})
+}
+
+// roughly rounds n to two significant digits, so one paste coming or
+// going doesn't show.
+func roughly(n int64) int64 {
+	unit := int64(1)
+	for n/unit >= 100 {
+		unit *= 10
+	}
+	return (n + unit/2) / unit * unit
+}
+
+// registerStatsGauges exports the /api/stats numbers in /metrics, read
+// from the same snapshot so the two agree.
+func registerStatsGauges() {
+	stat := func(fn func(s *instanceStats) float64) func() float64 {
+		return func() float64 { return fn(currentStats()) }
+	}
+	metrics.Gauge("uptime_seconds", stat(func(s *instanceStats) float64 { return float64(s.UptimeSeconds) }))
+	metrics.Gauge("pastes_stored", stat(func(s *instanceStats) float64 { return float64(s.Pastes) }))
+	metrics.Gauge("paste_bytes_stored", stat(func(s *instanceStats) float64 { return float64(s.Bytes) }))
+	for ttl := range TTLHours {
+		metrics.Gauge(`pastes_stored_by_ttl{ttl="`+ttl+`"}`, stat(func(s *instanceStats) float64 { return float64(s.ByTTL[ttl]) }))
+	}
+	metrics.Gauge("pastes_created_last_hour", stat(func(s *instanceStats) float64 { return float64(s.Created.LastHour) }))
+	metrics.Gauge("pastes_created_last_24h", stat(func(s *instanceStats) float64 { return float64(s.Created.Last24h) }))
+	metrics.Gauge("paste_views_last_hour", stat(func(s *instanceStats) float64 { return float64(s.Views.LastHour) }))
+	metrics.Gauge("paste_views_last_24h", stat(func(s *instanceStats) float64 { return float64(s.Views.Last24h) }))
+	metrics.Gauge("cleanup_removed_last_run", stat(func(s *instanceStats) float64 { return float64(s.Cleanup.RemovedLastRun) }))
+	metrics.Gauge("cleanup_removed_total", stat(func(s *instanceStats) float64 { return float64(s.Cleanup.RemovedTotal) }))
+	metrics.Gauge("cleanup_last_run_timestamp_seconds", stat(func(s *instanceStats) float64 {
+		if s.Cleanup.lastRun.IsZero() {
+			return 0
+		}
+		return float64(s.Cleanup.lastRun.Unix())
+	}))
+}
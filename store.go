@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Store holds the files under pastes/: paste files, tombstones and
+// takedown notices, named by the same paths whichever store is in use.
+// A file's modification time is its paste's creation time.
+type Store interface {
+	// Save writes a paste file in one piece, so readers never see part of
+	// it, replacing any file at path.
+	Save(ctx context.Context, path string, write func(io.Writer) error) error
+
+	// WriteFile writes a small bookkeeping file such as a tombstone.
+	WriteFile(path string, data []byte) error
+
+	Open(path string) (io.ReadCloser, error)
+	ReadFile(path string) ([]byte, error)
+	Stat(path string) (fs.FileInfo, error)
+	ReadDir(dir string) ([]fs.DirEntry, error)
+	Glob(pattern string) ([]string, error)
+	Rename(from, to string) error
+	Remove(path string) error
+	Chtimes(path string, t time.Time) error
+}
+
+// store is where pastes live, set from STORE at startup.
+var store Store = diskStore{}
+
+// errStoreFull rejects a paste that would take the memory store past
+// MEMORY_LIMIT.
+var errStoreFull = errors.New("Paste storage is full, try again later")
+
+// saveBackoff is how long saveRetrying waits before each retry.
+var saveBackoff = []time.Duration{50 * time.Millisecond, 200 * time.Millisecond}
+
+// saveRetrying saves through store, retrying errors a busy or briefly
+// failing disk can cause. Errors that won't pass by themselves, such as a
+// full disk or quota, fail at once. Each attempt starts over, so write
+// must be able to run more than once.
+func saveRetrying(ctx context.Context, path string, write func(io.Writer) error) error {
+	for attempt := 0; ; attempt++ {
+		err := store.Save(ctx, path, write)
+		if err == nil || !transientError(err) || attempt == len(saveBackoff) {
+			return err
+		}
+		slog.Warn("save failed, retrying", "path", path, "attempt", attempt+1, "err", err)
+		metrics.Inc("save_retries_total")
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(saveBackoff[attempt]):
+		}
+	}
+}
+
+// transientError reports whether err is worth retrying.
+func transientError(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EAGAIN, syscall.EINTR, syscall.EBUSY, syscall.EIO, syscall.ETIMEDOUT} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// diskStore keeps files in the working directory.
+type diskStore struct{}
+
+// Save writes to a temporary file in the same directory and renames it
+// into place, syncing as SYNC_MODE asks. Cleanup may remove the bucket
+// once empty, so it is held until the file exists.
+func (diskStore) Save(ctx context.Context, path string, write func(io.Writer) error) (err error) {
+	dir := filepath.Dir(path)
+	bucketMu.RLock()
+	os.MkdirAll(dir, 0755)
+	file, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	bucketMu.RUnlock()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+
+	done := timePhase(ctx, "write")
+	out := bufio.NewWriter(file)
+	err = write(out)
+	if err == nil {
+		err = out.Flush()
+	}
+	done()
+	if err != nil {
+		return err
+	}
+
+	// Force sync to disk unless the operator traded durability for speed
+	if config.SyncMode == syncAlways {
+		done := timePhase(ctx, "fsync")
+		err = file.Sync()
+		done()
+		if err != nil {
+			return err
+		}
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(file.Name(), path); err != nil {
+		return err
+	}
+	done = timePhase(ctx, "fsync_dir")
+	err = syncEntry(path)
+	done()
+	return err
+}
+
+func (diskStore) WriteFile(path string, data []byte) error {
+	bucketMu.RLock()
+	defer bucketMu.RUnlock()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+func (diskStore) Open(path string) (io.ReadCloser, error)   { return os.Open(path) }
+func (diskStore) ReadFile(path string) ([]byte, error)      { return os.ReadFile(path) }
+func (diskStore) Stat(path string) (fs.FileInfo, error)     { return os.Stat(path) }
+func (diskStore) ReadDir(dir string) ([]fs.DirEntry, error) { return os.ReadDir(dir) }
+func (diskStore) Glob(pattern string) ([]string, error)     { return filepath.Glob(pattern) }
+func (diskStore) Remove(path string) error                  { return os.Remove(path) }
+func (diskStore) Chtimes(path string, t time.Time) error    { return os.Chtimes(path, t, t) }
+
+func (diskStore) Rename(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0700); err != nil {
+		return err
+	}
+	return os.Rename(from, to)
+}
+
+// memStore keeps files in memory only, so pastes vanish on restart and
+// never touch the disk. Its total size is capped at limit bytes.
+type memStore struct {
+	mu    sync.RWMutex
+	files map[string]*memFile
+	size  int64
+	limit int64
+}
+
+type memFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+func newMemStore(limit int64) *memStore {
+	return &memStore{files: make(map[string]*memFile), limit: limit}
+}
+
+func (s *memStore) Save(ctx context.Context, path string, write func(io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
+	}
+	return s.put(path, buf.Bytes())
+}
+
+func (s *memStore) WriteFile(path string, data []byte) error {
+	return s.put(path, slices.Clone(data))
+}
+
+// put stores data at path unless that takes the store over its limit.
+func (s *memStore) put(path string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	size := s.size + int64(len(data))
+	if old := s.files[path]; old != nil {
+		size -= int64(len(old.data))
+	}
+	if size > s.limit {
+		return errStoreFull
+	}
+	s.files[path] = &memFile{data: data, modTime: time.Now()}
+	s.size = size
+	return nil
+}
+
+// Size returns the bytes held.
+func (s *memStore) Size() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.size
+}
+
+func (s *memStore) get(op, path string) (*memFile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	f := s.files[path]
+	if f == nil {
+		return nil, &fs.PathError{Op: op, Path: path, Err: fs.ErrNotExist}
+	}
+	return f, nil
+}
+
+// Files are never changed in place, only replaced, so readers can keep
+// using the data they got.
+
+func (s *memStore) Open(path string) (io.ReadCloser, error) {
+	f, err := s.get("open", path)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(f.data)), nil
+}
+
+func (s *memStore) ReadFile(path string) ([]byte, error) {
+	f, err := s.get("read", path)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Clone(f.data), nil
+}
+
+func (s *memStore) Stat(path string) (fs.FileInfo, error) {
+	f, err := s.get("stat", path)
+	if err != nil {
+		return nil, err
+	}
+	return memInfo{name: filepath.Base(path), size: int64(len(f.data)), modTime: f.modTime}, nil
+}
+
+func (s *memStore) ReadDir(dir string) ([]fs.DirEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var entries []fs.DirEntry
+	for path, f := range s.files {
+		if filepath.Dir(path) == filepath.Clean(dir) {
+			entries = append(entries, fs.FileInfoToDirEntry(memInfo{name: filepath.Base(path), size: int64(len(f.data)), modTime: f.modTime}))
+		}
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return entries, nil
+}
+
+func (s *memStore) Glob(pattern string) ([]string, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var matches []string
+	for path := range s.files {
+		if ok, _ := filepath.Match(pattern, path); ok {
+			matches = append(matches, path)
+		}
+	}
+	slices.Sort(matches)
+	return matches, nil
+}
+
+func (s *memStore) Rename(from, to string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.files[from]
+	if f == nil {
+		return &fs.PathError{Op: "rename", Path: from, Err: fs.ErrNotExist}
+	}
+	if old := s.files[to]; old != nil && to != from {
+		s.size -= int64(len(old.data))
+	}
+	delete(s.files, from)
+	s.files[to] = f
+	return nil
+}
+
+func (s *memStore) Remove(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.files[path]
+	if f == nil {
+		return &fs.PathError{Op: "remove", Path: path, Err: fs.ErrNotExist}
+	}
+	delete(s.files, path)
+	s.size -= int64(len(f.data))
+	return nil
+}
+
+func (s *memStore) Chtimes(path string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.files[path]
+	if f == nil {
+		return &fs.PathError{Op: "chtimes", Path: path, Err: fs.ErrNotExist}
+	}
+	s.files[path] = &memFile{data: f.data, modTime: t}
+	return nil
+}
+
+// memInfo describes a memStore file.
+type memInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) Mode() fs.FileMode  { return 0600 }
+func (i memInfo) ModTime() time.Time { return i.modTime }
+func (i memInfo) IsDir() bool        { return false }
+func (i memInfo) Sys() any           { return nil }
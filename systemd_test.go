@@ -0,0 +1,150 @@
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+// handOver passes ln's socket to systemdListener as systemd would: at
+// listenFDsStart, with LISTEN_PID and LISTEN_FDS naming it.
+func handOver(t *testing.T, ln net.Listener, pid, fds string) {
+	t.Helper()
+	f, err := ln.(interface{ File() (*os.File, error) }).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := listenFDsStart
+	listenFDsStart = uintptr(fd)
+	t.Cleanup(func() { listenFDsStart = old })
+	t.Setenv("LISTEN_PID", pid)
+	t.Setenv("LISTEN_FDS", fds)
+	t.Setenv("LISTEN_FDNAMES", "tinypaste.socket")
+}
+
+func TestSystemdListener(t *testing.T) {
+	self := strconv.Itoa(os.Getpid())
+	for _, network := range []string{"tcp", "unix"} {
+		t.Run(network, func(t *testing.T) {
+			addr := "127.0.0.1:0"
+			if network == "unix" {
+				addr = filepath.Join(t.TempDir(), "sock")
+			}
+			orig, err := net.Listen(network, addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer orig.Close()
+			handOver(t, orig, self, "1")
+
+			ln, err := systemdListener()
+			if err != nil || ln == nil {
+				t.Fatalf("systemdListener() = %v, %v", ln, err)
+			}
+			defer ln.Close()
+			if ln.Addr().String() != orig.Addr().String() {
+				t.Errorf("listening on %s, want %s", ln.Addr(), orig.Addr())
+			}
+			for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+				if v, ok := os.LookupEnv(name); ok {
+					t.Errorf("%s = %q left for child processes", name, v)
+				}
+			}
+
+			// A client gets through to the inherited socket
+			go func() {
+				c, err := net.Dial(network, orig.Addr().String())
+				if err == nil {
+					c.Write([]byte("hello"))
+					c.Close()
+				}
+			}()
+			c, err := ln.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			if got, _ := io.ReadAll(c); string(got) != "hello" {
+				t.Errorf("read %q, want hello", got)
+			}
+		})
+	}
+}
+
+func TestSystemdListenerNotActivated(t *testing.T) {
+	self := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		name    string
+		pid     string
+		fds     string
+		wantErr bool
+	}{
+		{"another process's sockets", "1", "1", false},
+		{"no sockets", self, "0", false},
+		{"garbage", self, "x", false},
+		{"too many sockets", self, "2", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orig, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer orig.Close()
+			handOver(t, orig, tt.pid, tt.fds)
+			// Not taken, so still ours to close
+			defer syscall.Close(int(listenFDsStart))
+			ln, err := systemdListener()
+			if ln != nil {
+				ln.Close()
+				t.Error("got a listener")
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSdNotify(t *testing.T) {
+	for name, addr := range map[string]string{
+		"path":     filepath.Join(t.TempDir(), "notify"),
+		"abstract": "@tinypaste-test-" + strconv.Itoa(os.Getpid()),
+	} {
+		t.Run(name, func(t *testing.T) {
+			listen := addr
+			if listen[0] == '@' {
+				listen = "\x00" + listen[1:]
+			}
+			conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: listen, Net: "unixgram"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			t.Setenv("NOTIFY_SOCKET", addr)
+
+			if err := sdNotify("READY=1"); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 64)
+			n, err := conn.Read(buf)
+			if err != nil || string(buf[:n]) != "READY=1" {
+				t.Errorf("read %q, %v; want READY=1", buf[:n], err)
+			}
+		})
+	}
+
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := sdNotify("READY=1"); err != nil {
+		t.Errorf("without NOTIFY_SOCKET: %v", err)
+	}
+}
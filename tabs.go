@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"unicode/utf8"
+)
+
+// tabWidths are the tab widths a paste can be rendered with.
+var tabWidths = []int{2, 4, 8}
+
+// defaultTabWidth applies when neither the paste nor the page picks one.
+const defaultTabWidth = 8
+
+// parseTabWidth returns the tab width s names, or 0 unless it is one of
+// tabWidths.
+func parseTabWidth(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0
+	}
+	for _, w := range tabWidths {
+		if n == w {
+			return n
+		}
+	}
+	return 0
+}
+
+// expandTabs replaces every tab in body with spaces up to the next
+// multiple of width columns. Columns are counted per rune, two for wide
+// East Asian characters, so alignment survives multi-byte text; bytes that
+// aren't UTF-8 count as one column and are kept as they are.
+func expandTabs(body []byte, width int) []byte {
+	if !bytes.ContainsRune(body, '\t') {
+		return body
+	}
+	out := make([]byte, 0, len(body)+len(body)/8)
+	col := 0
+	for len(body) > 0 {
+		r, size := utf8.DecodeRune(body)
+		switch r {
+		case '\t':
+			n := width - col%width
+			out = append(out, bytes.Repeat([]byte{' '}, n)...)
+			col += n
+		case '\n':
+			out = append(out, '\n')
+			col = 0
+		default:
+			out = append(out, body[:size]...)
+			col += runeWidth(r)
+		}
+		body = body[size:]
+	}
+	return out
+}
+
+// runeWidth is the number of columns r takes up in a monospace font.
+func runeWidth(r rune) int {
+	switch {
+	case r >= 0x1100 && r <= 0x115f, // Hangul Jamo
+		r >= 0x2e80 && r <= 0xa4cf && r != 0x303f, // CJK, Kana, Yi
+		r >= 0xac00 && r <= 0xd7a3,                // Hangul syllables
+		r >= 0xf900 && r <= 0xfaff,                // CJK compatibility ideographs
+		r >= 0xfe30 && r <= 0xfe4f,                // CJK compatibility forms
+		r >= 0xff00 && r <= 0xff60,                // Fullwidth forms
+		r >= 0xffe0 && r <= 0xffe6,                // Fullwidth signs
+		r >= 0x1f300 && r <= 0x1f64f,              // Emoji
+		r >= 0x20000 && r <= 0x3fffd:              // CJK extensions
+		return 2
+	}
+	return 1
+}
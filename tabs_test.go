@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"html"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestExpandTabs(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		width int
+		want  string
+	}{
+		{"leading tab", "\tx", 4, "    x"},
+		{"tab mid column", "ab\tc", 4, "ab  c"},
+		{"tab on a stop", "abcd\te", 4, "abcd    e"},
+		{"width 2", "a\tb\tc", 2, "a b c"},
+		{"width 8", "\t\tx", 8, "                x"},
+		{"mixed indentation", "  \tx\n \t y", 4, "    x\n     y"},
+		{"columns restart per line", "abc\n\tx", 4, "abc\n    x"},
+		// Multi-byte but one column wide
+		{"accents", "é\tx", 4, "é   x"},
+		// Each CJK character is two columns
+		{"CJK", "日本\tx", 4, "日本    x"},
+		{"CJK odd", "日\tx", 4, "日  x"},
+		{"CJK then ASCII", "日a\tx", 4, "日a x"},
+		{"fullwidth", "ＡＢ\tx", 8, "ＡＢ    x"},
+		{"invalid UTF-8", "\xff\tx", 4, "\xff   x"},
+		{"no tabs", "日本 x", 4, "日本 x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(expandTabs([]byte(tt.in), tt.width)); got != tt.want {
+				t.Errorf("expandTabs(%q, %d) = %q, want %q", tt.in, tt.width, got, tt.want)
+			}
+		})
+	}
+}
+
+// The view page expands tabs to the width asked for, or the paste's own,
+// while the raw view keeps the tab bytes.
+func TestRenderTabs(t *testing.T) {
+	useMemStore(t)
+	body := "func f() {\n\tif 日本 {\n\t  \treturn\n\t}\n}\n日本語\tx\n"
+	p := &Paste{ID: "00aaaaaaaaaaaaaa", Title: "tabs", Body: []byte(body), TTL: "1h", Normalized: true, Tabs: 4}
+	if err := p.save(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	tags := regexp.MustCompile(`<[^>]*>`)
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "    if 日本 {\n        return\n    }\n}\n日本語  x"},
+		{"?tabs=2", "  if 日本 {\n      return\n  }\n}\n日本語  x"},
+		{"?tabs=8", "        if 日本 {\n                return\n        }\n}\n日本語  x"},
+		// Not one of the widths: the paste's own applies
+		{"?tabs=3", "    if 日本 {\n        return\n    }\n}\n日本語  x"},
+	}
+	for _, tt := range tests {
+		w := getPaste("/p/"+p.ID+tt.query, false)
+		if w.Code != 200 {
+			t.Fatalf("%q: status %d", tt.query, w.Code)
+		}
+		text := html.UnescapeString(tags.ReplaceAllString(w.Body.String(), ""))
+		if !strings.Contains(text, tt.want) {
+			t.Errorf("%q: page doesn't have %q", tt.query, tt.want)
+		}
+		if strings.Contains(text, "\treturn") {
+			t.Errorf("%q: page still has a tab", tt.query)
+		}
+	}
+
+	w := getPaste("/p/"+p.ID+"/raw?tabs=2", false)
+	if w.Body.String() != body {
+		t.Errorf("raw = %q, want the tabs kept, %q", w.Body, body)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Limits on the tags of one paste.
+const (
+	maxTags      = 5
+	maxTagLength = 32
+)
+
+// normalizeTags parses a comma-separated tag list, lowercasing each tag
+// and dropping blanks and repeats. Tags are letters, digits, - and _.
+func normalizeTags(list string) ([]string, error) {
+	var tags []string
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || slices.Contains(tags, tag) {
+			continue
+		}
+		if !validTag(tag) {
+			return nil, fmt.Errorf("Invalid tag %q: want up to %d letters, digits, - or _", tag, maxTagLength)
+		}
+		tags = append(tags, tag)
+	}
+	if len(tags) > maxTags {
+		return nil, fmt.Errorf("Too many tags (max %d)", maxTags)
+	}
+	return tags, nil
+}
+
+// validTag reports whether tag is a normalized tag.
+func validTag(tag string) bool {
+	if tag == "" || len(tag) > maxTagLength {
+		return false
+	}
+	for _, r := range tag {
+		if (!unicode.IsLetter(r) || unicode.IsUpper(r)) && !unicode.IsDigit(r) && r != '-' && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// The tag index lists the pastes carrying each tag, newest first. It is
+// built once at startup and then kept up to date as pastes are saved and
+// removed, expired ones included, so listings never walk the buckets.
+var (
+	tagMu    sync.Mutex
+	tagIndex = make(map[string][]recentEntry)
+	tagsOf   = make(map[string][]string) // tags of each indexed paste, by ID
+)
+
+// loadTagIndex builds the tag index from the pastes on disk.
+func loadTagIndex() {
+	now := time.Now()
+	index, of := make(map[string][]recentEntry), make(map[string][]string)
+	walkBuckets(0, 256, func(f pasteFile) {
+		if f.expired(now) {
+			return
+		}
+		meta, err := readHeader(f.Path)
+		if err != nil || !listedByTag(meta.Public, meta.Quarantine, meta.Tags) {
+			return
+		}
+		for _, tag := range meta.Tags {
+			index[tag] = append(index[tag], recentEntry{
+				ID:       f.ID,
+				Title:    meta.Title,
+				Language: meta.Language,
+				created:  f.Created,
+				expires:  f.expires(),
+			})
+		}
+		of[f.ID] = meta.Tags
+	})
+	for _, entries := range index {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].created.After(entries[j].created) })
+	}
+
+	tagMu.Lock()
+	tagIndex, tagsOf = index, of
+	tagMu.Unlock()
+}
+
+// listedByTag reports whether a paste belongs in the tag index. Like
+// /recent, only pastes their author chose to list publicly are in it;
+// held pastes wait until they are approved.
+func listedByTag(public, quarantined bool, tags []string) bool {
+	return public && !quarantined && len(tags) > 0
+}
+
+// indexTags adds p to the tag index after a save, replacing what an
+// earlier save of it left there.
+func indexTags(p *Paste) {
+	if !config.TagsEnabled {
+		return
+	}
+	tagMu.Lock()
+	defer tagMu.Unlock()
+	unindexTagsLocked(p.ID)
+	if !listedByTag(p.Public, p.Quarantined, p.Tags) {
+		return
+	}
+	created := cmp.Or(p.Created, time.Now())
+	e := recentEntry{
+		ID:       p.ID,
+		Title:    p.Title,
+		Language: p.Language,
+		created:  created,
+		expires:  created.Add(time.Duration(TTLHours[p.TTL]) * time.Hour),
+	}
+	for _, tag := range p.Tags {
+		entries := tagIndex[tag]
+		i, _ := slices.BinarySearchFunc(entries, created, func(e recentEntry, t time.Time) int {
+			return t.Compare(e.created)
+		})
+		tagIndex[tag] = slices.Insert(entries, i, e)
+	}
+	tagsOf[p.ID] = p.Tags
+}
+
+// unindexTags drops paste id from the tag index.
+func unindexTags(id string) {
+	tagMu.Lock()
+	defer tagMu.Unlock()
+	unindexTagsLocked(id)
+}
+
+func unindexTagsLocked(id string) {
+	for _, tag := range tagsOf[id] {
+		tagIndex[tag] = slices.DeleteFunc(tagIndex[tag], func(e recentEntry) bool { return e.ID == id })
+		if len(tagIndex[tag]) == 0 {
+			delete(tagIndex, tag)
+		}
+	}
+	delete(tagsOf, id)
+}
+
+// taggedPastes returns the newest RECENT_LIMIT unexpired pastes carrying
+// tag, newest first, with ages in lang. Pastes past their expiry that
+// cleanup hasn't reached yet are skipped.
+func taggedPastes(tag, lang string) []recentEntry {
+	tagMu.Lock()
+	defer tagMu.Unlock()
+
+	now := time.Now()
+	var entries []recentEntry
+	for _, e := range tagIndex[tag] {
+		if now.After(e.expires) {
+			continue
+		}
+		e.Age = formatAge(lang, now.Sub(e.created))
+		if config.CommentsEnabled {
+			e.Comments = commentCount(e.ID)
+		}
+		entries = append(entries, e)
+		if len(entries) == config.RecentLimit {
+			break
+		}
+	}
+	return entries
+}
+
+// tagCount is one row of the /tags page.
+type tagCount struct {
+	Tag   string
+	Count int
+}
+
+// tagCounts returns every tag in use with its number of unexpired pastes,
+// most used first.
+func tagCounts() []tagCount {
+	tagMu.Lock()
+	defer tagMu.Unlock()
+
+	now := time.Now()
+	var counts []tagCount
+	for tag, entries := range tagIndex {
+		n := 0
+		for _, e := range entries {
+			if !now.After(e.expires) {
+				n++
+			}
+		}
+		if n > 0 {
+			counts = append(counts, tagCount{tag, n})
+		}
+	}
+	slices.SortFunc(counts, func(a, b tagCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), strings.Compare(a.Tag, b.Tag))
+	})
+	return counts
+}
+
+// tagData is what the tag template renders.
+type tagData struct {
+	Tag     string
+	Entries []recentEntry
+}
+
+// tagHandler serves /tags/<tag>, the pastes carrying a tag. The old
+// /tag/<tag> links redirect there.
+func tagHandler(w http.ResponseWriter, r *http.Request) {
+	if !config.TagsEnabled {
+		http.NotFound(w, r)
+		return
+	}
+	if old, ok := strings.CutPrefix(r.URL.Path, "/tag/"); ok {
+		http.Redirect(w, r, sitePath("/tags/"+url.PathEscape(old)), http.StatusMovedPermanently)
+		return
+	}
+	tag := strings.ToLower(strings.TrimPrefix(r.URL.Path, "/tags/"))
+	if !validTag(tag) {
+		http.NotFound(w, r)
+		return
+	}
+	renderTemplate(w, r, "tag", tagData{Tag: tag, Entries: taggedPastes(tag, requestLang(r))})
+}
+
+// tagsHandler serves /tags, every tag in use with its number of pastes.
+func tagsHandler(w http.ResponseWriter, r *http.Request) {
+	if !config.TagsEnabled {
+		http.NotFound(w, r)
+		return
+	}
+	renderTemplate(w, r, "tags", tagCounts())
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// takedownDir holds the original files of taken down pastes. Nothing in
+// it is ever served.
+const takedownDir = "pastes/takedown"
+
+// takedownNotice is shown with a 451 in place of a taken down paste.
+type takedownNotice struct {
+	ID        string    `json:"id"`
+	Reason    string    `json:"reason"`
+	Requester string    `json:"requester,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+func (t *takedownNotice) expired(now time.Time) bool {
+	return now.Sub(t.Time) > config.TakedownRetention
+}
+
+func noticePath(id string) string {
+	return filepath.Join(takedownDir, id+".json")
+}
+
+// takeDown moves the files of id into takedownDir and writes the notice
+// that replaces it. The paste is kept, unserved, for TAKEDOWN_RETENTION
+// in case of a counter-notice.
+func takeDown(notice takedownNotice) (bool, error) {
+	id := notice.ID
+	files, err := store.Glob(bucket(id) + "/" + id + "_*.txt")
+	if err != nil || len(files) == 0 {
+		return false, err
+	}
+	data, err := json.Marshal(notice)
+	if err != nil {
+		return true, err
+	}
+	if err := store.WriteFile(noticePath(id), data); err != nil {
+		return true, err
+	}
+	for _, file := range files {
+		if err := store.Rename(file, filepath.Join(takedownDir, filepath.Base(file))); err != nil {
+			return true, err
+		}
+	}
+	unindexTags(id)
+
+	recentMu.Lock()
+	recentAt = time.Time{}
+	recentMu.Unlock()
+	statsMu.Lock()
+	statsCache = nil
+	statsMu.Unlock()
+	return true, nil
+}
+
+// loadTakedown returns the notice for id, or nil if it was never taken
+// down or the retention period is over.
+func loadTakedown(id string) *takedownNotice {
+	data, err := store.ReadFile(noticePath(id))
+	if err != nil {
+		return nil
+	}
+	var t takedownNotice
+	if json.Unmarshal(data, &t) != nil || t.expired(time.Now()) {
+		return nil
+	}
+	return &t
+}
+
+// sweepTakedowns removes preserved pastes and their notices once the
+// retention period is over.
+func sweepTakedowns(now time.Time) {
+	notices, _ := store.Glob(filepath.Join(takedownDir, "*.json"))
+	for _, path := range notices {
+		id := strings.TrimSuffix(filepath.Base(path), ".json")
+		if !isValidID(id) {
+			continue
+		}
+		data, err := store.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var t takedownNotice
+		if json.Unmarshal(data, &t) == nil && !t.expired(now) {
+			continue
+		}
+		files, _ := store.Glob(filepath.Join(takedownDir, id+"_*.txt"))
+		for _, file := range files {
+			removePaste(file)
+		}
+		store.Remove(path)
+	}
+}
+
+// takedownHandler serves POST /admin/takedown with id, reason and an
+// optional requester reference.
+func takedownHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	notice := takedownNotice{
+		ID:        strings.TrimSpace(r.FormValue("id")),
+		Reason:    strings.TrimSpace(r.FormValue("reason")),
+		Requester: strings.TrimSpace(r.FormValue("requester")),
+		Time:      time.Now().UTC(),
+	}
+	if !isValidID(notice.ID) {
+		http.NotFound(w, r)
+		return
+	}
+	if notice.Reason == "" {
+		http.Error(w, "A reason is required", http.StatusBadRequest)
+		return
+	}
+
+	found, err := takeDown(notice)
+	if err != nil {
+		requestLog(r.Context()).Error("take down paste", "id", notice.ID, "err", err)
+		internalError(w, r)
+		return
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	logAction(operatorAction{Action: "takedown", ID: notice.ID, Reason: notice.Reason, Requester: notice.Requester})
+	slog.Info("operator took down paste", "id", notice.ID)
+
+	// The dashboard form logs in with Basic auth; scripts use the bearer token
+	if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		fmt.Fprintln(w, "taken down")
+		return
+	}
+	http.Redirect(w, r, sitePath("/admin"), http.StatusSeeOther)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// themes are the values of the theme cookie. auto follows the browser's
+// prefers-color-scheme and is the default.
+var themes = []string{"auto", "light", "dark"}
+
+const themeCookie = "theme"
+
+// requestTheme returns the theme chosen by the theme cookie.
+func requestTheme(r *http.Request) string {
+	if c, err := r.Cookie(themeCookie); err == nil && slices.Contains(themes, c.Value) {
+		return c.Value
+	}
+	return "auto"
+}
+
+// themeHandler serves POST /theme from the toggle in page headers. It
+// stores the theme in a cookie for a year and sends the visitor back to
+// the page they came from, so it works without JavaScript.
+func themeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	theme := r.FormValue("theme")
+	if !slices.Contains(themes, theme) {
+		http.Error(w, "Invalid theme: want auto, light or dark", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     themeCookie,
+		Value:    theme,
+		Path:     sitePath("/"),
+		MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, backTo(r), http.StatusSeeOther)
+}
+
+// backTo is the local path of the page a form was posted from, or the
+// front page if the browser didn't say. Only the path and query of the
+// Referer are used, so the redirect can't leave the site.
+func backTo(r *http.Request) string {
+	u, err := url.Parse(r.Referer())
+	if err != nil || !strings.HasPrefix(u.Path, "/") || strings.HasPrefix(u.Path, "//") {
+		return sitePath("/")
+	}
+	if u.RawQuery != "" {
+		return u.Path + "?" + u.RawQuery
+	}
+	return u.Path
+}
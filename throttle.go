@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// globalCount and globalBytes cap how fast the whole instance writes
+// pastes, regardless of who sends them.
+var (
+	globalMu    sync.Mutex
+	globalCount tokenBucket
+	globalBytes tokenBucket
+)
+
+// globalLimits returns the configured instance-wide buckets. Count allows a
+// one second burst, bytes a one minute burst.
+func globalLimits() (count, bytes rateLimit) {
+	count = rateLimit{burst: max(1, config.GlobalRate), perSecond: config.GlobalRate}
+	bytes = rateLimit{burst: float64(config.GlobalBytesPerMinute), perSecond: float64(config.GlobalBytesPerMinute) / 60}
+	return count, bytes
+}
+
+// takeGlobal charges one write of size bytes to the instance. When either
+// bucket is exhausted it returns how long until the write would fit.
+func takeGlobal(size int) (time.Duration, bool) {
+	if config.GlobalRate <= 0 && config.GlobalBytesPerMinute <= 0 {
+		return 0, true
+	}
+	countLimit, bytesLimit := globalLimits()
+	now := time.Now()
+
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	var wait time.Duration
+	if config.GlobalRate > 0 {
+		wait = max(wait, countLimit.wait(&globalCount, now, 1))
+	}
+	if config.GlobalBytesPerMinute > 0 {
+		wait = max(wait, bytesLimit.wait(&globalBytes, now, float64(size)))
+	}
+	if wait > 0 {
+		metrics.Inc("global_throttled_total")
+		return wait, false
+	}
+	if config.GlobalRate > 0 {
+		countLimit.take(&globalCount, 1)
+	}
+	if config.GlobalBytesPerMinute > 0 {
+		bytesLimit.take(&globalBytes, float64(size))
+	}
+	return 0, true
+}
+
+// refundGlobal gives back what takeGlobal charged for a write that was
+// then rejected or failed.
+func refundGlobal(size int) {
+	if config.GlobalRate <= 0 && config.GlobalBytesPerMinute <= 0 {
+		return
+	}
+	countLimit, bytesLimit := globalLimits()
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	if config.GlobalRate > 0 {
+		countLimit.give(&globalCount, 1)
+	}
+	if config.GlobalBytesPerMinute > 0 {
+		bytesLimit.give(&globalBytes, float64(size))
+	}
+}
+
+// globalFits reports whether a write of size bytes can ever fit in the
+// instance's byte bucket, so larger pastes aren't told to retry.
+func globalFits(size int) bool {
+	return config.GlobalBytesPerMinute <= 0 || size <= config.GlobalBytesPerMinute
+}
+
+// registerGlobalGauges exposes how full the instance buckets are, from 0
+// (exhausted) to 1 (idle).
+func registerGlobalGauges() {
+	countLimit, bytesLimit := globalLimits()
+	fill := func(l rateLimit, b *tokenBucket) func() float64 {
+		return func() float64 {
+			globalMu.Lock()
+			defer globalMu.Unlock()
+			l.refill(b, time.Now())
+			return b.Tokens / l.burst
+		}
+	}
+	if config.GlobalRate > 0 {
+		metrics.Gauge("global_write_count_fill", fill(countLimit, &globalCount))
+	}
+	if config.GlobalBytesPerMinute > 0 {
+		metrics.Gauge("global_write_bytes_fill", fill(bytesLimit, &globalBytes))
+	}
+}
+
+// saveSlots bounds how many pastes are written to disk at once, so a burst
+// of creates doesn't turn into a storm of concurrent fsyncs. It is nil when
+// MAX_CONCURRENT_SAVES is unset.
+var saveSlots chan struct{}
+
+func initSaveSlots() {
+	if config.MaxConcurrentSaves > 0 {
+		saveSlots = make(chan struct{}, config.MaxConcurrentSaves)
+		metrics.Gauge("saves_in_progress", func() float64 { return float64(len(saveSlots)) })
+	}
+}
+
+// acquireSave waits up to SAVE_QUEUE_TIMEOUT for a save slot. The returned
+// release must be called once the write is done.
+func acquireSave(ctx context.Context) (release func(), ok bool) {
+	if saveSlots == nil {
+		return func() {}, true
+	}
+	release = func() { <-saveSlots }
+	select {
+	case saveSlots <- struct{}{}:
+		return release, true
+	default:
+	}
+
+	timer := time.NewTimer(config.SaveQueueTimeout)
+	defer timer.Stop()
+	select {
+	case saveSlots <- struct{}{}:
+		return release, true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	metrics.Inc("save_queue_timeouts_total")
+	return nil, false
+}
+
+// throttled answers a request turned away by a limiter. Browsers posting
+// the form get a page saying how long to wait; everyone else plain text.
+func throttled(w http.ResponseWriter, r *http.Request, status int, wait time.Duration, msg string) {
+	w.Header().Set("Retry-After", retryAfter(wait))
+	if _, browser := formAge(r.FormValue("form_token")); browser {
+		renderStatus(w, r, status, "error", msg)
+		return
+	}
+	http.Error(w, msg, status)
+}
+
+// busyMessage says how long to wait in words.
+func busyMessage(wait time.Duration) string {
+	if wait < time.Minute {
+		secs := max(1, int(math.Ceil(wait.Seconds())))
+		if secs == 1 {
+			return "Server busy, please try again in a second."
+		}
+		return fmt.Sprintf("Server busy, please try again in %d seconds.", secs)
+	}
+	return fmt.Sprintf("Server busy, please try again in %s.", wait.Round(time.Minute))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// useGlobalLimit caps instance writes at bytes a minute, starting full.
+func useGlobalLimit(t *testing.T, bytes int) {
+	t.Helper()
+	useMemStore(t)
+	config.GlobalBytesPerMinute = bytes
+	globalMu.Lock()
+	globalCount, globalBytes = tokenBucket{}, tokenBucket{}
+	globalMu.Unlock()
+	t.Cleanup(func() {
+		globalMu.Lock()
+		globalCount, globalBytes = tokenBucket{}, tokenBucket{}
+		globalMu.Unlock()
+	})
+}
+
+// A paste bigger than the whole byte bucket can never be written, so it
+// gets a 413 rather than a Retry-After, and takes nothing from the bucket.
+func TestGlobalLimitTooLarge(t *testing.T) {
+	useGlobalLimit(t, 100)
+	w := postSave(t, url.Values{"title": {"t"}, "body": {strings.Repeat("a", 100)}, "ttl": {"1h"}})
+	if w.Code != http.StatusRequestEntityTooLarge || w.Header().Get("Retry-After") != "" {
+		t.Fatalf("status = %d, Retry-After %q; want 413 without it: %s", w.Code, w.Header().Get("Retry-After"), w.Body)
+	}
+	// 99 bytes and a newline fill the bucket exactly
+	w = postSave(t, url.Values{"title": {"t"}, "body": {strings.Repeat("a", 99)}, "ttl": {"1h"}})
+	if w.Code != http.StatusCreated {
+		t.Errorf("paste the size of the bucket: status %d: %s", w.Code, w.Body)
+	}
+	w = postSave(t, url.Values{"title": {"t"}, "body": {"a"}, "ttl": {"1h"}})
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("with the bucket empty: status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+// Writes turned away after the global check don't use up the limit.
+func TestGlobalLimitRefund(t *testing.T) {
+	useGlobalLimit(t, 100)
+	useModeration(t, &fakeModeration{respond: answer(moderationDeny)})
+	body := strings.Repeat("a", 79)
+	for range 3 {
+		w := postSave(t, url.Values{"title": {"t"}, "body": {body}, "ttl": {"1h"}})
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusUnprocessableEntity, w.Body)
+		}
+	}
+	config.ModerationURL = ""
+	if w := postSave(t, url.Values{"title": {"t"}, "body": {body}, "ttl": {"1h"}}); w.Code != http.StatusCreated {
+		t.Errorf("after denied pastes: status %d: %s", w.Code, w.Body)
+	}
+}
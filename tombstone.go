@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Tombstone reasons. They are fixed codes so nothing from the removed
+// paste can end up in the record.
+const (
+	removedByOperator = "operator"
+	removedByCreator  = "creator"
+	removedExpired    = "expired"
+)
+
+// tombstone marks a removed paste so its link answers 410 instead of 404.
+type tombstone struct {
+	ID      string    `json:"id"`
+	Reason  string    `json:"reason"`
+	Removed time.Time `json:"removed"`
+}
+
+func tombstonePath(id string) string {
+	return bucket(id) + "/" + id + ".gone"
+}
+
+// writeTombstone records that id was removed at the given time. It is a
+// no-op when TOMBSTONE_TTL is zero.
+func writeTombstone(id, reason string, at time.Time) error {
+	if config.TombstoneTTL <= 0 {
+		return nil
+	}
+	data, err := json.Marshal(tombstone{ID: id, Reason: reason, Removed: at.UTC()})
+	if err != nil {
+		return err
+	}
+	return store.WriteFile(tombstonePath(id), data)
+}
+
+// loadTombstone returns the tombstone for id, or nil if there is none or
+// it has outlived TOMBSTONE_TTL.
+func loadTombstone(id string) *tombstone {
+	if config.TombstoneTTL <= 0 {
+		return nil
+	}
+	data, err := store.ReadFile(tombstonePath(id))
+	if err != nil {
+		return nil
+	}
+	var t tombstone
+	if json.Unmarshal(data, &t) != nil || time.Since(t.Removed) > config.TombstoneTTL {
+		return nil
+	}
+	return &t
+}
+
+// sweepTombstones removes expired tombstones in buckets start to end-1.
+func sweepTombstones(start, end int, now time.Time) {
+	for i := start; i < end; i++ {
+		files, _ := store.Glob(fmt.Sprintf("pastes/%02x/*.gone", i))
+		for _, file := range files {
+			if !isValidID(strings.TrimSuffix(filepath.Base(file), ".gone")) {
+				continue
+			}
+			info, err := store.Stat(file)
+			if err == nil && now.Sub(info.ModTime()) > config.TombstoneTTL {
+				store.Remove(file)
+			}
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Pre-authorized upload URLs let an API token holder hand out a single-use
+// /u/<grant> URL. A grant is "<expiry>.<max size>.<ttl>.<nonce>.<sig>",
+// signed with formKey like proof-of-work challenges, so nothing is stored
+// until it is used. Used nonces are remembered until the grant expires.
+
+// maxGrantAge bounds how long an upload URL may stay valid.
+const maxGrantAge = 7 * 24 * time.Hour
+
+// uploadGrant is the decoded form of an upload URL token.
+type uploadGrant struct {
+	Expires time.Time
+	MaxSize int
+	TTL     string
+	Nonce   string
+}
+
+var (
+	grantMu   sync.Mutex
+	grantUsed = make(map[string]time.Time) // nonce -> grant expiry
+)
+
+func signGrant(payload string) string {
+	return signChallenge("upload:" + payload)
+}
+
+func (g uploadGrant) token() string {
+	payload := fmt.Sprintf("%d.%d.%s.%s", g.Expires.Unix(), g.MaxSize, g.TTL, g.Nonce)
+	return payload + "." + signGrant(payload)
+}
+
+// parseGrant verifies the signature and expiry of an upload token.
+func parseGrant(token string) (uploadGrant, bool) {
+	var g uploadGrant
+	payload, sig, ok := cutLast(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(signGrant(payload))) {
+		return g, false
+	}
+	parts := strings.Split(payload, ".")
+	if len(parts) != 4 {
+		return g, false
+	}
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return g, false
+	}
+	g.Expires = time.Unix(expires, 0)
+	if g.MaxSize, err = strconv.Atoi(parts[1]); err != nil {
+		return g, false
+	}
+	g.TTL, g.Nonce = parts[2], parts[3]
+	return g, time.Now().Before(g.Expires)
+}
+
+// claimGrant marks the grant's nonce used, reporting false if it already
+// was. releaseGrant undoes a claim when the upload fails before storing.
+func claimGrant(g uploadGrant) bool {
+	grantMu.Lock()
+	defer grantMu.Unlock()
+	if _, used := grantUsed[g.Nonce]; used {
+		return false
+	}
+	grantUsed[g.Nonce] = g.Expires
+	return true
+}
+
+func releaseGrant(g uploadGrant) {
+	grantMu.Lock()
+	delete(grantUsed, g.Nonce)
+	grantMu.Unlock()
+}
+
+// sweepGrants forgets used nonces whose grants have expired anyway.
+func sweepGrants(now time.Time) {
+	grantMu.Lock()
+	defer grantMu.Unlock()
+	for n, exp := range grantUsed {
+		if now.After(exp) {
+			delete(grantUsed, n)
+		}
+	}
+}
+
+// uploadURLsHandler serves POST /api/upload-urls for API and admin token
+// holders. Optional fields: max_size (e.g. 5m), ttl and expires_in (a Go
+// duration, default 1h).
+func uploadURLsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if config.Upstream != "" {
+		http.Error(w, errReadOnly.Error(), http.StatusForbidden)
+		return
+	}
+	if !creationOpen(w, r) {
+		return
+	}
+	if !apiClient(r) && !adminAuthorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="tinypaste"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	g := uploadGrant{MaxSize: config.MaxBodySize, TTL: r.FormValue("ttl")}
+	if v := r.FormValue("max_size"); v != "" {
+		size, err := parseSize(strings.ToLower(strings.TrimSpace(v)))
+		if err != nil || size <= 0 || size > config.MaxBodySize {
+			http.Error(w, fmt.Sprintf("Invalid max_size (max %s)", formatSize(config.MaxBodySize)), http.StatusBadRequest)
+			return
+		}
+		g.MaxSize = size
+	}
+	if g.TTL == "" {
+		g.TTL = config.DefaultTTL
+	}
+	if _, ok := TTLHours[g.TTL]; !ok {
+		http.Error(w, "Invalid TTL", http.StatusBadRequest)
+		return
+	}
+	age := time.Hour
+	if v := r.FormValue("expires_in"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > maxGrantAge {
+			http.Error(w, fmt.Sprintf("Invalid expires_in (max %s)", maxGrantAge), http.StatusBadRequest)
+			return
+		}
+		age = d
+	}
+	g.Expires = time.Now().Add(age).Truncate(time.Second)
+	nonce, err := randomHex(12)
+	if err != nil {
+		requestLog(r.Context()).Error("generate upload url", "err", err)
+		internalError(w, r)
+		return
+	}
+	g.Nonce = nonce
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{
+		"url":        absoluteURL(r, "/u/"+g.token()),
+		"expires_at": g.Expires.UTC().Format(time.RFC3339),
+		"max_size":   g.MaxSize,
+		"ttl":        g.TTL,
+	})
+}
+
+// uploadHandler serves POST /u/<grant>. The request body is the paste as
+// is; an optional title query parameter names it. It answers with the
+// paste URL.
+func uploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if config.Upstream != "" {
+		http.Error(w, errReadOnly.Error(), http.StatusForbidden)
+		return
+	}
+	if !creationOpen(w, r) {
+		return
+	}
+	g, ok := parseGrant(strings.TrimPrefix(r.URL.Path, "/u/"))
+	if !ok {
+		metrics.Inc(`upload_url_rejected_total{reason="invalid"}`)
+		http.Error(w, "Upload URL invalid or expired", http.StatusForbidden)
+		return
+	}
+	if !claimGrant(g) {
+		metrics.Inc(`upload_url_rejected_total{reason="reused"}`)
+		http.Error(w, "Upload URL already used", http.StatusForbidden)
+		return
+	}
+	stored := false
+	defer func() {
+		if !stored {
+			releaseGrant(g)
+		}
+	}()
+
+	// Normalizing can at most halve the body, by turning CRLF into LF, so
+	// read up to twice the limit and check the size once it's normalized,
+	// as /save does. A body past twice the limit is too large however it
+	// normalizes, and would be cut short if read on.
+	limit := min(g.MaxSize, config.MaxBodySize)
+	data, err := io.ReadAll(io.LimitReader(r.Body, 2*int64(limit)+1))
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(data) > 2*limit {
+		metrics.Inc(`upload_url_rejected_total{reason="size"}`)
+		http.Error(w, fmt.Sprintf("Content too large (max %s)", formatSize(limit)), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	title := r.URL.Query().Get("title")
+	if title == "" {
+		title = "Upload " + time.Now().UTC().Format("2006-01-02 15:04")
+	}
+	// ?cipher=age stores an age file exactly as sent
+	cipher := r.URL.Query().Get("cipher")
+	body := string(data)
+	if cipher == "" && len(data) > 0 {
+		body = normalizeBody(body)
+	}
+	if len(body) > limit {
+		metrics.Inc(`upload_url_rejected_total{reason="size"}`)
+		http.Error(w, fmt.Sprintf("Content too large (max %s)", formatSize(limit)), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if len(title) > config.MaxTitleLength || !utf8.ValidString(title) {
+		http.Error(w, fmt.Sprintf("Invalid title (max %d chars)", config.MaxTitleLength), http.StatusBadRequest)
+		return
+	}
+	if err := validateTitle(title); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body == "" {
+		http.Error(w, "Content required", http.StatusBadRequest)
+		return
+	}
+	if cipher != "" {
+		if _, ok := opaqueCiphers[cipher]; !ok {
+			http.Error(w, "Unsupported cipher", http.StatusBadRequest)
+			return
+		}
+		if err := validateEncrypted(cipher, "none", body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if err := validateBody(body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if cipher == "" && blocked(title, body) {
+		http.Error(w, "Paste rejected", http.StatusBadRequest)
+		return
+	}
+	if contentScanner != nil && cipher == "" {
+		verdict, err := scanContent(r.Context(), []byte(body))
+		if err != nil {
+			http.Error(w, "Content scanner unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if verdict != "" {
+			http.Error(w, "Paste rejected by content scan", http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	// The grant fixes the TTL, but the size policy still caps it
+	ttl := g.TTL
+	if limit, ok := maxTTL(len(body)); ok && TTLHours[ttl] > TTLHours[limit] {
+		ttl = limit
+	}
+	id, err := generateID(false)
+	if err == nil {
+		defer releaseID(id)
+	}
+	var token string
+	if err == nil {
+		token, err = generateDeleteToken()
+	}
+	if err != nil {
+		requestLog(r.Context()).Error("generate paste id", "err", err)
+		internalError(w, r)
+		return
+	}
+	p := &Paste{
+		ID:         id,
+		Title:      title,
+		Body:       []byte(body),
+		TTL:        ttl,
+		Normalized: cipher == "",
+		deleteHash: bodyHash([]byte(token)),
+	}
+	if cipher != "" {
+		p.Cipher, p.Compression = cipher, "none"
+	}
+	if !storePaste(w, r, p) {
+		return
+	}
+	stored = true
+
+	url := absoluteURL(r, p.URLPath())
+	w.Header().Set("Location", url)
+	w.Header().Set("X-Paste-Delete-Token", token)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, url)
+}
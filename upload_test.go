@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mintUploadURL asks uploadURLsHandler for an upload URL and returns its
+// path, /u/<grant>.
+func mintUploadURL(t *testing.T, form url.Values) string {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/api/upload-urls", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Authorization", "Bearer test-token")
+	w := httptest.NewRecorder()
+	uploadURLsHandler(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("minting upload URL: status %d: %s", w.Code, w.Body)
+	}
+	var resp struct{ URL string }
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(resp.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Path
+}
+
+func upload(path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	uploadHandler(w, r)
+	return w
+}
+
+func useUploads(t *testing.T) {
+	t.Helper()
+	useMemStore(t)
+	config.APITokens = []string{"test-token"}
+}
+
+func TestUploadCreatesPaste(t *testing.T) {
+	useUploads(t)
+	path := mintUploadURL(t, url.Values{"ttl": {"3h"}})
+
+	w := upload(path+"?title=build+log", "step 1\r\nstep 2\r\n")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	if w.Header().Get("X-Paste-Delete-Token") == "" {
+		t.Error("no delete token")
+	}
+	loc, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := strings.TrimPrefix(loc.Path, "/p/")
+	p, err := loadPaste(id)
+	if err != nil {
+		t.Fatalf("load %q: %v", id, err)
+	}
+	if p.Title != "build log" || string(p.Body) != "step 1\nstep 2\n" || p.TTL != "3h" {
+		t.Errorf("stored %q, %q with TTL %s", p.Title, p.Body, p.TTL)
+	}
+}
+
+func TestUploadReplayRejected(t *testing.T) {
+	useUploads(t)
+	path := mintUploadURL(t, nil)
+
+	if w := upload(path, "first"); w.Code != http.StatusCreated {
+		t.Fatalf("first upload: status %d: %s", w.Code, w.Body)
+	}
+	w := upload(path, "second")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("replayed upload: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+// A failed upload doesn't use up the grant.
+func TestUploadFailureKeepsGrant(t *testing.T) {
+	useUploads(t)
+	path := mintUploadURL(t, nil)
+
+	if w := upload(path, ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("empty upload: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := upload(path, "now with content"); w.Code != http.StatusCreated {
+		t.Errorf("retry: status %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+}
+
+func TestUploadOversize(t *testing.T) {
+	useUploads(t)
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"at limit", strings.Repeat("a", 99) + "\n", http.StatusCreated},
+		{"over limit", strings.Repeat("a", 100) + "\n", http.StatusRequestEntityTooLarge},
+		// Only the normalized size counts
+		{"fits once normalized", strings.Repeat("a\r\n", 50), http.StatusCreated},
+		{"far over limit", strings.Repeat("a", 10000), http.StatusRequestEntityTooLarge},
+		// Past twice the limit the read stops, so what was read can't be
+		// stored even if it would normalize to fit
+		{"CRLF past twice the limit", strings.Repeat("\r\n", 100) + "\r", http.StatusRequestEntityTooLarge},
+		{"trailing newlines past twice the limit", "a" + strings.Repeat("\n", 200), http.StatusRequestEntityTooLarge},
+		{"trailing newlines at twice the limit", "a" + strings.Repeat("\n", 199), http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := mintUploadURL(t, url.Values{"max_size": {"100"}})
+			w := upload(path, tt.body)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestUploadInvalidGrant(t *testing.T) {
+	useUploads(t)
+	path := mintUploadURL(t, nil)
+
+	expired := uploadGrant{Expires: time.Now().Add(-time.Minute), MaxSize: 100, TTL: "1h", Nonce: "abc"}
+	for name, p := range map[string]string{
+		"tampered": strings.Replace(path, ".", ".9", 1),
+		"expired":  "/u/" + expired.token(),
+		"garbage":  "/u/nonsense",
+	} {
+		if w := upload(p, "hello"); w.Code != http.StatusForbidden {
+			t.Errorf("%s: status %d, want %d", name, w.Code, http.StatusForbidden)
+		}
+	}
+}
+
+func TestUploadURLsNeedToken(t *testing.T) {
+	useUploads(t)
+	r := httptest.NewRequest(http.MethodPost, "/api/upload-urls", nil)
+	w := httptest.NewRecorder()
+	uploadURLsHandler(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
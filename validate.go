@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// validateBody applies the content rules shared by every creation path.
+// The returned error message is safe to show to the client.
+func validateBody(body string) error {
+	if !utf8.ValidString(body) {
+		return fmt.Errorf("Content must be valid UTF-8")
+	}
+
+	lines := 0
+	for rest := body; rest != ""; lines++ {
+		line, next, _ := strings.Cut(rest, "\n")
+		if config.MaxLineLength > 0 && len(line) > config.MaxLineLength {
+			return fmt.Errorf("Line %d too long (max %d bytes per line)", lines+1, config.MaxLineLength)
+		}
+		rest = next
+	}
+	if config.MaxLines > 0 && lines > config.MaxLines {
+		return fmt.Errorf("Too many lines (max %d)", config.MaxLines)
+	}
+	return nil
+}
+
+// Title character policies for TITLE_CHARS: any takes every valid UTF-8
+// title, printable refuses control characters and the bidirectional
+// formatting characters (such as U+202E RIGHT-TO-LEFT OVERRIDE) that make a
+// title display differently from what it says.
+const (
+	titleCharsAny       = "any"
+	titleCharsPrintable = "printable"
+)
+
+// validateTitle applies TITLE_CHARS to a valid UTF-8 title. The returned
+// error message is safe to show to the client.
+func validateTitle(title string) error {
+	if config.TitleChars != titleCharsPrintable {
+		return nil
+	}
+	for _, r := range title {
+		if !titleRune(r) {
+			return fmt.Errorf("Title must not contain control or bidirectional formatting characters (found %U)", r)
+		}
+	}
+	return nil
+}
+
+// titleRune reports whether r may appear in a printable title.
+func titleRune(r rune) bool {
+	return !unicode.IsControl(r) && !unicode.Is(unicode.Bidi_Control, r)
+}
+
+// longestLine returns the length in bytes of the longest line in body.
+func longestLine(body []byte) int {
+	longest := 0
+	for rest := string(body); rest != ""; {
+		line, next, _ := strings.Cut(rest, "\n")
+		longest = max(longest, len(line))
+		rest = next
+	}
+	return longest
+}
+
+// untitledLength is how much of the first line names an untitled paste.
+const untitledLength = 60
+
+// untitled names a paste created without a title after the first line of
+// body with something on it, shortened to untitledLength characters.
+// Bodies of only whitespace are "Untitled".
+func untitled(body string) string {
+	for line := range strings.Lines(body) {
+		if config.TitleChars == titleCharsPrintable {
+			line = strings.Map(func(r rune) rune {
+				if titleRune(r) {
+					return r
+				}
+				return -1
+			}, line)
+		}
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" {
+			continue
+		}
+		if utf8.RuneCountInString(line) > untitledLength {
+			line = string([]rune(line)[:untitledLength-1]) + "…"
+		}
+		return line
+	}
+	return "Untitled"
+}
+
+// maxTTL returns the longest TTL the policy allows for a body of the given
+// size. Bodies larger than every rule get the last rule's TTL. ok is false
+// when no policy is configured.
+func maxTTL(size int) (ttl string, ok bool) {
+	rules := config.TTLPolicy
+	if len(rules) == 0 {
+		return "", false
+	}
+	for _, rule := range rules {
+		if size <= rule.MaxBytes {
+			return rule.TTL, true
+		}
+	}
+	return rules[len(rules)-1].TTL, true
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// showInvisible renders body for the ?debug=1 view, with whitespace and
+// characters that don't print replaced by visible stand-ins: · for
+// spaces, → for tabs, ␍ and ↵ for line endings, control pictures for other
+// ASCII control characters, [U+XXXX] for invisible Unicode such as a BOM or
+// a zero-width space and [\xNN] for bytes that aren't UTF-8. Tabs and line
+// feeds are kept after their symbol so the layout doesn't change.
+func showInvisible(body []byte) string {
+	var b strings.Builder
+	for len(body) > 0 {
+		r, size := utf8.DecodeRune(body)
+		switch {
+		case r == utf8.RuneError && size == 1:
+			fmt.Fprintf(&b, `[\x%02x]`, body[0])
+		case r == ' ':
+			b.WriteString("·")
+		case r == '\t':
+			b.WriteString("→\t")
+		case r == '\n':
+			b.WriteString("↵\n")
+		case r < 0x20:
+			b.WriteRune(0x2400 + r)
+		case r == 0x7f:
+			b.WriteRune('␡')
+		case r > 0x7f && !unicode.IsPrint(r):
+			fmt.Fprintf(&b, "[U+%04X]", r)
+		default:
+			b.WriteRune(r)
+		}
+		body = body[size:]
+	}
+	return b.String()
+}
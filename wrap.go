@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// wrapCookie remembers whether the visitor wants long lines wrapped.
+const wrapCookie = "wrap"
+
+// requestWrap reports whether paste bodies should soft-wrap: ?wrap=1 or
+// ?wrap=0 if given, which is kept in a cookie for later pages, then the
+// cookie. Wrapping is on by default.
+func requestWrap(w http.ResponseWriter, r *http.Request) bool {
+	value := r.URL.Query().Get("wrap")
+	if value == "1" || value == "0" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     wrapCookie,
+			Value:    value,
+			Path:     sitePath("/"),
+			MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		return value == "1"
+	}
+	if c, err := r.Cookie(wrapCookie); err == nil {
+		return c.Value != "0"
+	}
+	return true
+}